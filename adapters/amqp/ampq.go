@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-runtime-sidecar/sidecar"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -13,6 +15,16 @@ type AMQPConfig struct {
 	URI      string `env:"AMQP_URI" default:""`
 	Exchange string `env:"AMQP_EXCHANGE" default:""`
 	Queue    string `env:"AMQP_QUEUE" default:""`
+
+	// json or protobuf, workers accept either.
+	WireEncoding string `env:"AMQP_WIRE_ENCODING" default:"json"`
+
+	// Failed messages are retried through a TTL queue per attempt, doubling
+	// the delay each time up to RetryMaxDelay. 0 attempts requeues
+	// immediately.
+	RetryAttempts int           `env:"AMQP_RETRY_ATTEMPTS" default:"3"`
+	RetryDelay    time.Duration `env:"AMQP_RETRY_DELAY" default:"5s"`
+	RetryMaxDelay time.Duration `env:"AMQP_RETRY_MAX_DELAY" default:"5m"`
 }
 
 type Connector struct {
@@ -81,6 +93,14 @@ global/{globalType}
 // a config of /foo.v1.FooService/FooMethod
 // will become service.foo/v1/FooService.FooMethod
 
+Replies are addressed to the app which sent the request rather than the
+service:
+
+// a reply to env/app on foo.v1.FooReplyTopic/FooReply becomes
+// reply.env.app.foo/v1/FooReplyTopic.FooReply
+
+// which the requesting app subscribes to as reply.env.app.#
+
 */
 
 func messageToRoutingKey(message *messaging_pb.Message) string {
@@ -92,5 +112,9 @@ func messageToRoutingKey(message *messaging_pb.Message) string {
 
 	serviceShash := strings.ReplaceAll(message.GrpcService, ".", "/")
 
+	if env, app, ok := sidecar.ReplyDestination(message); ok {
+		return fmt.Sprintf("reply.%s.%s.%s.%s", env, app, serviceShash, message.GrpcMethod)
+	}
+
 	return fmt.Sprintf("service.%s.%s", serviceShash, message.GrpcMethod)
 }
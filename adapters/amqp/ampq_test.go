@@ -0,0 +1,36 @@
+package amqp
+
+import (
+	"testing"
+
+	"github.com/pentops/j5/gen/j5/messaging/v1/messaging_j5pb"
+	"github.com/pentops/o5-messaging/o5msg"
+	"github.com/pentops/o5-runtime-sidecar/testproto/gen/test/v1/test_tpb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageToRoutingKey(t *testing.T) {
+	request, err := o5msg.WrapMessage(&test_tpb.TestReqResRequestMessage{
+		Request: &messaging_j5pb.RequestMetadata{},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	request.GetRequest().ReplyTo = "prod/requester"
+	assert.Equal(t, "service.test/v1/topic/TestReqResRequestTopic.TestReqResRequest", messageToRoutingKey(request))
+
+	reply, err := o5msg.WrapMessage(&test_tpb.TestReqResReplyMessage{
+		Request: &messaging_j5pb.RequestMetadata{
+			ReplyTo: "prod/requester",
+		},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, "reply.prod.requester.test/v1/topic/TestReqResReplyTopic.TestReqResReply", messageToRoutingKey(reply))
+}
+
+func TestNewPublisherWireEncoding(t *testing.T) {
+	_, err := NewPublisher(AMQPConfig{WireEncoding: "xml"}, "test")
+	assert.Error(t, err)
+}
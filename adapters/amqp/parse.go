@@ -0,0 +1,115 @@
+package amqp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/wire"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	serviceHeader     = "grpc-service"
+	grpcMessageHeader = "grpc-message"
+)
+
+var messageIDNamespace = uuid.MustParse("0B2B7BB5-4C6F-4D5B-9F3C-6A3A2E6E5B1D")
+
+// parseDelivery converts an AMQP delivery to an o5 message. Deliveries
+// published by another sidecar carry the whole message, possibly with a
+// compressed body, deliveries from other publishers are either addressed to a
+// gRPC service through the grpc-service header, or wrapped as a raw message.
+func parseDelivery(delivery amqp.Delivery) (*messaging_pb.Message, error) {
+	if wire.IsO5Message(delivery.ContentType) {
+		msg, err := wire.Unmarshal(delivery.ContentType, delivery.Body)
+		if err != nil {
+			return nil, err
+		}
+		if err := wire.Decompress(msg); err != nil {
+			return nil, fmt.Errorf("failed to decompress message: %w", err)
+		}
+		return msg, nil
+	}
+
+	if serviceName, ok := delivery.Headers[serviceHeader].(string); ok {
+		return parseServiceMessage(delivery, serviceName)
+	}
+
+	msg := &messaging_pb.Message{
+		MessageId: deliveryMessageID(delivery),
+		Body: &messaging_pb.Any{
+			Encoding: messaging_pb.WireEncoding_RAW,
+			Value:    delivery.Body,
+		},
+		GrpcService:      "o5.messaging.v1.topic.RawMessageTopic",
+		GrpcMethod:       "Raw",
+		DestinationTopic: routingKey(delivery),
+	}
+	if !delivery.Timestamp.IsZero() {
+		msg.Timestamp = timestamppb.New(delivery.Timestamp)
+	} else {
+		msg.Timestamp = timestamppb.Now()
+	}
+	return msg, nil
+}
+
+func parseServiceMessage(delivery amqp.Delivery, serviceName string) (*messaging_pb.Message, error) {
+	parts := strings.Split(serviceName, "/")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid service name: %s", serviceName)
+	}
+
+	var encoding messaging_pb.WireEncoding
+	switch delivery.ContentType {
+	case "application/json":
+		encoding = messaging_pb.WireEncoding_PROTOJSON
+	case "application/protobuf":
+		encoding = messaging_pb.WireEncoding_UNSPECIFIED
+	case "":
+		if looksLikeJSON(delivery.Body) {
+			encoding = messaging_pb.WireEncoding_PROTOJSON
+		} else {
+			encoding = messaging_pb.WireEncoding_UNSPECIFIED
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content type: %s", delivery.ContentType)
+	}
+
+	var typeURL string
+	if grpcMessage, ok := delivery.Headers[grpcMessageHeader].(string); ok {
+		typeURL = fmt.Sprintf("type.googleapis.com/%s", grpcMessage)
+	}
+
+	return &messaging_pb.Message{
+		MessageId: deliveryMessageID(delivery),
+		Body: &messaging_pb.Any{
+			Encoding: encoding,
+			Value:    delivery.Body,
+			TypeUrl:  typeURL,
+		},
+		GrpcService: parts[1],
+		GrpcMethod:  parts[2],
+	}, nil
+}
+
+func deliveryMessageID(delivery amqp.Delivery) string {
+	if delivery.MessageId == "" {
+		return uuid.New().String()
+	}
+	return uuid.NewSHA1(messageIDNamespace, []byte(delivery.MessageId)).String()
+}
+
+func looksLikeJSON(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return false
+	}
+	firstChar := body[0]
+	lastChar := body[len(body)-1]
+
+	return (firstChar == '{' && lastChar == '}') || (firstChar == '[' && lastChar == ']')
+}
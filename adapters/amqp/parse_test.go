@@ -0,0 +1,158 @@
+package amqp
+
+import (
+	"testing"
+
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/wire"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseDelivery(t *testing.T) {
+
+	for _, tc := range []struct {
+		name  string
+		input amqp.Delivery
+		want  *messaging_pb.Message
+	}{{
+		name: "o5-message",
+		input: amqp.Delivery{
+			ContentType: wire.O5MessageContentType,
+			Body: []byte(`{
+				"messageId": "6f4ad4b4-7c8e-4b2f-9a5e-0d0b1c2a3f4e",
+				"grpcService": "test.v1.FooTopic",
+				"grpcMethod": "Foo",
+				"body": {
+					"typeUrl": "type.googleapis.com/test.v1.FooMessage",
+					"value": "Rk9PQkFS"
+				}
+			}`),
+		},
+		want: &messaging_pb.Message{
+			MessageId:   "6f4ad4b4-7c8e-4b2f-9a5e-0d0b1c2a3f4e",
+			GrpcService: "test.v1.FooTopic",
+			GrpcMethod:  "Foo",
+			Body: &messaging_pb.Any{
+				Encoding: messaging_pb.WireEncoding_UNSPECIFIED,
+				TypeUrl:  "type.googleapis.com/test.v1.FooMessage",
+				Value:    []byte(`FOOBAR`),
+			},
+		},
+	}, {
+		name: "service-json",
+		input: amqp.Delivery{
+			ContentType: "application/json",
+			MessageId:   "asdf",
+			Headers: amqp.Table{
+				serviceHeader:     "/test.v1.FooTopic/Foo",
+				grpcMessageHeader: "test.v1.FooMessage",
+			},
+			Body: []byte(`{"name": "test", "id": "asdf"}`),
+		},
+		want: &messaging_pb.Message{
+			GrpcService: "test.v1.FooTopic",
+			GrpcMethod:  "Foo",
+			Body: &messaging_pb.Any{
+				Encoding: messaging_pb.WireEncoding_PROTOJSON,
+				TypeUrl:  "type.googleapis.com/test.v1.FooMessage",
+				Value:    []byte(`{"name": "test", "id": "asdf"}`),
+			},
+		},
+	}, {
+		name: "service-protobuf",
+		input: amqp.Delivery{
+			ContentType: "application/protobuf",
+			Headers: amqp.Table{
+				serviceHeader: "/test.v1.FooTopic/Foo",
+			},
+			Body: []byte{0x0a, 0x04, 0x74, 0x65, 0x73, 0x74},
+		},
+		want: &messaging_pb.Message{
+			GrpcService: "test.v1.FooTopic",
+			GrpcMethod:  "Foo",
+			Body: &messaging_pb.Any{
+				Encoding: messaging_pb.WireEncoding_UNSPECIFIED,
+				Value:    []byte{0x0a, 0x04, 0x74, 0x65, 0x73, 0x74},
+			},
+		},
+	}, {
+		name: "raw",
+		input: amqp.Delivery{
+			ContentType: "text/plain",
+			RoutingKey:  "external.thing",
+			Body:        []byte(`Hello World!`),
+		},
+		want: &messaging_pb.Message{
+			GrpcService:      "o5.messaging.v1.topic.RawMessageTopic",
+			GrpcMethod:       "Raw",
+			DestinationTopic: "external.thing",
+			Body: &messaging_pb.Any{
+				Encoding: messaging_pb.WireEncoding_RAW,
+				Value:    []byte(`Hello World!`),
+			},
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := parseDelivery(tc.input)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			assert.Equal(t, tc.want.Body.Encoding.String(), msg.Body.Encoding.String(), "Encoding")
+			assert.Equal(t, tc.want.Body.TypeUrl, msg.Body.TypeUrl)
+			assert.Equal(t, tc.want.GrpcService, msg.GrpcService)
+			assert.Equal(t, tc.want.GrpcMethod, msg.GrpcMethod)
+			assert.Equal(t, tc.want.DestinationTopic, msg.DestinationTopic)
+			assert.Equal(t, string(tc.want.Body.Value), string(msg.Body.Value))
+			assert.NotEmpty(t, msg.MessageId)
+
+			if tc.want.MessageId != "" {
+				assert.Equal(t, tc.want.MessageId, msg.MessageId)
+			}
+		})
+	}
+}
+
+func TestParseProtobufDelivery(t *testing.T) {
+	want := &messaging_pb.Message{
+		MessageId:   "6f4ad4b4-7c8e-4b2f-9a5e-0d0b1c2a3f4e",
+		GrpcService: "test.v1.FooTopic",
+		GrpcMethod:  "Foo",
+		Body: &messaging_pb.Any{
+			TypeUrl: "type.googleapis.com/test.v1.FooMessage",
+			Value:   []byte(`FOOBAR`),
+		},
+	}
+
+	body, err := wire.Marshal(wire.O5MessageProtobufContentType, want)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	msg, err := parseDelivery(amqp.Delivery{
+		ContentType: wire.O5MessageProtobufContentType,
+		Body:        body,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.True(t, proto.Equal(want, msg))
+}
+
+func TestParseDeliveryErrors(t *testing.T) {
+	_, err := parseDelivery(amqp.Delivery{
+		ContentType: wire.O5MessageContentType,
+		Body:        []byte(`not json`),
+	})
+	assert.Error(t, err)
+
+	_, err = parseDelivery(amqp.Delivery{
+		ContentType: "application/xml",
+		Headers: amqp.Table{
+			serviceHeader: "/test.v1.FooTopic/Foo",
+		},
+	})
+	assert.Error(t, err)
+}
@@ -5,15 +5,16 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/pentops/j5/lib/j5codec"
 	"github.com/pentops/log.go/log"
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/wire"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 type Publisher struct {
-	connector *Connector
-	exchange  string
+	connector   *Connector
+	exchange    string
+	contentType string
 }
 
 func NewPublisher(config AMQPConfig, envName string) (*Publisher, error) {
@@ -23,9 +24,15 @@ func NewPublisher(config AMQPConfig, envName string) (*Publisher, error) {
 	if exchange == "" {
 		exchange = fmt.Sprintf("o5.%s", envName)
 	}
+	contentType, err := wire.ContentType(config.WireEncoding)
+	if err != nil {
+		return nil, fmt.Errorf("AMQP_WIRE_ENCODING: %w", err)
+	}
+
 	cw := &Publisher{
-		connector: connector,
-		exchange:  exchange,
+		connector:   connector,
+		exchange:    exchange,
+		contentType: contentType,
 	}
 	return cw, nil
 }
@@ -33,8 +40,7 @@ func NewPublisher(config AMQPConfig, envName string) (*Publisher, error) {
 func (p *Publisher) Publish(ctx context.Context, message *messaging_pb.Message) error {
 	routingKey := messageToRoutingKey(message)
 
-	// eventbridge requires JSON bodies.
-	detail, err := j5codec.Global.ProtoToJSON(message.ProtoReflect())
+	body, err := wire.Marshal(p.contentType, message)
 	if err != nil {
 		return err
 	}
@@ -51,8 +57,8 @@ func (p *Publisher) Publish(ctx context.Context, message *messaging_pb.Message)
 		false,      // mandatory
 		false,      // immediate
 		amqp.Publishing{
-			ContentType: "application/o5-message",
-			Body:        detail,
+			ContentType: p.contentType,
+			Body:        body,
 		},
 	)
 
@@ -0,0 +1,195 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pentops/log.go/log"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	retryAttemptHeader       = "x-o5-retry-attempt"
+	originalRoutingKeyHeader = "x-o5-routing-key"
+)
+
+type retryTier struct {
+	queueName string
+	delay     time.Duration
+}
+
+// retryTiers builds the exponential backoff tiers for the configured queue.
+// Each tier is a TTL queue with no consumers which dead-letters back into the
+// source queue through the default exchange once the delay has elapsed.
+func retryTiers(config AMQPConfig) []retryTier {
+	if config.RetryAttempts <= 0 || config.RetryDelay <= 0 {
+		return nil
+	}
+
+	tiers := make([]retryTier, 0, config.RetryAttempts)
+	delay := config.RetryDelay
+	for range config.RetryAttempts {
+		if config.RetryMaxDelay > 0 && delay > config.RetryMaxDelay {
+			delay = config.RetryMaxDelay
+		}
+		tiers = append(tiers, retryTier{
+			// The delay is part of the name as the TTL can't be changed on an
+			// existing queue.
+			queueName: fmt.Sprintf("%s.retry.%d", config.Queue, delay.Milliseconds()),
+			delay:     delay,
+		})
+		delay *= 2
+	}
+	return tiers
+}
+
+func (ww *Worker) declareRetryQueues(ch *amqp.Channel) error {
+	for _, tier := range ww.retryTiers {
+		_, err := ch.QueueDeclare(
+			tier.queueName,
+			true,  // durable
+			false, // autoDelete
+			false, // exclusive
+			false, // noWait
+			amqp.Table{
+				"x-message-ttl":             tier.delay.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": ww.queueName,
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("declaring retry queue %s: %w", tier.queueName, err)
+		}
+	}
+	return nil
+}
+
+// retryAttempt returns the number of delayed retries the delivery has already
+// been through.
+func retryAttempt(delivery amqp.Delivery) int {
+	switch val := delivery.Headers[retryAttemptHeader].(type) {
+	case int32:
+		return int(val)
+	case int64:
+		return int(val)
+	case int:
+		return val
+	default:
+		return 0
+	}
+}
+
+func routingKey(delivery amqp.Delivery) string {
+	if original, ok := delivery.Headers[originalRoutingKeyHeader].(string); ok {
+		return original
+	}
+	return delivery.RoutingKey
+}
+
+func (ww *Worker) retryMessage(ctx context.Context, delivery amqp.Delivery, msg *messaging_pb.Message, handlerError error) error {
+	attempt := retryAttempt(delivery)
+	if attempt < len(ww.retryTiers) {
+		if err := ww.scheduleRetry(ctx, delivery, ww.retryTiers[attempt], attempt+1); err != nil {
+			return requeue(delivery, err)
+		}
+		return delivery.Ack(false)
+	}
+
+	if ww.deadLetterHandler != nil {
+		log.WithField(ctx, "attempts", attempt).Info("Message Handler: Killing after final retry")
+		return ww.killMessage(ctx, delivery, msg, handlerError)
+	}
+
+	return ww.holdMessage(ctx, delivery)
+}
+
+// holdMessage keeps a message which can't be handled or dead-lettered,
+// rather than dropping it. It is held in the slowest retry tier, or requeued
+// when there are no tiers.
+func (ww *Worker) holdMessage(ctx context.Context, delivery amqp.Delivery) error {
+	if len(ww.retryTiers) == 0 {
+		log.Info(ctx, "Message Handler: Requeuing message")
+		return delivery.Nack(false, true)
+	}
+
+	if err := ww.scheduleRetry(ctx, delivery, ww.retryTiers[len(ww.retryTiers)-1], retryAttempt(delivery)); err != nil {
+		return requeue(delivery, err)
+	}
+	return delivery.Ack(false)
+}
+
+// requeue returns the delivery to the queue when the retry could not be
+// scheduled, rather than leaving it unacknowledged.
+func requeue(delivery amqp.Delivery, err error) error {
+	if nackErr := delivery.Nack(false, true); nackErr != nil {
+		return errors.Join(err, nackErr)
+	}
+	return err
+}
+
+// retryPublisher publishes to a retry queue, returning once the broker has
+// confirmed the message.
+type retryPublisher interface {
+	PublishConfirmed(ctx context.Context, queueName string, msg amqp.Publishing) error
+}
+
+// scheduleRetry publishes the delivery to the tier's queue, returning once the
+// broker has confirmed it, so that the original is only acked once the copy
+// is safe.
+func (ww *Worker) scheduleRetry(ctx context.Context, delivery amqp.Delivery, tier retryTier, attempt int) error {
+	log.WithFields(ctx, "attempt", attempt, "delay", tier.delay.String()).Info("Message Handler: Scheduling retry")
+
+	headers := amqp.Table{}
+	for k, v := range delivery.Headers {
+		headers[k] = v
+	}
+	headers[retryAttemptHeader] = int64(attempt)
+	headers[originalRoutingKeyHeader] = routingKey(delivery)
+
+	return ww.retries.PublishConfirmed(ctx, tier.queueName, amqp.Publishing{
+		ContentType:  delivery.ContentType,
+		MessageId:    delivery.MessageId,
+		Timestamp:    delivery.Timestamp,
+		Headers:      headers,
+		DeliveryMode: amqp.Persistent,
+		Body:         delivery.Body,
+	})
+}
+
+// PublishConfirmed publishes directly to the queue through the default
+// exchange, waiting for the broker to confirm it.
+func (c *Connector) PublishConfirmed(ctx context.Context, queueName string, msg amqp.Publishing) error {
+	ch, err := c.Channel()
+	if err != nil {
+		return err
+	}
+
+	// The channel may have been re-opened since the last retry, putting it in
+	// confirm mode again has no effect.
+	if err := ch.Confirm(false); err != nil {
+		return fmt.Errorf("enabling publisher confirms: %w", err)
+	}
+
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx,
+		"",        // default exchange routes directly to the queue
+		queueName, // routing key
+		false,     // mandatory
+		false,     // immediate
+		msg,
+	)
+	if err != nil {
+		return fmt.Errorf("publishing retry: %w", err)
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("waiting for retry confirmation: %w", err)
+	}
+	if !acked {
+		return fmt.Errorf("retry to %s was not accepted by the broker", queueName)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_tpb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/wire"
+	"github.com/pentops/o5-runtime-sidecar/apps/queueworker/messaging"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryTiers(t *testing.T) {
+	tiers := retryTiers(AMQPConfig{
+		Queue:         "app",
+		RetryAttempts: 4,
+		RetryDelay:    time.Second,
+		RetryMaxDelay: 5 * time.Second,
+	})
+
+	names := make([]string, 0, len(tiers))
+	for _, tier := range tiers {
+		names = append(names, tier.queueName)
+	}
+	assert.Equal(t, []string{
+		"app.retry.1000",
+		"app.retry.2000",
+		"app.retry.4000",
+		"app.retry.5000",
+	}, names)
+
+	assert.Empty(t, retryTiers(AMQPConfig{Queue: "app"}))
+}
+
+func TestRetryAttempt(t *testing.T) {
+	assert.Equal(t, 0, retryAttempt(amqp.Delivery{}))
+	assert.Equal(t, 2, retryAttempt(amqp.Delivery{
+		Headers: amqp.Table{retryAttemptHeader: int32(2)},
+	}))
+	assert.Equal(t, 3, retryAttempt(amqp.Delivery{
+		Headers: amqp.Table{retryAttemptHeader: int64(3)},
+	}))
+}
+
+// fakeAcknowledger records how deliveries are settled.
+type fakeAcknowledger struct {
+	events *[]string
+}
+
+func (fa fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	*fa.events = append(*fa.events, "ack")
+	return nil
+}
+
+func (fa fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	*fa.events = append(*fa.events, fmt.Sprintf("nack requeue=%t", requeue))
+	return nil
+}
+
+func (fa fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	*fa.events = append(*fa.events, fmt.Sprintf("reject requeue=%t", requeue))
+	return nil
+}
+
+// fakeRetries stands in for the channel, confirming each publish unless err
+// is set.
+type fakeRetries struct {
+	events    *[]string
+	err       error
+	published []amqp.Publishing
+}
+
+func (fr *fakeRetries) PublishConfirmed(ctx context.Context, queueName string, msg amqp.Publishing) error {
+	if fr.err != nil {
+		*fr.events = append(*fr.events, "publish "+queueName+" failed")
+		return fr.err
+	}
+	*fr.events = append(*fr.events, "publish "+queueName+" confirmed")
+	fr.published = append(fr.published, msg)
+	return nil
+}
+
+type deadLetters []*messaging_tpb.DeadMessage
+
+func (dl *deadLetters) DeadMessage(ctx context.Context, death *messaging_tpb.DeadMessage) error {
+	*dl = append(*dl, death)
+	return nil
+}
+
+func testRetryWorker(retries *fakeRetries, dlh messaging.DeadLetterHandler) *Worker {
+	return &Worker{
+		queueName: "app",
+		retries:   retries,
+		retryTiers: retryTiers(AMQPConfig{
+			Queue:         "app",
+			RetryAttempts: 2,
+			RetryDelay:    time.Second,
+		}),
+		handler: messaging.HandlerFunc(func(ctx context.Context, msg *messaging_pb.Message) error {
+			return errors.New("handler failed")
+		}),
+		deadLetterHandler: dlh,
+	}
+}
+
+// testFailedDelivery is a delivery which has already been through the given
+// number of retries.
+func testFailedDelivery(events *[]string, attempt int) amqp.Delivery {
+	delivery := amqp.Delivery{
+		Acknowledger: fakeAcknowledger{events: events},
+		DeliveryTag:  1,
+		ContentType:  wire.O5MessageContentType,
+		RoutingKey:   "service.test/v1/FooTopic.Foo",
+		Headers:      amqp.Table{},
+		Body: []byte(`{
+			"messageId": "6f4ad4b4-7c8e-4b2f-9a5e-0d0b1c2a3f4e",
+			"grpcService": "test.v1.FooTopic",
+			"grpcMethod": "Foo",
+			"body": {
+				"typeUrl": "type.googleapis.com/test.v1.FooMessage",
+				"value": "Rk9PQkFS"
+			}
+		}`),
+	}
+	if attempt > 0 {
+		delivery.Headers[retryAttemptHeader] = int64(attempt)
+		delivery.Headers[originalRoutingKeyHeader] = delivery.RoutingKey
+		delivery.RoutingKey = "app"
+	}
+	return delivery
+}
+
+func TestRetryAcksAfterConfirm(t *testing.T) {
+	events := []string{}
+	retries := &fakeRetries{events: &events}
+	ww := testRetryWorker(retries, &deadLetters{})
+
+	require.NoError(t, ww.handleDelivery(t.Context(), testFailedDelivery(&events, 0)))
+	assert.Equal(t, []string{
+		"publish app.retry.1000 confirmed",
+		"ack",
+	}, events)
+
+	require.Len(t, retries.published, 1)
+	published := retries.published[0]
+	assert.Equal(t, int64(1), published.Headers[retryAttemptHeader])
+	assert.Equal(t, "service.test/v1/FooTopic.Foo", published.Headers[originalRoutingKeyHeader])
+	assert.Equal(t, amqp.Persistent, published.DeliveryMode)
+}
+
+func TestRetryRequeuesWhenNotConfirmed(t *testing.T) {
+	events := []string{}
+	retries := &fakeRetries{events: &events, err: errors.New("nacked by broker")}
+	ww := testRetryWorker(retries, &deadLetters{})
+
+	err := ww.handleDelivery(t.Context(), testFailedDelivery(&events, 1))
+	assert.Error(t, err, "restarts the consumer")
+	assert.Equal(t, []string{
+		"publish app.retry.2000 failed",
+		"nack requeue=true",
+	}, events)
+}
+
+func TestRetryDeadLettersAfterFinalTier(t *testing.T) {
+	events := []string{}
+	retries := &fakeRetries{events: &events}
+	dead := &deadLetters{}
+	ww := testRetryWorker(retries, dead)
+
+	require.NoError(t, ww.handleDelivery(t.Context(), testFailedDelivery(&events, 2)))
+	assert.Equal(t, []string{"ack"}, events, "not retried again")
+
+	require.Len(t, *dead, 1)
+	death := (*dead)[0]
+	assert.Equal(t, "handler failed", death.Problem.GetUnhandledError().Error)
+	assert.Equal(t, "6f4ad4b4-7c8e-4b2f-9a5e-0d0b1c2a3f4e", death.Message.MessageId)
+	assert.Equal(t, "AMQP", death.Infra.Type)
+}
+
+func TestRetryHoldsInFinalTierWithoutDeadLetters(t *testing.T) {
+	events := []string{}
+	retries := &fakeRetries{events: &events}
+	ww := testRetryWorker(retries, nil)
+
+	require.NoError(t, ww.handleDelivery(t.Context(), testFailedDelivery(&events, 2)))
+	assert.Equal(t, []string{
+		"publish app.retry.2000 confirmed",
+		"ack",
+	}, events)
+
+	require.Len(t, retries.published, 1)
+	assert.Equal(t, int64(2), retries.published[0].Headers[retryAttemptHeader], "stays on the final tier")
+	assert.Equal(t, "service.test/v1/FooTopic.Foo", retries.published[0].Headers[originalRoutingKeyHeader])
+}
+
+func TestUnparsableHeldWithoutDeadLetters(t *testing.T) {
+	events := []string{}
+	retries := &fakeRetries{events: &events}
+	ww := testRetryWorker(retries, nil)
+
+	delivery := testFailedDelivery(&events, 0)
+	delivery.Body = []byte("not a message")
+	require.NoError(t, ww.handleDelivery(t.Context(), delivery))
+	assert.Equal(t, []string{
+		"publish app.retry.2000 confirmed",
+		"ack",
+	}, events, "held in the final tier rather than dropped")
+
+	events = events[:0]
+	ww.retryTiers = nil
+	require.NoError(t, ww.handleDelivery(t.Context(), delivery))
+	assert.Equal(t, []string{"nack requeue=true"}, events, "requeued without retry tiers")
+}
@@ -6,10 +6,10 @@ import (
 	"fmt"
 
 	"github.com/google/uuid"
-	"github.com/pentops/j5/lib/j5codec"
 	"github.com/pentops/log.go/log"
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_tpb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/tracing"
 	"github.com/pentops/o5-runtime-sidecar/apps/queueworker/messaging"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -19,24 +19,38 @@ const RawMessageName = "/o5.messaging.v1.topic.RawMessageTopic/Raw"
 type Worker struct {
 	queueName         string
 	connector         *Connector
+	retries           retryPublisher
 	handler           messaging.Handler
 	deadLetterHandler messaging.DeadLetterHandler
+	retryTiers        []retryTier
+	readiness         messaging.Readiness
 }
 
+// errNotReady stops consuming until the app is ready again
+var errNotReady = errors.New("app not ready")
+
 func NewWorker(config AMQPConfig, router messaging.Handler, deadLetter messaging.DeadLetterHandler) (*Worker, error) {
 
 	conn := NewConnector(config)
 
 	sub := &Worker{
 		connector:         conn,
+		retries:           conn,
 		queueName:         config.Queue,
 		handler:           router,
 		deadLetterHandler: deadLetter,
+		retryTiers:        retryTiers(config),
 	}
 	return sub, nil
 
 }
 
+// SetReadiness pauses consuming while the app is not ready, rather than
+// holding deliveries unacked until the broker's consumer_timeout.
+func (ww *Worker) SetReadiness(readiness messaging.Readiness) {
+	ww.readiness = readiness
+}
+
 func (ww *Worker) Run(ctx context.Context) error {
 
 	for {
@@ -44,16 +58,29 @@ func (ww *Worker) Run(ctx context.Context) error {
 		if errors.Is(err, context.Canceled) {
 			return nil
 		}
+		if errors.Is(err, errNotReady) {
+			continue
+		}
 		log.WithError(ctx, err).Error("Worker: Error in run loop, restarting")
 	}
 }
 
 func (ww *Worker) runLoopOnce(ctx context.Context) error {
+	if ww.readiness != nil {
+		if err := ww.readiness.WaitReady(ctx); err != nil {
+			return err
+		}
+	}
+
 	ch, err := ww.connector.Channel()
 	if err != nil {
 		return err
 	}
 
+	if err := ww.declareRetryQueues(ch); err != nil {
+		return err
+	}
+
 	delivery, err := ch.ConsumeWithContext(ctx,
 		ww.queueName, // queue
 		"",           // consumer
@@ -68,6 +95,18 @@ func (ww *Worker) runLoopOnce(ctx context.Context) error {
 	}
 
 	for msg := range delivery {
+		if ww.readiness != nil {
+			if err := ww.readiness.Ready(); err != nil {
+				// Closing the channel requeues this and any prefetched
+				// deliveries, consuming resumes once the app is ready
+				log.WithError(ctx, err).Info("Worker: Pausing until the app is ready")
+				if err := ch.Close(); err != nil {
+					return err
+				}
+				return errNotReady
+			}
+		}
+
 		err = ww.handleDelivery(ctx, msg)
 		if err != nil {
 			return err
@@ -77,26 +116,30 @@ func (ww *Worker) runLoopOnce(ctx context.Context) error {
 }
 
 func (ww *Worker) handleDelivery(ctx context.Context, delivery amqp.Delivery) error {
-	routingKey := delivery.RoutingKey
-	ctx = log.WithFields(ctx, "routing_key", routingKey)
+	ctx = log.WithFields(ctx, "routing_key", routingKey(delivery))
 	log.Info(ctx, "Message Handler: Received message")
 	count, ok := delivery.Headers["x-delivery-count"].(int64)
 	if !ok {
 		count = 0
 	}
 
-	if delivery.ContentType != "application/o5-message" {
-		return fmt.Errorf("invalid content type: %q", delivery.ContentType)
-	}
-
 	ctx = log.WithFields(ctx, "deliveryCount", count)
 
-	msg := &messaging_pb.Message{}
-	err := j5codec.Global.JSONToProto(delivery.Body, msg.ProtoReflect())
+	msg, err := parseDelivery(delivery)
 	if err != nil {
-		return err
+		log.WithFields(ctx, map[string]any{
+			"error":       err.Error(),
+			"deliveryTag": delivery.DeliveryTag,
+		}).Error("Message Handler: Failed to parse message")
+		if ww.deadLetterHandler == nil {
+			return ww.holdMessage(ctx, delivery)
+		}
+		return ww.killMessage(ctx, delivery, nil, err)
 	}
 
+	ctx, span := tracing.StartConsumer(ctx, msg)
+	defer span.End()
+
 	handlerError := ww.handler.HandleMessage(ctx, msg)
 	if handlerError == nil { // LOGIC INVERSION
 		err = delivery.Ack(false)
@@ -105,7 +148,12 @@ func (ww *Worker) handleDelivery(ctx context.Context, delivery amqp.Delivery) er
 		}
 		return nil
 	}
+	tracing.SetError(span, handlerError)
 	log.WithError(ctx, handlerError).Error("Message Handler: Error")
+	if len(ww.retryTiers) > 0 {
+		return ww.retryMessage(ctx, delivery, msg, handlerError)
+	}
+
 	if count >= 3 && ww.deadLetterHandler != nil {
 		log.Info(ctx, "Message Handler: Killing after 3 attempts")
 		err = ww.killMessage(ctx, delivery, msg, handlerError)
@@ -155,7 +203,7 @@ func (ww *Worker) killMessage(ctx context.Context, delivery amqp.Delivery, msg *
 		Problem: problem,
 		Message: msg,
 		Infra: &messaging_tpb.Infra{
-			Type:     "SQS",
+			Type:     "AMQP",
 			Metadata: meta,
 		},
 	}
@@ -0,0 +1,224 @@
+// Package envelope encrypts message bodies with a data key, which is itself
+// encrypted by a KeyProvider and carried in the message headers.
+package envelope
+
+import (
+	"context"
+	"crypto/cipher"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/wire"
+)
+
+const (
+	ProviderKMS    = "kms"
+	ProviderStatic = "static"
+
+	algorithmAES256GCM = "aes-256-gcm"
+)
+
+type EncryptionConfig struct {
+	// kms or static, empty disables encryption and decryption
+	KeyProvider string `env:"MESSAGE_ENCRYPTION_PROVIDER" default:""`
+
+	// The key to encrypt published messages with, a KMS key ARN or alias, or
+	// a static key ID. When empty, messages are only decrypted.
+	KeyID string `env:"MESSAGE_ENCRYPTION_KEY_ID" default:""`
+
+	// id:base64 AES-256 keys for the static provider
+	StaticKeys []string `env:"MESSAGE_ENCRYPTION_STATIC_KEYS" default:""`
+
+	// gRPC services whose messages are encrypted, empty encrypts all
+	Services []string `env:"MESSAGE_ENCRYPTION_SERVICES" default:""`
+
+	// How long a data key is reused for before generating a new one
+	DataKeyTTL time.Duration `env:"MESSAGE_ENCRYPTION_DATA_KEY_TTL" default:"5m"`
+}
+
+type dataKey struct {
+	aead      cipher.AEAD
+	encrypted string
+	expires   time.Time
+}
+
+// Encrypter encrypts message bodies under a single key.
+type Encrypter struct {
+	provider KeyProvider
+	keyID    string
+	services map[string]bool
+	ttl      time.Duration
+
+	lock    sync.Mutex
+	current *dataKey
+}
+
+func NewEncrypter(provider KeyProvider, config EncryptionConfig) (*Encrypter, error) {
+	if config.KeyID == "" {
+		return nil, fmt.Errorf("missing $MESSAGE_ENCRYPTION_KEY_ID")
+	}
+
+	var services map[string]bool
+	if len(config.Services) > 0 {
+		services = make(map[string]bool, len(config.Services))
+		for _, service := range config.Services {
+			services[service] = true
+		}
+	}
+
+	return &Encrypter{
+		provider: provider,
+		keyID:    config.KeyID,
+		services: services,
+		ttl:      config.DataKeyTTL,
+	}, nil
+}
+
+// dataKey returns the current data key, generating a new one once it expires.
+// Reusing keys keeps the provider, e.g. KMS, out of the path of most messages.
+func (ee *Encrypter) dataKey(ctx context.Context) (*dataKey, error) {
+	ee.lock.Lock()
+	defer ee.lock.Unlock()
+
+	if ee.current != nil && time.Now().Before(ee.current.expires) {
+		return ee.current, nil
+	}
+
+	plaintext, encrypted, err := ee.provider.GenerateDataKey(ctx, ee.keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("data key: %w", err)
+	}
+
+	ee.current = &dataKey{
+		aead:      aead,
+		encrypted: base64.StdEncoding.EncodeToString(encrypted),
+		expires:   time.Now().Add(ee.ttl),
+	}
+	return ee.current, nil
+}
+
+// Encrypt replaces the body value with its encrypted form, and sets the
+// headers required to decrypt it. Raw bodies, messages for other services and
+// already encrypted messages are left as they are.
+func (ee *Encrypter) Encrypt(ctx context.Context, msg *messaging_pb.Message) error {
+	if msg.Body == nil || msg.Body.Encoding == messaging_pb.WireEncoding_RAW || wire.IsEncrypted(msg) {
+		return nil
+	}
+
+	if ee.services != nil && !ee.services[msg.GrpcService] {
+		return nil
+	}
+
+	key, err := ee.dataKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := seal(key.aead, msg.Body.Value, []byte(msg.Body.TypeUrl))
+	if err != nil {
+		return err
+	}
+
+	if msg.Headers == nil {
+		msg.Headers = map[string]string{}
+	}
+	msg.Headers[wire.EncryptionHeader] = algorithmAES256GCM
+	msg.Headers[wire.EncryptionKeyIDHeader] = ee.keyID
+	msg.Headers[wire.EncryptedDataKeyHeader] = key.encrypted
+	msg.Body.Value = sealed
+	return nil
+}
+
+// maxCachedKeys bounds the decrypted data key cache, each publisher generates
+// a new data key every TTL.
+const maxCachedKeys = 1024
+
+// Decrypter decrypts message bodies under any key the provider can access.
+type Decrypter struct {
+	provider KeyProvider
+
+	lock  sync.Mutex
+	cache map[string]cipher.AEAD
+}
+
+func NewDecrypter(provider KeyProvider) *Decrypter {
+	return &Decrypter{
+		provider: provider,
+		cache:    map[string]cipher.AEAD{},
+	}
+}
+
+func (dd *Decrypter) dataKey(ctx context.Context, keyID string, encoded string) (cipher.AEAD, error) {
+	cacheKey := keyID + "/" + encoded
+
+	dd.lock.Lock()
+	aead, ok := dd.cache[cacheKey]
+	dd.lock.Unlock()
+	if ok {
+		return aead, nil
+	}
+
+	encrypted, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", wire.EncryptedDataKeyHeader, err)
+	}
+
+	plaintext, err := dd.provider.DecryptDataKey(ctx, keyID, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err = newAEAD(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("data key: %w", err)
+	}
+
+	dd.lock.Lock()
+	if len(dd.cache) >= maxCachedKeys {
+		clear(dd.cache)
+	}
+	dd.cache[cacheKey] = aead
+	dd.lock.Unlock()
+
+	return aead, nil
+}
+
+// Decrypt restores a body encrypted by Encrypt and removes the headers.
+// Messages without the headers are left as they are.
+func (dd *Decrypter) Decrypt(ctx context.Context, msg *messaging_pb.Message) error {
+	if !wire.IsEncrypted(msg) {
+		return nil
+	}
+
+	if algorithm := msg.Headers[wire.EncryptionHeader]; algorithm != algorithmAES256GCM {
+		return fmt.Errorf("unknown %s %q", wire.EncryptionHeader, algorithm)
+	}
+
+	if msg.Body == nil {
+		return fmt.Errorf("encrypted message has no body")
+	}
+
+	aead, err := dd.dataKey(ctx, msg.Headers[wire.EncryptionKeyIDHeader], msg.Headers[wire.EncryptedDataKeyHeader])
+	if err != nil {
+		return err
+	}
+
+	value, err := open(aead, msg.Body.Value, []byte(msg.Body.TypeUrl))
+	if err != nil {
+		return fmt.Errorf("decrypting body: %w", err)
+	}
+
+	delete(msg.Headers, wire.EncryptionHeader)
+	delete(msg.Headers, wire.EncryptionKeyIDHeader)
+	delete(msg.Headers, wire.EncryptedDataKeyHeader)
+	msg.Body.Value = value
+	return nil
+}
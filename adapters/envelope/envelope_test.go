@@ -0,0 +1,143 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func staticKey(id string, fill byte) string {
+	return id + ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, dataKeyBytes))
+}
+
+func testMessage(service string) *messaging_pb.Message {
+	return &messaging_pb.Message{
+		MessageId:   "m1",
+		GrpcService: service,
+		GrpcMethod:  "Foo",
+		Body: &messaging_pb.Any{
+			TypeUrl:  "type.googleapis.com/test.v1.FooMessage",
+			Encoding: messaging_pb.WireEncoding_J5_JSON,
+			Value:    []byte(`{"email": "someone@example.com"}`),
+		},
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	provider, err := NewStaticKeyProvider([]string{staticKey("k1", 1), staticKey("k2", 2)})
+	require.NoError(t, err)
+
+	decrypter := NewDecrypter(provider)
+
+	for _, keyID := range []string{"k1", "k2"} {
+		encrypter, err := NewEncrypter(provider, EncryptionConfig{KeyID: keyID})
+		require.NoError(t, err)
+
+		msg := testMessage("test.v1.FooTopic")
+		require.NoError(t, encrypter.Encrypt(ctx, msg))
+		assert.Equal(t, keyID, msg.Headers[wire.EncryptionKeyIDHeader])
+		assert.NotContains(t, string(msg.Body.Value), "someone@example.com")
+
+		// Encrypting again is a no-op
+		sealed := bytes.Clone(msg.Body.Value)
+		require.NoError(t, encrypter.Encrypt(ctx, msg))
+		assert.Equal(t, sealed, msg.Body.Value)
+
+		require.NoError(t, decrypter.Decrypt(ctx, msg))
+		assert.Equal(t, `{"email": "someone@example.com"}`, string(msg.Body.Value))
+		assert.False(t, wire.IsEncrypted(msg))
+		assert.Empty(t, msg.Headers)
+	}
+}
+
+func TestEncryptServices(t *testing.T) {
+	ctx := context.Background()
+
+	provider, err := NewStaticKeyProvider([]string{staticKey("k1", 1)})
+	require.NoError(t, err)
+
+	encrypter, err := NewEncrypter(provider, EncryptionConfig{
+		KeyID:    "k1",
+		Services: []string{"test.v1.PersonTopic"},
+	})
+	require.NoError(t, err)
+
+	other := testMessage("test.v1.FooTopic")
+	require.NoError(t, encrypter.Encrypt(ctx, other))
+	assert.False(t, wire.IsEncrypted(other))
+
+	person := testMessage("test.v1.PersonTopic")
+	require.NoError(t, encrypter.Encrypt(ctx, person))
+	assert.True(t, wire.IsEncrypted(person))
+}
+
+func TestDecryptInvalid(t *testing.T) {
+	ctx := context.Background()
+
+	provider, err := NewStaticKeyProvider([]string{staticKey("k1", 1)})
+	require.NoError(t, err)
+
+	encrypter, err := NewEncrypter(provider, EncryptionConfig{KeyID: "k1"})
+	require.NoError(t, err)
+
+	t.Run("tampered body", func(t *testing.T) {
+		msg := testMessage("test.v1.FooTopic")
+		require.NoError(t, encrypter.Encrypt(ctx, msg))
+		msg.Body.Value[len(msg.Body.Value)-1] ^= 0xff
+		assert.Error(t, NewDecrypter(provider).Decrypt(ctx, msg))
+	})
+
+	t.Run("changed type", func(t *testing.T) {
+		msg := testMessage("test.v1.FooTopic")
+		require.NoError(t, encrypter.Encrypt(ctx, msg))
+		msg.Body.TypeUrl = "type.googleapis.com/test.v1.BarMessage"
+		assert.Error(t, NewDecrypter(provider).Decrypt(ctx, msg))
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		msg := testMessage("test.v1.FooTopic")
+		require.NoError(t, encrypter.Encrypt(ctx, msg))
+
+		rotated, err := NewStaticKeyProvider([]string{staticKey("k2", 2)})
+		require.NoError(t, err)
+		assert.Error(t, NewDecrypter(rotated).Decrypt(ctx, msg))
+	})
+
+	_, err = NewStaticKeyProvider([]string{"k1:c2hvcnQ="})
+	assert.Error(t, err)
+	_, err = NewStaticKeyProvider([]string{"no-id"})
+	assert.Error(t, err)
+}
+
+func TestCompressedAndEncrypted(t *testing.T) {
+	ctx := context.Background()
+
+	provider, err := NewStaticKeyProvider([]string{staticKey("k1", 1)})
+	require.NoError(t, err)
+	encrypter, err := NewEncrypter(provider, EncryptionConfig{KeyID: "k1"})
+	require.NoError(t, err)
+	compressor, err := wire.NewCompressor(wire.CompressionConfig{
+		MessageCompression: wire.CompressionGzip,
+	})
+	require.NoError(t, err)
+
+	msg := testMessage("test.v1.FooTopic")
+	require.NoError(t, compressor.Compress(msg))
+	require.NoError(t, encrypter.Encrypt(ctx, msg))
+
+	// Parsers decompress before the handler decrypts, which must wait
+	require.NoError(t, wire.Decompress(msg))
+	assert.Equal(t, wire.CompressionGzip, msg.Headers[wire.ContentEncodingHeader])
+
+	require.NoError(t, NewDecrypter(provider).Decrypt(ctx, msg))
+	require.NoError(t, wire.Decompress(msg))
+	assert.Equal(t, `{"email": "someone@example.com"}`, string(msg.Body.Value))
+}
@@ -0,0 +1,158 @@
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// KeyProvider generates data keys encrypted under a named key, and decrypts
+// them again.
+type KeyProvider interface {
+	GenerateDataKey(ctx context.Context, keyID string) (plaintext []byte, encrypted []byte, err error)
+	DecryptDataKey(ctx context.Context, keyID string, encrypted []byte) ([]byte, error)
+}
+
+type KMSAPI interface {
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// KMSKeyProvider uses AWS KMS keys, the key ID is the key's ARN or alias.
+type KMSKeyProvider struct {
+	client KMSAPI
+}
+
+func NewKMSKeyProvider(client KMSAPI) *KMSKeyProvider {
+	return &KMSKeyProvider{
+		client: client,
+	}
+}
+
+func (kp *KMSKeyProvider) GenerateDataKey(ctx context.Context, keyID string) ([]byte, []byte, error) {
+	out, err := kp.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(keyID),
+		KeySpec: types.DataKeySpecAes256,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("kms generate data key: %w", err)
+	}
+	return out.Plaintext, out.CiphertextBlob, nil
+}
+
+func (kp *KMSKeyProvider) DecryptDataKey(ctx context.Context, keyID string, encrypted []byte) ([]byte, error) {
+	out, err := kp.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:          aws.String(keyID),
+		CiphertextBlob: encrypted,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kms decrypt data key: %w", err)
+	}
+	return out.Plaintext, nil
+}
+
+// StaticKeyProvider uses AES-256 keys from config, for local development and
+// tests.
+type StaticKeyProvider struct {
+	keys map[string]cipher.AEAD
+}
+
+// NewStaticKeyProvider parses keys as id:base64, where the decoded key is 32
+// bytes.
+func NewStaticKeyProvider(keys []string) (*StaticKeyProvider, error) {
+	kp := &StaticKeyProvider{
+		keys: make(map[string]cipher.AEAD, len(keys)),
+	}
+
+	for _, entry := range keys {
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			// don't include the entry, it may be a key
+			return nil, fmt.Errorf("static keys must be id:base64")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("static key %s: %w", id, err)
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("static key %s: %w", id, err)
+		}
+
+		kp.keys[id] = aead
+	}
+
+	return kp, nil
+}
+
+func (kp *StaticKeyProvider) GenerateDataKey(ctx context.Context, keyID string) ([]byte, []byte, error) {
+	aead, ok := kp.keys[keyID]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown static key %q", keyID)
+	}
+
+	plaintext := make([]byte, dataKeyBytes)
+	if _, err := rand.Read(plaintext); err != nil {
+		return nil, nil, err
+	}
+
+	encrypted, err := seal(aead, plaintext, []byte(keyID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return plaintext, encrypted, nil
+}
+
+func (kp *StaticKeyProvider) DecryptDataKey(ctx context.Context, keyID string, encrypted []byte) ([]byte, error) {
+	aead, ok := kp.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown static key %q", keyID)
+	}
+
+	return open(aead, encrypted, []byte(keyID))
+}
+
+const dataKeyBytes = 32
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != dataKeyBytes {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", dataKeyBytes, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal returns the nonce followed by the ciphertext
+func seal(aead cipher.AEAD, plaintext []byte, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
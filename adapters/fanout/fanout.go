@@ -0,0 +1,308 @@
+package fanout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pentops/log.go/log"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+)
+
+type FanoutConfig struct {
+	// PublishTo names the transports to publish to. Without it, or a rules
+	// file referencing them, only the first configured transport is
+	// published to, and the others are only consumed from.
+	PublishTo []string `env:"PUBLISH_TO" default:""`
+
+	// RulesFile is a JSON file of Rules, without it every message goes to
+	// every publisher.
+	RulesFile string `env:"PUBLISH_RULES_FILE" default:""`
+
+	// Mode is 'all', where a message is only published once every publisher
+	// it routes to accepts it, or 'primary', where only the Primary publisher
+	// must accept it and failures of the others are logged.
+	Mode    string `env:"PUBLISH_MODE" default:"all"`
+	Primary string `env:"PUBLISH_PRIMARY" default:""`
+}
+
+const (
+	ModeAll     = "all"
+	ModePrimary = "primary"
+)
+
+type Rules struct {
+	Rules []Rule `json:"rules"`
+
+	// Default publishers for messages which match no rule, all publishers
+	// when empty.
+	Default []string `json:"default,omitempty"`
+}
+
+// Rule matches messages on each of the set fields. Service and topic
+// patterns ending in '*' match as a prefix.
+type Rule struct {
+	GrpcService      string            `json:"grpcService,omitempty"`
+	DestinationTopic string            `json:"destinationTopic,omitempty"`
+	Headers          map[string]string `json:"headers,omitempty"`
+
+	Publishers []string `json:"publishers"`
+}
+
+func (rule Rule) matches(msg *messaging_pb.Message) bool {
+	if rule.GrpcService != "" && !matchPattern(rule.GrpcService, msg.GrpcService) {
+		return false
+	}
+	if rule.DestinationTopic != "" && !matchPattern(rule.DestinationTopic, msg.DestinationTopic) {
+		return false
+	}
+	for key, val := range rule.Headers {
+		if msg.Headers[key] != val {
+			return false
+		}
+	}
+	return true
+}
+
+func matchPattern(pattern, val string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(val, prefix)
+	}
+	return pattern == val
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, msg *messaging_pb.Message) error
+	PublishBatch(ctx context.Context, msgs []*messaging_pb.Message) ([]string, error)
+}
+
+type NamedPublisher struct {
+	Name      string
+	Publisher Publisher
+}
+
+type FanoutPublisher struct {
+	publishers map[string]Publisher
+	rules      Rules
+	mode       string
+	primary    string
+}
+
+func LoadRules(filename string) (Rules, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Rules{}, fmt.Errorf("reading publish rules: %w", err)
+	}
+
+	rules := Rules{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return Rules{}, fmt.Errorf("parsing publish rules: %w", err)
+	}
+	return rules, nil
+}
+
+// names lists the publishers the rules reference.
+func (rules Rules) names() []string {
+	names := append([]string{}, rules.Default...)
+	for _, rule := range rules.Rules {
+		names = append(names, rule.Publishers...)
+	}
+	return names
+}
+
+// Enabled returns the publishers which are published to, in order: those in
+// PublishTo, or referenced by the rules file or as the primary. Without
+// either, only the first publisher is, so that a transport configured to
+// consume from isn't also sent every outbound message.
+func Enabled(config FanoutConfig, publishers []NamedPublisher) ([]NamedPublisher, error) {
+	names := config.PublishTo
+	if len(names) == 0 && config.RulesFile != "" {
+		rules, err := LoadRules(config.RulesFile)
+		if err != nil {
+			return nil, err
+		}
+		names = rules.names()
+		if config.Primary != "" {
+			names = append(names, config.Primary)
+		}
+	}
+
+	if len(names) == 0 {
+		if len(publishers) > 1 {
+			return publishers[:1], nil
+		}
+		return publishers, nil
+	}
+
+	enabled := make([]NamedPublisher, 0, len(publishers))
+	for _, pub := range publishers {
+		if slices.Contains(names, pub.Name) {
+			enabled = append(enabled, pub)
+		}
+	}
+	for _, name := range names {
+		if !slices.ContainsFunc(enabled, func(pub NamedPublisher) bool { return pub.Name == name }) {
+			return nil, fmt.Errorf("%q is not a configured publisher", name)
+		}
+	}
+	return enabled, nil
+}
+
+func NewPublisher(config FanoutConfig, publishers []NamedPublisher) (*FanoutPublisher, error) {
+	fp := &FanoutPublisher{
+		publishers: make(map[string]Publisher, len(publishers)),
+		mode:       config.Mode,
+		primary:    config.Primary,
+	}
+
+	for _, pub := range publishers {
+		fp.publishers[pub.Name] = pub.Publisher
+		fp.rules.Default = append(fp.rules.Default, pub.Name)
+	}
+
+	if config.RulesFile != "" {
+		rules, err := LoadRules(config.RulesFile)
+		if err != nil {
+			return nil, err
+		}
+		if len(rules.Default) == 0 {
+			rules.Default = fp.rules.Default
+		}
+		fp.rules = rules
+	}
+
+	switch fp.mode {
+	case ModeAll, "":
+		fp.mode = ModeAll
+	case ModePrimary:
+		if _, ok := fp.publishers[fp.primary]; !ok {
+			return nil, fmt.Errorf("PUBLISH_PRIMARY %q is not a configured publisher", fp.primary)
+		}
+	default:
+		return nil, fmt.Errorf("unknown PUBLISH_MODE %q", fp.mode)
+	}
+
+	for _, rule := range fp.rules.Rules {
+		if len(rule.Publishers) == 0 {
+			return nil, fmt.Errorf("publish rule has no publishers")
+		}
+		if err := fp.checkNames(rule.Publishers); err != nil {
+			return nil, err
+		}
+	}
+	if err := fp.checkNames(fp.rules.Default); err != nil {
+		return nil, err
+	}
+
+	return fp, nil
+}
+
+func (fp *FanoutPublisher) checkNames(names []string) error {
+	for _, name := range names {
+		if _, ok := fp.publishers[name]; !ok {
+			return fmt.Errorf("publish rule references unknown publisher %q", name)
+		}
+	}
+	return nil
+}
+
+func (fp *FanoutPublisher) route(msg *messaging_pb.Message) []string {
+	for _, rule := range fp.rules.Rules {
+		if rule.matches(msg) {
+			return rule.Publishers
+		}
+	}
+	return fp.rules.Default
+}
+
+func (fp *FanoutPublisher) Publish(ctx context.Context, msg *messaging_pb.Message) error {
+	_, err := fp.PublishBatch(ctx, []*messaging_pb.Message{msg})
+	return err
+}
+
+type batchResult struct {
+	ids map[string]struct{}
+	err error
+}
+
+// PublishBatch splits the batch by publisher, publishes each in parallel, and
+// returns the IDs of messages which were accepted according to the mode.
+func (fp *FanoutPublisher) PublishBatch(ctx context.Context, msgs []*messaging_pb.Message) ([]string, error) {
+	routes := make([][]string, len(msgs))
+	batches := map[string][]*messaging_pb.Message{}
+	for idx, msg := range msgs {
+		routes[idx] = fp.route(msg)
+		for _, name := range routes[idx] {
+			batches[name] = append(batches[name], msg)
+		}
+	}
+
+	results := make(map[string]batchResult, len(batches))
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for name, batch := range batches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids, err := fp.publishers[name].PublishBatch(ctx, batch)
+			idSet := make(map[string]struct{}, len(ids))
+			for _, id := range ids {
+				idSet[id] = struct{}{}
+			}
+			lock.Lock()
+			results[name] = batchResult{ids: idSet, err: err}
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	errs := make([]error, 0)
+	for name, result := range results {
+		if result.err == nil {
+			continue
+		}
+		if fp.mode == ModePrimary && name != fp.primary {
+			log.WithFields(ctx, "publisher", name, "error", result.err.Error()).Error("Fanout: secondary publisher failed")
+			continue
+		}
+		errs = append(errs, fmt.Errorf("publisher %s: %w", name, result.err))
+	}
+
+	ids := make([]string, 0, len(msgs))
+	for idx, msg := range msgs {
+		if fp.accepted(msg.MessageId, routes[idx], results) {
+			ids = append(ids, msg.MessageId)
+		}
+	}
+
+	if len(ids) < len(msgs) && len(errs) == 0 {
+		// Messages not routed to the primary fail on any publisher
+		errs = append(errs, fmt.Errorf("%d of %d messages not published", len(msgs)-len(ids), len(msgs)))
+	}
+
+	return ids, errors.Join(errs...)
+}
+
+func (fp *FanoutPublisher) accepted(id string, route []string, results map[string]batchResult) bool {
+	if fp.mode == ModePrimary {
+		for _, name := range route {
+			if name == fp.primary {
+				_, ok := results[name].ids[id]
+				return ok
+			}
+		}
+		// not routed to the primary, so falls back to all.
+	}
+
+	for _, name := range route {
+		if _, ok := results[name].ids[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,172 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPublisher struct {
+	fail map[string]bool
+
+	lock sync.Mutex
+	sent []string
+}
+
+func (tp *testPublisher) Publish(ctx context.Context, msg *messaging_pb.Message) error {
+	_, err := tp.PublishBatch(ctx, []*messaging_pb.Message{msg})
+	return err
+}
+
+func (tp *testPublisher) PublishBatch(ctx context.Context, msgs []*messaging_pb.Message) ([]string, error) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+	ids := []string{}
+	var err error
+	for _, msg := range msgs {
+		if tp.fail[msg.MessageId] {
+			err = errors.New("failed")
+			continue
+		}
+		tp.sent = append(tp.sent, msg.MessageId)
+		ids = append(ids, msg.MessageId)
+	}
+	return ids, err
+}
+
+func TestRouting(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.json")
+	err := os.WriteFile(rulesFile, []byte(`{
+		"rules": [{
+			"grpcService": "foo.v1.*",
+			"publishers": ["a"]
+		}, {
+			"destinationTopic": "things",
+			"headers": {"route": "b"},
+			"publishers": ["b"]
+		}]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	pubA := &testPublisher{}
+	pubB := &testPublisher{}
+
+	fp, err := NewPublisher(FanoutConfig{
+		RulesFile: rulesFile,
+	}, []NamedPublisher{
+		{Name: "a", Publisher: pubA},
+		{Name: "b", Publisher: pubB},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ids, err := fp.PublishBatch(t.Context(), []*messaging_pb.Message{{
+		MessageId:   "foo",
+		GrpcService: "foo.v1.FooTopic",
+	}, {
+		MessageId:        "things",
+		DestinationTopic: "things",
+		Headers:          map[string]string{"route": "b"},
+	}, {
+		MessageId:   "other",
+		GrpcService: "bar.v1.BarTopic",
+	}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.ElementsMatch(t, []string{"foo", "things", "other"}, ids)
+	assert.ElementsMatch(t, []string{"foo", "other"}, pubA.sent)
+	assert.ElementsMatch(t, []string{"things", "other"}, pubB.sent)
+}
+
+func TestModes(t *testing.T) {
+	msgs := []*messaging_pb.Message{{
+		MessageId: "ok",
+	}, {
+		MessageId: "secondary-fails",
+	}}
+
+	t.Run("all", func(t *testing.T) {
+		fp, err := NewPublisher(FanoutConfig{Mode: ModeAll}, []NamedPublisher{
+			{Name: "primary", Publisher: &testPublisher{}},
+			{Name: "secondary", Publisher: &testPublisher{fail: map[string]bool{"secondary-fails": true}}},
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		ids, err := fp.PublishBatch(t.Context(), msgs)
+		assert.Error(t, err)
+		assert.Equal(t, []string{"ok"}, ids)
+	})
+
+	t.Run("primary", func(t *testing.T) {
+		fp, err := NewPublisher(FanoutConfig{Mode: ModePrimary, Primary: "primary"}, []NamedPublisher{
+			{Name: "primary", Publisher: &testPublisher{}},
+			{Name: "secondary", Publisher: &testPublisher{fail: map[string]bool{"secondary-fails": true}}},
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		ids, err := fp.PublishBatch(t.Context(), msgs)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"ok", "secondary-fails"}, ids)
+	})
+
+	t.Run("unknown primary", func(t *testing.T) {
+		_, err := NewPublisher(FanoutConfig{Mode: ModePrimary, Primary: "nope"}, []NamedPublisher{
+			{Name: "primary", Publisher: &testPublisher{}},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestEnabled(t *testing.T) {
+	publishers := []NamedPublisher{
+		{Name: "a", Publisher: &testPublisher{}},
+		{Name: "b", Publisher: &testPublisher{}},
+		{Name: "c", Publisher: &testPublisher{}},
+	}
+	enabledNames := func(config FanoutConfig) []string {
+		t.Helper()
+		enabled, err := Enabled(config, publishers)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		names := []string{}
+		for _, pub := range enabled {
+			names = append(names, pub.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"a"}, enabledNames(FanoutConfig{}), "others are only consumed from")
+	assert.Equal(t, []string{"a", "c"}, enabledNames(FanoutConfig{PublishTo: []string{"c", "a"}}))
+
+	rulesFile := filepath.Join(t.TempDir(), "rules.json")
+	err := os.WriteFile(rulesFile, []byte(`{
+		"rules": [{"grpcService": "foo.v1.*", "publishers": ["b"]}]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, []string{"b", "c"}, enabledNames(FanoutConfig{
+		RulesFile: rulesFile,
+		Mode:      ModePrimary,
+		Primary:   "c",
+	}))
+
+	_, err = Enabled(FanoutConfig{PublishTo: []string{"a", "d"}}, publishers)
+	assert.Error(t, err)
+}
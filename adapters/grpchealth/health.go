@@ -0,0 +1,145 @@
+// Package grpchealth tracks whether the app's endpoints report SERVING on the
+// standard grpc.health.v1 service.
+package grpchealth
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pentops/log.go/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const retryDelay = time.Second
+
+// Checker is ready while every endpoint added to it is serving.
+type Checker struct {
+	lock      sync.Mutex
+	endpoints map[string]bool
+	isReady   bool
+	ready     chan struct{} // closed while ready
+}
+
+func NewChecker() *Checker {
+	cc := &Checker{
+		endpoints: map[string]bool{},
+		ready:     make(chan struct{}),
+	}
+	cc.update()
+	return cc
+}
+
+// Add registers an endpoint as not serving, until Watch reports otherwise.
+func (cc *Checker) Add(endpoint string) {
+	cc.set(endpoint, false)
+}
+
+func (cc *Checker) set(endpoint string, serving bool) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	cc.endpoints[endpoint] = serving
+	cc.update()
+}
+
+// update opens or closes the ready channel, the caller holds the lock.
+func (cc *Checker) update() {
+	all := true
+	for _, serving := range cc.endpoints {
+		all = all && serving
+	}
+
+	switch {
+	case all && !cc.isReady:
+		close(cc.ready)
+	case !all && cc.isReady:
+		cc.ready = make(chan struct{})
+	}
+	cc.isReady = all
+}
+
+// Ready returns an error naming the endpoints which are not serving.
+func (cc *Checker) Ready() error {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	if cc.isReady {
+		return nil
+	}
+
+	notServing := []string{}
+	for endpoint, serving := range cc.endpoints {
+		if !serving {
+			notServing = append(notServing, endpoint)
+		}
+	}
+	slices.Sort(notServing)
+	return fmt.Errorf("app not serving: %s", strings.Join(notServing, ", "))
+}
+
+// WaitReady blocks until every endpoint is serving.
+func (cc *Checker) WaitReady(ctx context.Context) error {
+	cc.lock.Lock()
+	ready := cc.ready
+	cc.lock.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Watch follows the endpoint's overall health status until the context is
+// done. Apps which don't implement the health service are always serving.
+func (cc *Checker) Watch(ctx context.Context, endpoint string, conn grpc.ClientConnInterface) error {
+	ctx = log.WithField(ctx, "endpoint", endpoint)
+	client := grpc_health_v1.NewHealthClient(conn)
+
+	for {
+		err := cc.watch(ctx, endpoint, client)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if status.Code(err) == codes.Unimplemented {
+			log.Info(ctx, "App has no health service, assuming serving")
+			cc.set(endpoint, true)
+			return nil
+		}
+
+		// The app is down or restarting
+		cc.set(endpoint, false)
+		log.WithError(ctx, err).Warn("App health watch failed")
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+func (cc *Checker) watch(ctx context.Context, endpoint string, client grpc_health_v1.HealthClient) error {
+	stream, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		serving := resp.Status == grpc_health_v1.HealthCheckResponse_SERVING
+		log.WithField(ctx, "status", resp.Status.String()).Info("App health changed")
+		cc.set(endpoint, serving)
+	}
+}
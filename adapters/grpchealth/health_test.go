@@ -0,0 +1,99 @@
+package grpchealth
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestCheckerReady(t *testing.T) {
+	cc := NewChecker()
+	require.NoError(t, cc.Ready(), "no endpoints is ready")
+
+	cc.Add("b")
+	cc.Add("a")
+	err := cc.Ready()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "a, b")
+
+	cc.set("a", true)
+	cc.set("b", true)
+	require.NoError(t, cc.Ready())
+	require.NoError(t, cc.WaitReady(t.Context()))
+
+	// Not serving again pauses waiters
+	cc.set("a", false)
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, cc.WaitReady(ctx), context.DeadlineExceeded)
+}
+
+func newTestConn(t *testing.T, register func(*grpc.Server)) *grpc.ClientConn {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	register(srv)
+	go srv.Serve(lis) // nolint: errcheck
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestWatch(t *testing.T) {
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	conn := newTestConn(t, func(srv *grpc.Server) {
+		grpc_health_v1.RegisterHealthServer(srv, healthServer)
+	})
+
+	cc := NewChecker()
+	cc.Add("app")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go cc.Watch(ctx, "app", conn) // nolint: errcheck
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer waitCancel()
+	assert.Error(t, cc.WaitReady(waitCtx), "not serving")
+
+	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	require.NoError(t, waitFor(ctx, cc))
+
+	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	assert.Eventually(t, func() bool {
+		return cc.Ready() != nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWatchUnimplemented(t *testing.T) {
+	conn := newTestConn(t, func(*grpc.Server) {})
+
+	cc := NewChecker()
+	cc.Add("app")
+
+	require.NoError(t, cc.Watch(t.Context(), "app", conn))
+	require.NoError(t, cc.Ready())
+}
+
+func waitFor(ctx context.Context, cc *Checker) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	return cc.WaitReady(ctx)
+}
@@ -0,0 +1,160 @@
+package grpcreflect
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	codec "github.com/pentops/j5/lib/j5codec"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// DescriptorSource is satisfied by ReflectionClient
+type DescriptorSource interface {
+	Name() string
+	FindDescriptorByName(protoreflect.FullName) (protoreflect.Descriptor, error)
+}
+
+// ConflictError is returned when more than one endpoint defines the same full
+// name differently.
+type ConflictError struct {
+	Name      protoreflect.FullName
+	Endpoints []string
+}
+
+func (ce *ConflictError) Error() string {
+	return fmt.Sprintf("conflicting definitions of %s from endpoints %v", ce.Name, ce.Endpoints)
+}
+
+// CompositeResolver resolves types from all of the app's endpoints, for
+// converting messages which any of them may have published.
+type CompositeResolver struct {
+	codec *codec.Codec
+
+	lock     sync.RWMutex
+	sources  []DescriptorSource
+	resolved map[protoreflect.FullName]protoreflect.Descriptor
+}
+
+func NewCompositeResolver() *CompositeResolver {
+	cr := &CompositeResolver{
+		resolved: map[protoreflect.FullName]protoreflect.Descriptor{},
+	}
+	cr.codec = codec.NewCodec(codec.WithResolver(cr))
+	return cr
+}
+
+func (cr *CompositeResolver) AddSource(source DescriptorSource) {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	cr.sources = append(cr.sources, source)
+	// A new source may conflict with names already resolved
+	clear(cr.resolved)
+}
+
+// Reset forgets resolved names, after a source's descriptors change.
+func (cr *CompositeResolver) Reset() {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	clear(cr.resolved)
+}
+
+func (cr *CompositeResolver) JSONToProto(jsonData []byte, msg protoreflect.Message) error {
+	return cr.codec.JSONToProto(jsonData, msg)
+}
+
+func (cr *CompositeResolver) ProtoToJSON(msg protoreflect.Message) ([]byte, error) {
+	return cr.codec.ProtoToJSON(msg)
+}
+
+// FindDescriptorByName searches every source, returning a ConflictError if
+// they don't agree on the definition.
+func (cr *CompositeResolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	cr.lock.RLock()
+	desc, ok := cr.resolved[name]
+	sources := cr.sources
+	cr.lock.RUnlock()
+	if ok {
+		return desc, nil
+	}
+
+	var found protoreflect.Descriptor
+	var foundIn []string
+	var errs []error
+	for _, source := range sources {
+		desc, err := source.FindDescriptorByName(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", source.Name(), err))
+			continue
+		}
+
+		if found != nil && !sameDefinition(found, desc) {
+			return nil, &ConflictError{
+				Name:      name,
+				Endpoints: append(foundIn, source.Name()),
+			}
+		}
+
+		if found == nil {
+			found = desc
+		}
+		foundIn = append(foundIn, source.Name())
+	}
+
+	if found == nil {
+		if len(errs) == 0 {
+			return nil, fmt.Errorf("%w: %s: no endpoints", protoregistry.NotFound, name)
+		}
+		return nil, fmt.Errorf("%w: %s: %w", protoregistry.NotFound, name, errors.Join(errs...))
+	}
+
+	cr.lock.Lock()
+	cr.resolved[name] = found
+	cr.lock.Unlock()
+
+	return found, nil
+}
+
+func (cr *CompositeResolver) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	desc, err := cr.FindDescriptorByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	descMsg, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("type %s is not a message", name)
+	}
+
+	return dynamicpb.NewMessageType(descMsg), nil
+}
+
+// sameDefinition compares the descriptors themselves, rather than the files
+// which contain them, so that endpoints built from different versions of a
+// file agree as long as the type didn't change.
+func sameDefinition(a, b protoreflect.Descriptor) bool {
+	aProto, bProto := descriptorProto(a), descriptorProto(b)
+	if aProto == nil || bProto == nil {
+		return a.FullName() == b.FullName() && a.ParentFile().Path() == b.ParentFile().Path()
+	}
+	return proto.Equal(aProto, bProto)
+}
+
+func descriptorProto(desc protoreflect.Descriptor) proto.Message {
+	switch desc := desc.(type) {
+	case protoreflect.MessageDescriptor:
+		return protodesc.ToDescriptorProto(desc)
+	case protoreflect.EnumDescriptor:
+		return protodesc.ToEnumDescriptorProto(desc)
+	case protoreflect.ServiceDescriptor:
+		return protodesc.ToServiceDescriptorProto(desc)
+	case protoreflect.FieldDescriptor:
+		return protodesc.ToFieldDescriptorProto(desc)
+	default:
+		return nil
+	}
+}
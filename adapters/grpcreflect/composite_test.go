@@ -0,0 +1,122 @@
+package grpcreflect
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+type testSource struct {
+	name  string
+	files *protoregistry.Files
+}
+
+func (ts testSource) Name() string {
+	return ts.name
+}
+
+func (ts testSource) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	return ts.files.FindDescriptorByName(name)
+}
+
+// newTestSource serves a file with the given messages, each with a single
+// field of the given type.
+func newTestSource(t *testing.T, name string, path string, messages map[string]descriptorpb.FieldDescriptorProto_Type) testSource {
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(path),
+		Package: proto.String("test.v1"),
+		Syntax:  proto.String("proto3"),
+	}
+	for msgName, fieldType := range messages {
+		fdp.MessageType = append(fdp.MessageType, &descriptorpb.DescriptorProto{
+			Name: proto.String(msgName),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("value"),
+				JsonName: proto.String("value"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     fieldType.Enum(),
+			}},
+		})
+	}
+
+	fd, err := protodesc.NewFile(fdp, nil)
+	require.NoError(t, err)
+
+	files := &protoregistry.Files{}
+	require.NoError(t, files.RegisterFile(fd))
+
+	return testSource{name: name, files: files}
+}
+
+func TestCompositeResolver(t *testing.T) {
+	resolver := NewCompositeResolver()
+
+	_, err := resolver.FindMessageByName("test.v1.Foo")
+	assert.True(t, errors.Is(err, protoregistry.NotFound))
+
+	resolver.AddSource(newTestSource(t, "a:8080", "test/v1/a.proto", map[string]descriptorpb.FieldDescriptorProto_Type{
+		"Foo":    descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		"Shared": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		"Clash":  descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	}))
+	resolver.AddSource(newTestSource(t, "b:8080", "test/v1/b.proto", map[string]descriptorpb.FieldDescriptorProto_Type{
+		"Bar":    descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		"Shared": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		"Clash":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	}))
+
+	for _, name := range []protoreflect.FullName{"test.v1.Foo", "test.v1.Bar", "test.v1.Shared"} {
+		mt, err := resolver.FindMessageByName(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, name, mt.Descriptor().FullName())
+	}
+
+	_, err = resolver.FindMessageByName("test.v1.Clash")
+	conflict := &ConflictError{}
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, []string{"a:8080", "b:8080"}, conflict.Endpoints)
+
+	_, err = resolver.FindMessageByName("test.v1.Missing")
+	assert.True(t, errors.Is(err, protoregistry.NotFound))
+}
+
+func TestServicesDigest(t *testing.T) {
+	build := func(method string) protoreflect.ServiceDescriptor {
+		fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+			Name:    proto.String("test/v1/service.proto"),
+			Package: proto.String("test.v1"),
+			Syntax:  proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Empty"),
+			}},
+			Service: []*descriptorpb.ServiceDescriptorProto{{
+				Name: proto.String("FooService"),
+				Method: []*descriptorpb.MethodDescriptorProto{{
+					Name:       proto.String(method),
+					InputType:  proto.String(".test.v1.Empty"),
+					OutputType: proto.String(".test.v1.Empty"),
+				}},
+			}},
+		}, nil)
+		require.NoError(t, err)
+		return fd.Services().Get(0)
+	}
+
+	a, err := ServicesDigest([]protoreflect.ServiceDescriptor{build("Get")})
+	require.NoError(t, err)
+	again, err := ServicesDigest([]protoreflect.ServiceDescriptor{build("Get")})
+	require.NoError(t, err)
+	changed, err := ServicesDigest([]protoreflect.ServiceDescriptor{build("List")})
+	require.NoError(t, err)
+
+	assert.Equal(t, a, again)
+	assert.NotEqual(t, a, changed)
+}
@@ -2,15 +2,20 @@ package grpcreflect
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"maps"
 	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	codec "github.com/pentops/j5/lib/j5codec"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/reflection/grpc_reflection_v1"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protodesc"
@@ -44,6 +49,11 @@ func (cl *ReflectionClient) Name() string {
 	return cl.conn.Target()
 }
 
+// Conn is the connection to the endpoint, for other clients of the app.
+func (cl *ReflectionClient) Conn() grpc.ClientConnInterface {
+	return cl.conn
+}
+
 func (cl *ReflectionClient) Close() error {
 	return cl.conn.Close()
 }
@@ -188,7 +198,9 @@ func (cl *ReflectionClient) FetchServices(ctx context.Context) ([]protoreflect.S
 		return nil, err
 	}
 
+	cl.lock.Lock()
 	cl.files = files
+	cl.lock.Unlock()
 
 	services := make([]protoreflect.ServiceDescriptor, 0, len(serviceNames))
 
@@ -208,3 +220,88 @@ func (cl *ReflectionClient) FetchServices(ctx context.Context) ([]protoreflect.S
 
 	return services, nil
 }
+
+// Files are the descriptors cached by the last FetchServices, along with any
+// fetched since.
+func (cl *ReflectionClient) Files() *protoregistry.Files {
+	cl.lock.RLock()
+	defer cl.lock.RUnlock()
+	return cl.files
+}
+
+// RestoreFiles puts back descriptors from Files, when the services from a
+// later FetchServices are not used.
+func (cl *ReflectionClient) RestoreFiles(files *protoregistry.Files) {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+	cl.files = files
+}
+
+// WaitForReconnect blocks until the connection to the app is ready after
+// having been lost, e.g. when the app container restarts.
+func (cl *ReflectionClient) WaitForReconnect(ctx context.Context) error {
+	lost := false
+	state := cl.conn.GetState()
+	for {
+		switch state {
+		case connectivity.Ready:
+			if lost {
+				return nil
+			}
+
+		case connectivity.Idle:
+			// Idle connections don't reconnect until used
+			lost = true
+			cl.conn.Connect()
+
+		default:
+			lost = true
+		}
+
+		if !cl.conn.WaitForStateChange(ctx, state) {
+			return ctx.Err()
+		}
+		state = cl.conn.GetState()
+	}
+}
+
+// ServicesDigest identifies the definitions of the services and all of the
+// files they depend on, to detect when an app's API changes.
+func ServicesDigest(services []protoreflect.ServiceDescriptor) (string, error) {
+	files := map[string]protoreflect.FileDescriptor{}
+	var walk func(protoreflect.FileDescriptor)
+	walk = func(fd protoreflect.FileDescriptor) {
+		if _, ok := files[fd.Path()]; ok {
+			return
+		}
+		files[fd.Path()] = fd
+		imports := fd.Imports()
+		for ii := range imports.Len() {
+			walk(imports.Get(ii).FileDescriptor)
+		}
+	}
+
+	names := make([]string, 0, len(services))
+	for _, service := range services {
+		names = append(names, string(service.FullName()))
+		walk(service.ParentFile())
+	}
+
+	paths := slices.Sorted(maps.Keys(files))
+	slices.Sort(names)
+
+	hash := sha256.New()
+	for _, name := range names {
+		hash.Write([]byte(name))
+		hash.Write([]byte{0})
+	}
+	for _, path := range paths {
+		data, err := proto.MarshalOptions{Deterministic: true}.Marshal(protodesc.ToFileDescriptorProto(files[path]))
+		if err != nil {
+			return "", fmt.Errorf("marshal %s: %w", path, err)
+		}
+		hash.Write(data)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
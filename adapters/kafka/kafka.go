@@ -0,0 +1,145 @@
+package kafka
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pentops/j5/lib/j5codec"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-runtime-sidecar/sidecar"
+	kafka "github.com/segmentio/kafka-go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type KafkaConfig struct {
+	Brokers     []string `env:"KAFKA_BROKERS" default:""`
+	TopicPrefix string   `env:"KAFKA_TOPIC_PREFIX" default:""`
+
+	// PartitionKey names a message header to use as the record key, the
+	// message ID is used when unset or when the header is missing.
+	PartitionKey string `env:"KAFKA_PARTITION_KEY" default:""`
+
+	GroupID       string   `env:"KAFKA_GROUP_ID" default:""`
+	ConsumeTopics []string `env:"KAFKA_CONSUME_TOPICS" default:""`
+
+	// Failed records are retried in place this many times before they are
+	// dead-lettered, or skipped without a dead letter handler.
+	MaxAttempts int `env:"KAFKA_MAX_ATTEMPTS" default:"3"`
+}
+
+const (
+	o5MessageContentType = "application/o5-message"
+
+	contentTypeHeader = "content-type"
+	serviceHeader     = "grpc-service"
+	grpcMessageHeader = "grpc-message"
+	messageIDHeader   = "o5-message-id"
+)
+
+/* Topic Breakdown:
+
+Messages with a destination topic are published to {prefix}.{topic}, all
+others to a topic per gRPC service, {prefix}.{package}.{Service}, with the
+method in the grpc-service header.
+
+Replies are published to the topic of the app which sent the request,
+{prefix}.reply.{env}.{app}, which that app adds to KAFKA_CONSUME_TOPICS.
+
+The prefix defaults to o5.{env}, matching the AMQP exchange name.
+
+*/
+
+func messageToTopic(prefix string, message *messaging_pb.Message) string {
+	if env, app, ok := sidecar.ReplyDestination(message); ok {
+		return fmt.Sprintf("%s.reply.%s.%s", prefix, env, app)
+	}
+	if message.DestinationTopic != "" {
+		return fmt.Sprintf("%s.%s", prefix, message.DestinationTopic)
+	}
+	return fmt.Sprintf("%s.%s", prefix, message.GrpcService)
+}
+
+func headerValue(headers []kafka.Header, key string) (string, bool) {
+	for _, header := range headers {
+		if header.Key == key {
+			return string(header.Value), true
+		}
+	}
+	return "", false
+}
+
+var messageIDNamespace = uuid.MustParse("5E0B4C1A-8D0F-4B6E-A3B2-7C1D9F2E4A60")
+
+// parseRecord converts a Kafka record to an o5 message. Records published by
+// another sidecar carry the whole message, records from other publishers are
+// either addressed to a gRPC service through the grpc-service header, or
+// wrapped as a raw message.
+func parseRecord(record kafka.Message) (*messaging_pb.Message, error) {
+	contentType, _ := headerValue(record.Headers, contentTypeHeader)
+	if contentType == o5MessageContentType {
+		msg := &messaging_pb.Message{}
+		if err := j5codec.Global.JSONToProto(record.Value, msg.ProtoReflect()); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal o5-message: %w", err)
+		}
+		return msg, nil
+	}
+
+	// There is no native message ID in Kafka, the record position is unique
+	// and stable across redeliveries.
+	messageID := uuid.NewSHA1(messageIDNamespace, fmt.Appendf(nil, "%s/%d/%d", record.Topic, record.Partition, record.Offset)).String()
+
+	serviceName, ok := headerValue(record.Headers, serviceHeader)
+	if !ok {
+		return &messaging_pb.Message{
+			MessageId: messageID,
+			Body: &messaging_pb.Any{
+				Encoding: messaging_pb.WireEncoding_RAW,
+				Value:    record.Value,
+			},
+			GrpcService:      "o5.messaging.v1.topic.RawMessageTopic",
+			GrpcMethod:       "Raw",
+			DestinationTopic: record.Topic,
+			Timestamp:        timestamppb.New(record.Time),
+		}, nil
+	}
+
+	parts := strings.Split(serviceName, "/")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid service name: %s", serviceName)
+	}
+
+	var encoding messaging_pb.WireEncoding
+	switch contentType {
+	case "application/json":
+		encoding = messaging_pb.WireEncoding_PROTOJSON
+	case "application/protobuf":
+		encoding = messaging_pb.WireEncoding_UNSPECIFIED
+	case "":
+		trimmed := bytes.TrimSpace(record.Value)
+		if len(trimmed) > 0 && trimmed[0] == '{' {
+			encoding = messaging_pb.WireEncoding_PROTOJSON
+		} else {
+			encoding = messaging_pb.WireEncoding_UNSPECIFIED
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content type: %s", contentType)
+	}
+
+	var typeURL string
+	if grpcMessage, ok := headerValue(record.Headers, grpcMessageHeader); ok {
+		typeURL = fmt.Sprintf("type.googleapis.com/%s", grpcMessage)
+	}
+
+	return &messaging_pb.Message{
+		MessageId: messageID,
+		Body: &messaging_pb.Any{
+			Encoding: encoding,
+			Value:    record.Value,
+			TypeUrl:  typeURL,
+		},
+		GrpcService: parts[1],
+		GrpcMethod:  parts[2],
+	}, nil
+}
@@ -0,0 +1,127 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pentops/j5/gen/j5/messaging/v1/messaging_j5pb"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-messaging/o5msg"
+	"github.com/pentops/o5-runtime-sidecar/testproto/gen/test/v1/test_tpb"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishRoundTrip(t *testing.T) {
+	publisher, err := NewPublisher(KafkaConfig{
+		Brokers:      []string{"localhost:9092"},
+		PartitionKey: "tenant",
+	}, "test")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	msg := &messaging_pb.Message{
+		MessageId:   "6f4ad4b4-7c8e-4b2f-9a5e-0d0b1c2a3f4e",
+		GrpcService: "test.v1.FooTopic",
+		GrpcMethod:  "Foo",
+		Headers: map[string]string{
+			"tenant": "t1",
+		},
+		Body: &messaging_pb.Any{
+			TypeUrl: "type.googleapis.com/test.v1.FooMessage",
+			Value:   []byte("FOOBAR"),
+		},
+	}
+
+	record, err := publisher.buildRecord(msg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, "o5.test.test.v1.FooTopic", record.Topic)
+	assert.Equal(t, "t1", string(record.Key))
+	service, _ := headerValue(record.Headers, serviceHeader)
+	assert.Equal(t, "/test.v1.FooTopic/Foo", service)
+	tenant, _ := headerValue(record.Headers, "tenant")
+	assert.Equal(t, "t1", tenant)
+
+	parsed, err := parseRecord(record)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, msg.MessageId, parsed.MessageId)
+	assert.Equal(t, msg.GrpcService, parsed.GrpcService)
+	assert.Equal(t, msg.GrpcMethod, parsed.GrpcMethod)
+	assert.Equal(t, "FOOBAR", string(parsed.Body.Value))
+
+	msg.DestinationTopic = "things"
+	record, err = publisher.buildRecord(msg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, "o5.test.things", record.Topic)
+}
+
+func TestParseRecord(t *testing.T) {
+	t.Run("service", func(t *testing.T) {
+		msg, err := parseRecord(kafka.Message{
+			Topic:  "external",
+			Offset: 12,
+			Headers: []kafka.Header{
+				{Key: serviceHeader, Value: []byte("/test.v1.FooTopic/Foo")},
+				{Key: grpcMessageHeader, Value: []byte("test.v1.FooMessage")},
+			},
+			Value: []byte(`{"name": "test"}`),
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, "test.v1.FooTopic", msg.GrpcService)
+		assert.Equal(t, "Foo", msg.GrpcMethod)
+		assert.Equal(t, messaging_pb.WireEncoding_PROTOJSON, msg.Body.Encoding)
+		assert.Equal(t, "type.googleapis.com/test.v1.FooMessage", msg.Body.TypeUrl)
+		assert.NotEmpty(t, msg.MessageId)
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		msg, err := parseRecord(kafka.Message{
+			Topic: "external",
+			Time:  time.Now(),
+			Value: []byte(`Hello World!`),
+		})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, "o5.messaging.v1.topic.RawMessageTopic", msg.GrpcService)
+		assert.Equal(t, "external", msg.DestinationTopic)
+		assert.Equal(t, messaging_pb.WireEncoding_RAW, msg.Body.Encoding)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := parseRecord(kafka.Message{
+			Headers: []kafka.Header{
+				{Key: contentTypeHeader, Value: []byte(o5MessageContentType)},
+			},
+			Value: []byte(`not json`),
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestReplyTopic(t *testing.T) {
+	msg, err := o5msg.WrapMessage(&test_tpb.TestReqResReplyMessage{
+		Request: &messaging_j5pb.RequestMetadata{
+			ReplyTo: "prod/requester",
+		},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, "o5.prod.reply.prod.requester", messageToTopic("o5.prod", msg))
+
+	// Without a reply-to address, the reply goes to its topic like any other
+	msg.GetReply().ReplyTo = ""
+	assert.Equal(t, "o5.prod.test_req_res", messageToTopic("o5.prod", msg))
+}
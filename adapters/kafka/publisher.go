@@ -0,0 +1,128 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pentops/j5/lib/j5codec"
+	"github.com/pentops/log.go/log"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+type Publisher struct {
+	writer       *kafka.Writer
+	topicPrefix  string
+	partitionKey string
+}
+
+func NewPublisher(config KafkaConfig, envName string) (*Publisher, error) {
+	if len(config.Brokers) == 0 {
+		return nil, fmt.Errorf("missing $KAFKA_BROKERS")
+	}
+
+	prefix := config.TopicPrefix
+	if prefix == "" {
+		prefix = fmt.Sprintf("o5.%s", envName)
+	}
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(config.Brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		// Writes are synchronous, so the default of 1s would be added to every
+		// single message publish.
+		BatchTimeout: 10 * time.Millisecond,
+	}
+
+	return &Publisher{
+		writer:       writer,
+		topicPrefix:  prefix,
+		partitionKey: config.PartitionKey,
+	}, nil
+}
+
+func (p *Publisher) Close() error {
+	return p.writer.Close()
+}
+
+func (p *Publisher) buildRecord(message *messaging_pb.Message) (kafka.Message, error) {
+	body, err := j5codec.Global.ProtoToJSON(message.ProtoReflect())
+	if err != nil {
+		return kafka.Message{}, err
+	}
+
+	key := message.MessageId
+	if p.partitionKey != "" {
+		if val, ok := message.Headers[p.partitionKey]; ok && val != "" {
+			key = val
+		}
+	}
+
+	headers := make([]kafka.Header, 0, len(message.Headers)+3)
+	headers = append(headers,
+		kafka.Header{Key: contentTypeHeader, Value: []byte(o5MessageContentType)},
+		kafka.Header{Key: serviceHeader, Value: fmt.Appendf(nil, "/%s/%s", message.GrpcService, message.GrpcMethod)},
+		kafka.Header{Key: messageIDHeader, Value: []byte(message.MessageId)},
+	)
+	for k, v := range message.Headers {
+		headers = append(headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+
+	return kafka.Message{
+		Topic:   messageToTopic(p.topicPrefix, message),
+		Key:     []byte(key),
+		Value:   body,
+		Headers: headers,
+	}, nil
+}
+
+func (p *Publisher) Publish(ctx context.Context, message *messaging_pb.Message) error {
+	_, err := p.PublishBatch(ctx, []*messaging_pb.Message{message})
+	return err
+}
+
+func (p *Publisher) PublishBatch(ctx context.Context, messages []*messaging_pb.Message) ([]string, error) {
+	records := make([]kafka.Message, len(messages))
+	for idx, msg := range messages {
+		record, err := p.buildRecord(msg)
+		if err != nil {
+			return nil, err
+		}
+		records[idx] = record
+	}
+
+	err := p.writer.WriteMessages(ctx, records...)
+	if err == nil {
+		ids := make([]string, 0, len(messages))
+		for idx, msg := range messages {
+			log.WithFields(ctx, "topic", records[idx].Topic, "messageId", msg.MessageId).Info("Published to Kafka")
+			ids = append(ids, msg.MessageId)
+		}
+		return ids, nil
+	}
+
+	var writeErrors kafka.WriteErrors
+	if !errors.As(err, &writeErrors) {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(messages))
+	errs := make([]error, 0)
+	for idx, msg := range messages {
+		if writeErrors[idx] != nil {
+			log.WithFields(ctx, map[string]any{
+				"topic":     records[idx].Topic,
+				"messageId": msg.MessageId,
+				"error":     writeErrors[idx].Error(),
+			}).Error("Failed to publish to Kafka")
+			errs = append(errs, fmt.Errorf("message %s: %w", msg.MessageId, writeErrors[idx]))
+			continue
+		}
+		ids = append(ids, msg.MessageId)
+	}
+
+	return ids, errors.Join(errs...)
+}
@@ -0,0 +1,242 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pentops/log.go/log"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_tpb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/tracing"
+	"github.com/pentops/o5-runtime-sidecar/apps/queueworker/messaging"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+const RawMessageName = "/o5.messaging.v1.topic.RawMessageTopic/Raw"
+
+// maxErrorDelay caps the backoff after broker and dead letter errors
+const maxErrorDelay = time.Minute
+
+type reader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Worker consumes from a consumer group. Offsets are committed only once a
+// record has been handled or dead-lettered, so delivery is at-least-once.
+// Kafka has no per-record redelivery, so failed records are retried in place
+// with a backoff, which holds up the partition until they succeed or use up
+// their attempts. They are then dead-lettered, or skipped when there is no
+// dead letter handler. Broker and dead letter errors are logged and retried
+// with a backoff, Run only returns once the context is done.
+type Worker struct {
+	reader            reader
+	groupID           string
+	maxAttempts       int
+	retryDelay        time.Duration
+	errorDelay        time.Duration
+	handler           messaging.Handler
+	deadLetterHandler messaging.DeadLetterHandler
+	readiness         messaging.Readiness
+}
+
+func NewWorker(config KafkaConfig, handler messaging.Handler, deadLetter messaging.DeadLetterHandler) (*Worker, error) {
+	if len(config.Brokers) == 0 {
+		return nil, fmt.Errorf("missing $KAFKA_BROKERS")
+	}
+	if len(config.ConsumeTopics) == 0 {
+		return nil, fmt.Errorf("KAFKA_GROUP_ID set but KAFKA_CONSUME_TOPICS is empty")
+	}
+
+	rr := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     config.Brokers,
+		GroupID:     config.GroupID,
+		GroupTopics: config.ConsumeTopics,
+		// 0 commits synchronously in CommitMessages
+		CommitInterval: 0,
+	})
+
+	return &Worker{
+		reader:            rr,
+		groupID:           config.GroupID,
+		maxAttempts:       max(config.MaxAttempts, 1),
+		retryDelay:        time.Second,
+		errorDelay:        time.Second,
+		handler:           handler,
+		deadLetterHandler: deadLetter,
+	}, nil
+}
+
+// SetReadiness pauses fetching while the app is not ready, rather than
+// retrying records against it.
+func (ww *Worker) SetReadiness(readiness messaging.Readiness) {
+	ww.readiness = readiness
+}
+
+func (ww *Worker) Run(ctx context.Context) error {
+	defer ww.reader.Close()
+
+	for {
+		if ww.readiness != nil {
+			if err := ww.readiness.WaitReady(ctx); err != nil {
+				return nil
+			}
+		}
+
+		// Errors only end the loop once the context is done, transient broker
+		// errors are retried rather than stopping the runtime.
+		var record kafka.Message
+		if err := ww.retry(ctx, "fetching message", func() error {
+			var err error
+			record, err = ww.reader.FetchMessage(ctx)
+			return err
+		}); err != nil {
+			return nil
+		}
+
+		if err := ww.handleRecord(ctx, record); err != nil {
+			return nil
+		}
+
+		if err := ww.retry(ctx, "committing offset", func() error {
+			return ww.reader.CommitMessages(ctx, record)
+		}); err != nil {
+			return nil
+		}
+	}
+}
+
+// retry calls fn until it succeeds, backing off after each error. It only
+// fails once the context is done.
+func (ww *Worker) retry(ctx context.Context, action string, fn func() error) error {
+	delay := ww.errorDelay
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		log.WithFields(ctx, map[string]any{
+			"error":      err.Error(),
+			"retryDelay": delay.String(),
+		}).Error("Worker: Error " + action + ", retrying")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxErrorDelay)
+	}
+}
+
+// handleRecord returns nil once the record can be committed, and only fails
+// once the context is done.
+func (ww *Worker) handleRecord(ctx context.Context, record kafka.Message) error {
+	ctx = log.WithFields(ctx, map[string]any{
+		"kafka-topic":     record.Topic,
+		"kafka-partition": record.Partition,
+		"kafka-offset":    record.Offset,
+	})
+	log.Info(ctx, "Message Handler: Received message")
+
+	msg, err := parseRecord(record)
+	if err != nil {
+		log.WithError(ctx, err).Error("Message Handler: Failed to parse message")
+		if ww.deadLetterHandler == nil {
+			// Retrying won't make it parse, and holding the partition on it
+			// would stop the worker entirely.
+			log.Error(ctx, "Message Handler: Skipping unparsable message, no dead letter handler")
+			return nil
+		}
+		return ww.retry(ctx, "dead-lettering message", func() error {
+			return ww.killMessage(ctx, record, nil, err)
+		})
+	}
+
+	ctx, span := tracing.StartConsumer(ctx, msg)
+	defer span.End()
+
+	attempt := 0
+	for {
+		attempt++
+		handlerError := ww.handler.HandleMessage(ctx, msg)
+		if handlerError == nil {
+			log.Info(ctx, "Message Handler: Success")
+			return nil
+		}
+		tracing.SetError(span, handlerError)
+
+		log.WithFields(ctx, "attempt", attempt, "error", handlerError.Error()).Error("Message Handler: Error")
+
+		if attempt >= ww.maxAttempts {
+			if ww.deadLetterHandler == nil {
+				log.Error(ctx, "Message Handler: Skipping after final attempt, no dead letter handler")
+				return nil
+			}
+			log.Info(ctx, "Message Handler: Killing after final attempt")
+			return ww.retry(ctx, "dead-lettering message", func() error {
+				return ww.killMessage(ctx, record, msg, handlerError)
+			})
+		}
+
+		delay := ww.retryDelay * time.Duration(1<<min(attempt-1, 6))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (ww *Worker) killMessage(ctx context.Context, record kafka.Message, msg *messaging_pb.Message, killError error) error {
+	if msg == nil {
+		// Unparsable message
+		msg = &messaging_pb.Message{
+			MessageId: uuid.New().String(),
+			Body: &messaging_pb.Any{
+				TypeUrl:  RawMessageName,
+				Encoding: messaging_pb.WireEncoding_RAW,
+				Value:    record.Value,
+			},
+		}
+	}
+
+	meta := map[string]string{
+		"topic":     record.Topic,
+		"partition": strconv.Itoa(record.Partition),
+		"offset":    strconv.FormatInt(record.Offset, 10),
+		"groupId":   ww.groupID,
+	}
+
+	for _, header := range record.Headers {
+		meta["header:"+header.Key] = string(header.Value)
+	}
+
+	problem := &messaging_tpb.Problem{
+		Type: &messaging_tpb.Problem_UnhandledError_{
+			UnhandledError: &messaging_tpb.Problem_UnhandledError{
+				Error: killError.Error(),
+			},
+		},
+	}
+
+	death := &messaging_tpb.DeadMessage{
+		DeathId: uuid.New().String(),
+		Problem: problem,
+		Message: msg,
+		Infra: &messaging_tpb.Infra{
+			Type:     "KAFKA",
+			Metadata: meta,
+		},
+	}
+
+	return ww.deadLetterHandler.DeadMessage(ctx, death)
+}
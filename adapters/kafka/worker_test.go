@@ -0,0 +1,285 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_tpb"
+	"github.com/pentops/o5-runtime-sidecar/apps/queueworker/messaging"
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeReader serves the records in order, then stops the worker. Handling
+// and commits are recorded in events, in the order they happen. The first
+// fetchErrors fetches and commitErrors commits fail.
+type fakeReader struct {
+	records      []kafka.Message
+	events       *[]string
+	cancel       context.CancelFunc
+	fetchErrors  int
+	commitErrors int
+}
+
+func (fr *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	if fr.fetchErrors > 0 {
+		fr.fetchErrors--
+		*fr.events = append(*fr.events, "fetch error")
+		return kafka.Message{}, errors.New("broker unavailable")
+	}
+	if len(fr.records) == 0 {
+		fr.cancel()
+		return kafka.Message{}, context.Canceled
+	}
+	record := fr.records[0]
+	fr.records = fr.records[1:]
+	return record, nil
+}
+
+func (fr *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if fr.commitErrors > 0 {
+		fr.commitErrors--
+		*fr.events = append(*fr.events, "commit error")
+		return errors.New("broker unavailable")
+	}
+	for _, msg := range msgs {
+		*fr.events = append(*fr.events, fmt.Sprintf("commit %d", msg.Offset))
+	}
+	return nil
+}
+
+func (fr *fakeReader) Close() error {
+	return nil
+}
+
+type deadLetters []*messaging_tpb.DeadMessage
+
+func (dl *deadLetters) DeadMessage(ctx context.Context, death *messaging_tpb.DeadMessage) error {
+	*dl = append(*dl, death)
+	return nil
+}
+
+// flakyDeadLetters fails the first failures deaths before accepting them.
+type flakyDeadLetters struct {
+	deadLetters
+	events   *[]string
+	failures int
+}
+
+func (dl *flakyDeadLetters) DeadMessage(ctx context.Context, death *messaging_tpb.DeadMessage) error {
+	if dl.failures > 0 {
+		dl.failures--
+		*dl.events = append(*dl.events, "dead letter error")
+		return errors.New("dead letter failed")
+	}
+	*dl.events = append(*dl.events, "dead letter")
+	return dl.deadLetters.DeadMessage(ctx, death)
+}
+
+func testRecords(t *testing.T, ids ...string) []kafka.Message {
+	publisher, err := NewPublisher(KafkaConfig{
+		Brokers: []string{"localhost:9092"},
+	}, "test")
+	require.NoError(t, err)
+
+	records := make([]kafka.Message, 0, len(ids))
+	for idx, id := range ids {
+		record, err := publisher.buildRecord(&messaging_pb.Message{
+			MessageId:   id,
+			GrpcService: "test.v1.FooTopic",
+			GrpcMethod:  "Foo",
+			Body: &messaging_pb.Any{
+				TypeUrl: "type.googleapis.com/test.v1.FooMessage",
+				Value:   []byte(id),
+			},
+		})
+		require.NoError(t, err)
+		record.Offset = int64(idx)
+		records = append(records, record)
+	}
+	return records
+}
+
+// runTestWorker runs a worker over the records until they are all handled,
+// failing each message ID the number of times given in failures.
+func runTestWorker(t *testing.T, records []kafka.Message, failures map[string]int, dlh messaging.DeadLetterHandler) []string {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	events := []string{}
+	ww := &Worker{
+		reader: &fakeReader{
+			records: records,
+			events:  &events,
+			cancel:  cancel,
+		},
+		groupID:     "group",
+		maxAttempts: 3,
+		retryDelay:  time.Millisecond,
+		handler: messaging.HandlerFunc(func(ctx context.Context, msg *messaging_pb.Message) error {
+			events = append(events, "handle "+msg.MessageId)
+			if failures[msg.MessageId] > 0 {
+				failures[msg.MessageId]--
+				return errors.New("handler failed")
+			}
+			return nil
+		}),
+		deadLetterHandler: dlh,
+	}
+
+	require.NoError(t, ww.Run(ctx))
+	return events
+}
+
+func TestWorkerCommitsAfterHandling(t *testing.T) {
+	events := runTestWorker(t, testRecords(t, "m1", "m2"), map[string]int{"m2": 1}, nil)
+
+	assert.Equal(t, []string{
+		"handle m1",
+		"commit 0",
+		"handle m2", // fails, retried in place
+		"handle m2",
+		"commit 1",
+	}, events)
+}
+
+func TestWorkerDeadLetters(t *testing.T) {
+	dead := &deadLetters{}
+	events := runTestWorker(t, testRecords(t, "m1", "m2"), map[string]int{"m1": 10}, dead)
+
+	assert.Equal(t, []string{
+		"handle m1",
+		"handle m1",
+		"handle m1",
+		"commit 0",
+		"handle m2",
+		"commit 1",
+	}, events)
+
+	require.Len(t, *dead, 1)
+	death := (*dead)[0]
+	assert.Equal(t, "m1", death.Message.MessageId)
+	assert.Equal(t, "handler failed", death.Problem.GetUnhandledError().Error)
+	assert.Equal(t, "KAFKA", death.Infra.Type)
+	assert.Equal(t, "0", death.Infra.Metadata["offset"])
+	assert.Equal(t, "group", death.Infra.Metadata["groupId"])
+}
+
+func TestWorkerSkipsWithoutDeadLetters(t *testing.T) {
+	events := runTestWorker(t, testRecords(t, "m1", "m2"), map[string]int{"m1": 10}, nil)
+
+	assert.Equal(t, []string{
+		"handle m1",
+		"handle m1",
+		"handle m1",
+		"commit 0",
+		"handle m2",
+		"commit 1",
+	}, events, "bounded, rather than holding the partition forever")
+}
+
+func TestWorkerUnparsable(t *testing.T) {
+	records := testRecords(t, "m1")
+	records[0].Headers = []kafka.Header{
+		{Key: contentTypeHeader, Value: []byte(o5MessageContentType)},
+	}
+	records[0].Value = []byte("not a message")
+
+	dead := &deadLetters{}
+	events := runTestWorker(t, records, nil, dead)
+
+	assert.Equal(t, []string{"commit 0"}, events)
+	require.Len(t, *dead, 1)
+	assert.Equal(t, RawMessageName, (*dead)[0].Message.Body.TypeUrl)
+	assert.Equal(t, []byte("not a message"), (*dead)[0].Message.Body.Value)
+}
+
+func TestWorkerRetriesBrokerErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	events := []string{}
+	dead := &flakyDeadLetters{events: &events, failures: 1}
+	ww := &Worker{
+		reader: &fakeReader{
+			records:      testRecords(t, "m1", "m2"),
+			events:       &events,
+			cancel:       cancel,
+			fetchErrors:  2,
+			commitErrors: 1,
+		},
+		maxAttempts: 1,
+		errorDelay:  time.Millisecond,
+		handler: messaging.HandlerFunc(func(ctx context.Context, msg *messaging_pb.Message) error {
+			events = append(events, "handle "+msg.MessageId)
+			if msg.MessageId == "m2" {
+				return errors.New("handler failed")
+			}
+			return nil
+		}),
+		deadLetterHandler: dead,
+	}
+
+	require.NoError(t, ww.Run(ctx), "only stops when the context is done")
+	assert.Equal(t, []string{
+		"fetch error",
+		"fetch error",
+		"handle m1",
+		"commit error",
+		"commit 0",
+		"handle m2",
+		"dead letter error", // retried without handling again
+		"dead letter",
+		"commit 1",
+	}, events)
+	assert.Len(t, dead.deadLetters, 1)
+}
+
+// recordedReadiness is always ready, recording each wait.
+type recordedReadiness struct {
+	events *[]string
+}
+
+func (rr recordedReadiness) Ready() error {
+	return nil
+}
+
+func (rr recordedReadiness) WaitReady(ctx context.Context) error {
+	*rr.events = append(*rr.events, "wait ready")
+	return nil
+}
+
+func TestWorkerWaitsForReadiness(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	events := []string{}
+	ww := &Worker{
+		reader: &fakeReader{
+			records: testRecords(t, "m1"),
+			events:  &events,
+			cancel:  cancel,
+		},
+		maxAttempts: 1,
+		handler: messaging.HandlerFunc(func(ctx context.Context, msg *messaging_pb.Message) error {
+			events = append(events, "handle "+msg.MessageId)
+			return nil
+		}),
+	}
+	ww.SetReadiness(recordedReadiness{events: &events})
+
+	require.NoError(t, ww.Run(ctx))
+	assert.Equal(t, []string{
+		"wait ready",
+		"handle m1",
+		"commit 0",
+		"wait ready",
+	}, events, "checked before each fetch, not while handling")
+}
@@ -1,11 +1,14 @@
 package msgconvert
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
 	"github.com/pentops/j5/lib/j5codec"
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/envelope"
+	"github.com/pentops/o5-runtime-sidecar/adapters/wire"
 	"github.com/pentops/o5-runtime-sidecar/sidecar"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
@@ -14,12 +17,17 @@ import (
 
 type ReflectionClient interface {
 	ProtoToJSON(protoreflect.Message) ([]byte, error)
+	JSONToProto([]byte, protoreflect.Message) error
 	FindMessageByName(protoreflect.FullName) (protoreflect.MessageType, error)
+	FindDescriptorByName(protoreflect.FullName) (protoreflect.Descriptor, error)
 }
 
 type Converter struct {
 	source     sidecar.AppInfo
 	reflection ReflectionClient
+	validation *validator
+	compressor *wire.Compressor
+	encrypter  *envelope.Encrypter
 }
 
 func NewConverter(source sidecar.AppInfo) *Converter {
@@ -32,7 +40,34 @@ func (ll *Converter) SetReflectionClient(reflection ReflectionClient) {
 	ll.reflection = reflection
 }
 
-func (ll *Converter) ParseMessage(id string, data []byte) (*messaging_pb.Message, error) {
+// SetValidation enables validating messages against the app's reflected
+// descriptors. Validation only runs once a reflection client is set.
+func (ll *Converter) SetValidation(config ValidationConfig) error {
+	vv, err := newValidator(config)
+	if err != nil {
+		return err
+	}
+	ll.validation = vv
+	return nil
+}
+
+// SetCompression enables compressing message bodies over the configured
+// threshold.
+func (ll *Converter) SetCompression(config wire.CompressionConfig) error {
+	cc, err := wire.NewCompressor(config)
+	if err != nil {
+		return err
+	}
+	ll.compressor = cc
+	return nil
+}
+
+// SetEncryption enables encrypting message bodies, after compression.
+func (ll *Converter) SetEncryption(encrypter *envelope.Encrypter) {
+	ll.encrypter = encrypter
+}
+
+func (ll *Converter) ParseMessage(ctx context.Context, id string, data []byte) (*messaging_pb.Message, error) {
 	msg := &messaging_pb.Message{}
 	if err := j5codec.Global.JSONToProto(data, msg.ProtoReflect()); err != nil {
 		return nil, fmt.Errorf("error unmarshalling outbox message: %w", err)
@@ -40,10 +75,10 @@ func (ll *Converter) ParseMessage(id string, data []byte) (*messaging_pb.Message
 
 	msg.MessageId = id
 
-	return ll.ConvertMessage(msg)
+	return ll.ConvertMessage(ctx, msg)
 }
 
-func (ll *Converter) ConvertMessage(msg *messaging_pb.Message) (*messaging_pb.Message, error) {
+func (ll *Converter) ConvertMessage(ctx context.Context, msg *messaging_pb.Message) (*messaging_pb.Message, error) {
 	msg.SourceApp = ll.source.SourceApp
 	msg.SourceEnv = ll.source.SourceEnv
 
@@ -52,6 +87,12 @@ func (ll *Converter) ConvertMessage(msg *messaging_pb.Message) (*messaging_pb.Me
 	}
 	msg.Headers["o5-sidecar-outbox-version"] = ll.source.SidecarVersion
 
+	if ll.validation != nil && ll.reflection != nil {
+		if err := ll.validation.check(ll.reflection, msg); err != nil {
+			return nil, err
+		}
+	}
+
 	// If we can, and the message isn't already in J5 (or raw), convert it to J5
 	if ll.reflection != nil {
 		switch msg.Body.Encoding {
@@ -73,7 +114,20 @@ func (ll *Converter) ConvertMessage(msg *messaging_pb.Message) (*messaging_pb.Me
 		// The receiver app copies the request field from the request to the reply.
 		// The generated code for the reply message copies the reply_to field to the
 		// message wrapper's Reply.ReplyTo field, allowing the queue subscription rules to filter the reply.
-		ext.Request.ReplyTo = fmt.Sprintf("%s/%s", ll.source.SourceEnv, ll.source.SourceApp)
+		ext.Request.ReplyTo = ll.source.ReplyTo()
+	}
+
+	// Compress last, validation and conversion need the plain body
+	if ll.compressor != nil {
+		if err := ll.compressor.Compress(msg); err != nil {
+			return nil, fmt.Errorf("error compressing message body: %w", err)
+		}
+	}
+
+	if ll.encrypter != nil {
+		if err := ll.encrypter.Encrypt(ctx, msg); err != nil {
+			return nil, fmt.Errorf("error encrypting message body: %w", err)
+		}
 	}
 
 	return msg, nil
@@ -0,0 +1,148 @@
+package msgconvert
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"buf.build/go/protovalidate"
+	"github.com/pentops/log.go/log"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	ValidationOff    = "off"
+	ValidationWarn   = "warn"
+	ValidationReject = "reject"
+)
+
+type ValidationConfig struct {
+	// off, warn (log invalid messages and send them anyway) or reject
+	MessageValidation string `env:"MESSAGE_VALIDATION" default:"off"`
+}
+
+// ValidationError is returned from ConvertMessage for a message which does
+// not match the app's descriptors. The message is included so that the caller
+// can dead-letter it.
+type ValidationError struct {
+	Message *messaging_pb.Message
+	Reason  string
+}
+
+func (ve *ValidationError) Error() string {
+	return fmt.Sprintf("invalid message %s: %s", ve.Message.MessageId, ve.Reason)
+}
+
+type validator struct {
+	reject    bool
+	validator protovalidate.Validator
+}
+
+func newValidator(config ValidationConfig) (*validator, error) {
+	switch config.MessageValidation {
+	case "", ValidationOff:
+		return nil, nil
+	case ValidationWarn, ValidationReject:
+	default:
+		return nil, fmt.Errorf("unknown MESSAGE_VALIDATION %q, expected off, warn or reject", config.MessageValidation)
+	}
+
+	pv, err := protovalidate.New()
+	if err != nil {
+		return nil, fmt.Errorf("creating protovalidate validator: %w", err)
+	}
+
+	return &validator{
+		reject:    config.MessageValidation == ValidationReject,
+		validator: pv,
+	}, nil
+}
+
+// check returns a ValidationError in reject mode, and only logs in warn mode.
+func (vv *validator) check(reflection ReflectionClient, msg *messaging_pb.Message) error {
+	reason := vv.validate(reflection, msg)
+	if reason == "" {
+		return nil
+	}
+
+	if vv.reject {
+		return &ValidationError{
+			Message: msg,
+			Reason:  reason,
+		}
+	}
+
+	log.WithFields(context.Background(), map[string]any{
+		"messageId":   msg.MessageId,
+		"grpcService": msg.GrpcService,
+		"grpcMethod":  msg.GrpcMethod,
+		"reason":      reason,
+	}).Warn("Sending invalid message")
+
+	return nil
+}
+
+// validate returns the reason the message is invalid, or an empty string.
+func (vv *validator) validate(reflection ReflectionClient, msg *messaging_pb.Message) string {
+	desc, err := reflection.FindDescriptorByName(protoreflect.FullName(msg.GrpcService))
+	if err != nil {
+		return fmt.Sprintf("service %s not found: %s", msg.GrpcService, err)
+	}
+
+	service, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return fmt.Sprintf("%s is not a service", msg.GrpcService)
+	}
+
+	method := service.Methods().ByName(protoreflect.Name(msg.GrpcMethod))
+	if method == nil {
+		return fmt.Sprintf("method %s not found on %s", msg.GrpcMethod, msg.GrpcService)
+	}
+
+	if msg.Body == nil {
+		return "message has no body"
+	}
+
+	if msg.Body.Encoding == messaging_pb.WireEncoding_RAW {
+		// Raw payloads are opaque
+		return ""
+	}
+
+	input := method.Input()
+	if msg.Body.TypeUrl == "" {
+		return "body has no type url"
+	}
+
+	typeName := strings.TrimPrefix(msg.Body.TypeUrl, "type.googleapis.com/")
+	if typeName != string(input.FullName()) {
+		return fmt.Sprintf("body type %s does not match %s input %s", typeName, msg.GrpcMethod, input.FullName())
+	}
+
+	body := dynamicpb.NewMessage(input)
+	switch msg.Body.Encoding {
+	case messaging_pb.WireEncoding_UNSPECIFIED:
+		err = proto.Unmarshal(msg.Body.Value, body)
+
+	case messaging_pb.WireEncoding_PROTOJSON:
+		err = protojson.Unmarshal(msg.Body.Value, body) // nolint:forbidigo
+
+	case messaging_pb.WireEncoding_J5_JSON:
+		err = reflection.JSONToProto(msg.Body.Value, body)
+
+	default:
+		return fmt.Sprintf("unknown body encoding %v", msg.Body.Encoding)
+	}
+	if err != nil {
+		return fmt.Sprintf("body does not decode as %s: %s", input.FullName(), err)
+	}
+
+	if err := vv.validator.Validate(body); err != nil {
+		return err.Error()
+	}
+
+	return ""
+}
@@ -0,0 +1,189 @@
+package msgconvert
+
+import (
+	"errors"
+	"testing"
+
+	"buf.build/gen/go/bufbuild/protovalidate/protocolbuffers/go/buf/validate"
+	"github.com/pentops/j5/lib/j5codec"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-runtime-sidecar/sidecar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// testReflection stands in for the app's reflection API, serving a file
+// declaring test.v1.ThingTopic, where the name field must not be empty.
+type testReflection struct {
+	files *protoregistry.Files
+}
+
+func newTestReflection(t *testing.T) *testReflection {
+	nameOptions := &descriptorpb.FieldOptions{}
+	proto.SetExtension(nameOptions, validate.E_Field, &validate.FieldRules{
+		Type: &validate.FieldRules_String_{
+			String_: &validate.StringRules{
+				MinLen: proto.Uint64(1),
+			},
+		},
+	})
+
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("test/v1/thing.proto"),
+		Package:    proto.String("test.v1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/empty.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("ThingMessage"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("name"),
+				JsonName: proto.String("name"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Options:  nameOptions,
+			}},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("ThingTopic"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Thing"),
+				InputType:  proto.String(".test.v1.ThingMessage"),
+				OutputType: proto.String(".google.protobuf.Empty"),
+			}},
+		}},
+	}
+
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	require.NoError(t, err)
+
+	files := &protoregistry.Files{}
+	require.NoError(t, files.RegisterFile(fd))
+
+	return &testReflection{files: files}
+}
+
+func (tr *testReflection) ProtoToJSON(msg protoreflect.Message) ([]byte, error) {
+	return j5codec.Global.ProtoToJSON(msg)
+}
+
+func (tr *testReflection) JSONToProto(data []byte, msg protoreflect.Message) error {
+	return j5codec.Global.JSONToProto(data, msg)
+}
+
+func (tr *testReflection) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	return tr.files.FindDescriptorByName(name)
+}
+
+func (tr *testReflection) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	desc, err := tr.FindDescriptorByName(name)
+	if err != nil {
+		return nil, err
+	}
+	msgDesc, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, errors.New("not a message")
+	}
+	return dynamicpb.NewMessageType(msgDesc), nil
+}
+
+func thingMessage(body string) *messaging_pb.Message {
+	return &messaging_pb.Message{
+		MessageId:   "m1",
+		GrpcService: "test.v1.ThingTopic",
+		GrpcMethod:  "Thing",
+		Body: &messaging_pb.Any{
+			TypeUrl:  "type.googleapis.com/test.v1.ThingMessage",
+			Encoding: messaging_pb.WireEncoding_PROTOJSON,
+			Value:    []byte(body),
+		},
+	}
+}
+
+func TestValidationReject(t *testing.T) {
+	conv := NewConverter(sidecar.AppInfo{SourceApp: "app", SourceEnv: "env"})
+	conv.SetReflectionClient(newTestReflection(t))
+	require.NoError(t, conv.SetValidation(ValidationConfig{MessageValidation: ValidationReject}))
+
+	_, err := conv.ConvertMessage(t.Context(), thingMessage(`{"name": "thing"}`))
+	require.NoError(t, err)
+
+	raw := thingMessage("not json")
+	raw.Body.TypeUrl = ""
+	raw.Body.Encoding = messaging_pb.WireEncoding_RAW
+	_, err = conv.ConvertMessage(t.Context(), raw)
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		mutate func(*messaging_pb.Message)
+		reason string
+	}{
+		"constraint": {
+			mutate: func(msg *messaging_pb.Message) {
+				msg.Body.Value = []byte(`{"name": ""}`)
+			},
+			reason: "string.min_len",
+		},
+		"unknown service": {
+			mutate: func(msg *messaging_pb.Message) {
+				msg.GrpcService = "test.v1.OtherTopic"
+			},
+			reason: "service test.v1.OtherTopic not found",
+		},
+		"unknown method": {
+			mutate: func(msg *messaging_pb.Message) {
+				msg.GrpcMethod = "Other"
+			},
+			reason: "method Other not found",
+		},
+		"no type url": {
+			mutate: func(msg *messaging_pb.Message) {
+				msg.Body.TypeUrl = ""
+			},
+			reason: "no type url",
+		},
+		"wrong type": {
+			mutate: func(msg *messaging_pb.Message) {
+				msg.Body.TypeUrl = "type.googleapis.com/test.v1.OtherMessage"
+			},
+			reason: "does not match",
+		},
+		"bad body": {
+			mutate: func(msg *messaging_pb.Message) {
+				msg.Body.Value = []byte(`{"name": 5}`)
+			},
+			reason: "does not decode",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			msg := thingMessage(`{"name": "thing"}`)
+			tc.mutate(msg)
+
+			_, err := conv.ConvertMessage(t.Context(), msg)
+			invalid := &ValidationError{}
+			require.ErrorAs(t, err, &invalid)
+			assert.Contains(t, invalid.Reason, tc.reason)
+			assert.Equal(t, "m1", invalid.Message.MessageId)
+		})
+	}
+}
+
+func TestValidationWarn(t *testing.T) {
+	conv := NewConverter(sidecar.AppInfo{SourceApp: "app", SourceEnv: "env"})
+	conv.SetReflectionClient(newTestReflection(t))
+	require.NoError(t, conv.SetValidation(ValidationConfig{MessageValidation: ValidationWarn}))
+
+	msg := thingMessage(`{"name": "thing"}`)
+	msg.GrpcMethod = "Other"
+	_, err := conv.ConvertMessage(t.Context(), msg)
+	assert.NoError(t, err)
+
+	assert.Error(t, conv.SetValidation(ValidationConfig{MessageValidation: "strict"}))
+}
@@ -0,0 +1,155 @@
+package nats
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/pentops/j5/lib/j5codec"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-runtime-sidecar/sidecar"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type NATSConfig struct {
+	URL           string `env:"NATS_URL" default:""`
+	SubjectPrefix string `env:"NATS_SUBJECT_PREFIX" default:""`
+
+	// Stream and Consumer name the durable pull consumer to work from. When
+	// FilterSubjects is set the consumer is created or updated on startup,
+	// otherwise it must already exist.
+	Stream         string        `env:"NATS_STREAM" default:""`
+	Consumer       string        `env:"NATS_CONSUMER" default:""`
+	FilterSubjects []string      `env:"NATS_FILTER_SUBJECTS" default:""`
+	MaxDeliver     int           `env:"NATS_MAX_DELIVER" default:"5"`
+	RetryDelay     time.Duration `env:"NATS_RETRY_DELAY" default:"5s"`
+}
+
+type Connector struct {
+	Config NATSConfig
+
+	dialLock sync.Mutex
+
+	_conn *nats.Conn
+	_js   jetstream.JetStream
+}
+
+func NewConnector(config NATSConfig) *Connector {
+	return &Connector{
+		Config: config,
+	}
+}
+
+// JetStream returns a JetStream client, connecting on first use. The
+// connection itself handles reconnects, so is only re-dialed once it has
+// been closed.
+func (c *Connector) JetStream() (jetstream.JetStream, error) {
+	c.dialLock.Lock()
+	defer c.dialLock.Unlock()
+
+	if c._js != nil && !c._conn.IsClosed() {
+		return c._js, nil
+	}
+
+	conn, err := nats.Connect(c.Config.URL,
+		nats.Name("o5-runtime-sidecar"),
+		nats.MaxReconnects(-1),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c._conn = conn
+	c._js = js
+	return c._js, nil
+}
+
+func (c *Connector) Close() {
+	c.dialLock.Lock()
+	defer c.dialLock.Unlock()
+	if c._conn != nil {
+		c._conn.Close()
+	}
+}
+
+const (
+	o5MessageContentType = "application/o5-message"
+
+	contentTypeHeader = "Content-Type"
+	serviceHeader     = "grpc-service"
+)
+
+/* Subject Breakdown:
+
+Subjects follow the AMQP routing keys, under a prefix which defaults to
+o5.{env}:
+
+foo.v1.FooService/FooMethod becomes {prefix}.service.foo/v1/FooService.FooMethod
+
+A consumer for all methods of a service filters on
+{prefix}.service.foo/v1/FooService.*
+
+Replies are addressed to the app which sent the request,
+{prefix}.reply.{env}.{app}.foo/v1/FooReplyTopic.FooReply, and that app filters
+on {prefix}.reply.{env}.{app}.>
+
+*/
+
+func messageToSubject(prefix string, message *messaging_pb.Message) string {
+	serviceSlash := strings.ReplaceAll(message.GrpcService, ".", "/")
+	if env, app, ok := sidecar.ReplyDestination(message); ok {
+		return fmt.Sprintf("%s.reply.%s.%s.%s.%s", prefix, env, app, serviceSlash, message.GrpcMethod)
+	}
+	return fmt.Sprintf("%s.service.%s.%s", prefix, serviceSlash, message.GrpcMethod)
+}
+
+var messageIDNamespace = uuid.MustParse("C4D1B6E2-3F8A-4E0B-9B7D-2A5C6E1F8D93")
+
+// parseMsg converts a JetStream message to an o5 message. Messages published
+// by another sidecar carry the whole message, anything else is wrapped as a
+// raw message.
+func parseMsg(subject string, headers nats.Header, data []byte, metadata *jetstream.MsgMetadata) (*messaging_pb.Message, error) {
+	if headers.Get(contentTypeHeader) == o5MessageContentType {
+		msg := &messaging_pb.Message{}
+		if err := j5codec.Global.JSONToProto(data, msg.ProtoReflect()); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal o5-message: %w", err)
+		}
+		return msg, nil
+	}
+
+	msg := &messaging_pb.Message{
+		Body: &messaging_pb.Any{
+			Encoding: messaging_pb.WireEncoding_RAW,
+			Value:    data,
+		},
+		GrpcService:      "o5.messaging.v1.topic.RawMessageTopic",
+		GrpcMethod:       "Raw",
+		DestinationTopic: subject,
+	}
+
+	if id := headers.Get(nats.MsgIdHdr); id != "" {
+		msg.MessageId = uuid.NewSHA1(messageIDNamespace, []byte(id)).String()
+	} else if metadata != nil {
+		msg.MessageId = uuid.NewSHA1(messageIDNamespace, fmt.Appendf(nil, "%s/%d", metadata.Stream, metadata.Sequence.Stream)).String()
+	} else {
+		msg.MessageId = uuid.New().String()
+	}
+
+	if metadata != nil {
+		msg.Timestamp = timestamppb.New(metadata.Timestamp)
+	} else {
+		msg.Timestamp = timestamppb.Now()
+	}
+
+	return msg, nil
+}
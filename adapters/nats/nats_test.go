@@ -0,0 +1,83 @@
+package nats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/pentops/j5/gen/j5/messaging/v1/messaging_j5pb"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-messaging/o5msg"
+	"github.com/pentops/o5-runtime-sidecar/testproto/gen/test/v1/test_tpb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishRoundTrip(t *testing.T) {
+	publisher, err := NewPublisher(NATSConfig{}, "test")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	msg := &messaging_pb.Message{
+		MessageId:   "6f4ad4b4-7c8e-4b2f-9a5e-0d0b1c2a3f4e",
+		GrpcService: "test.v1.FooTopic",
+		GrpcMethod:  "Foo",
+		Body: &messaging_pb.Any{
+			TypeUrl: "type.googleapis.com/test.v1.FooMessage",
+			Value:   []byte("FOOBAR"),
+		},
+	}
+
+	natsMsg, err := publisher.buildMsg(msg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, "o5.test.service.test/v1/FooTopic.Foo", natsMsg.Subject)
+	assert.Equal(t, msg.MessageId, natsMsg.Header.Get("Nats-Msg-Id"))
+
+	parsed, err := parseMsg(natsMsg.Subject, natsMsg.Header, natsMsg.Data, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, msg.MessageId, parsed.MessageId)
+	assert.Equal(t, msg.GrpcService, parsed.GrpcService)
+	assert.Equal(t, msg.GrpcMethod, parsed.GrpcMethod)
+	assert.Equal(t, "FOOBAR", string(parsed.Body.Value))
+}
+
+func TestParseRaw(t *testing.T) {
+	metadata := &jetstream.MsgMetadata{
+		Stream:    "EXTERNAL",
+		Sequence:  jetstream.SequencePair{Stream: 12},
+		Timestamp: time.Now(),
+	}
+
+	msg, err := parseMsg("external.thing", nil, []byte("Hello World!"), metadata)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, "o5.messaging.v1.topic.RawMessageTopic", msg.GrpcService)
+	assert.Equal(t, "Raw", msg.GrpcMethod)
+	assert.Equal(t, "external.thing", msg.DestinationTopic)
+	assert.Equal(t, messaging_pb.WireEncoding_RAW, msg.Body.Encoding)
+
+	again, err := parseMsg("external.thing", nil, []byte("Hello World!"), metadata)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, msg.MessageId, again.MessageId, "stable across redelivery")
+}
+
+func TestReplySubject(t *testing.T) {
+	msg, err := o5msg.WrapMessage(&test_tpb.TestReqResReplyMessage{
+		Request: &messaging_j5pb.RequestMetadata{
+			ReplyTo: "prod/requester",
+		},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, "o5.prod.reply.prod.requester.test/v1/topic/TestReqResReplyTopic.TestReqResReply", messageToSubject("o5.prod", msg))
+}
@@ -0,0 +1,110 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	nats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/pentops/j5/lib/j5codec"
+	"github.com/pentops/log.go/log"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+)
+
+type Publisher struct {
+	connector *Connector
+	prefix    string
+}
+
+func NewPublisher(config NATSConfig, envName string) (*Publisher, error) {
+	prefix := config.SubjectPrefix
+	if prefix == "" {
+		prefix = fmt.Sprintf("o5.%s", envName)
+	}
+
+	return &Publisher{
+		connector: NewConnector(config),
+		prefix:    prefix,
+	}, nil
+}
+
+func (p *Publisher) buildMsg(message *messaging_pb.Message) (*nats.Msg, error) {
+	data, err := j5codec.Global.ProtoToJSON(message.ProtoReflect())
+	if err != nil {
+		return nil, err
+	}
+
+	msg := nats.NewMsg(messageToSubject(p.prefix, message))
+	msg.Data = data
+	for k, v := range message.Headers {
+		msg.Header.Set(k, v)
+	}
+	msg.Header.Set(contentTypeHeader, o5MessageContentType)
+	msg.Header.Set(serviceHeader, fmt.Sprintf("/%s/%s", message.GrpcService, message.GrpcMethod))
+	// The stream de-duplicates on message ID within its duplicate window
+	msg.Header.Set(nats.MsgIdHdr, message.MessageId)
+
+	return msg, nil
+}
+
+func (p *Publisher) Publish(ctx context.Context, message *messaging_pb.Message) error {
+	_, err := p.PublishBatch(ctx, []*messaging_pb.Message{message})
+	return err
+}
+
+// PublishBatch publishes all messages asynchronously and then waits for the
+// publish ack of each, returning the IDs which were acknowledged.
+func (p *Publisher) PublishBatch(ctx context.Context, messages []*messaging_pb.Message) ([]string, error) {
+	js, err := p.connector.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	futures := make([]jetstream.PubAckFuture, len(messages))
+	errs := make([]error, 0)
+	for idx, message := range messages {
+		msg, err := p.buildMsg(message)
+		if err != nil {
+			return nil, err
+		}
+
+		future, err := js.PublishMsgAsync(msg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("message %s: %w", message.MessageId, err))
+			continue
+		}
+		futures[idx] = future
+	}
+
+	ids := make([]string, 0, len(messages))
+	for idx, future := range futures {
+		if future == nil {
+			continue
+		}
+		message := messages[idx]
+		select {
+		case <-ctx.Done():
+			return ids, errors.Join(append(errs, ctx.Err())...)
+
+		case ack := <-future.Ok():
+			log.WithFields(ctx, map[string]any{
+				"subject":   future.Msg().Subject,
+				"stream":    ack.Stream,
+				"sequence":  ack.Sequence,
+				"messageId": message.MessageId,
+			}).Info("Published to JetStream")
+			ids = append(ids, message.MessageId)
+
+		case err := <-future.Err():
+			log.WithFields(ctx, map[string]any{
+				"subject":   future.Msg().Subject,
+				"messageId": message.MessageId,
+				"error":     err.Error(),
+			}).Error("Failed to publish to JetStream")
+			errs = append(errs, fmt.Errorf("message %s: %w", message.MessageId, err))
+		}
+	}
+
+	return ids, errors.Join(errs...)
+}
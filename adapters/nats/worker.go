@@ -0,0 +1,216 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/pentops/log.go/log"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_tpb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/tracing"
+	"github.com/pentops/o5-runtime-sidecar/apps/queueworker/messaging"
+)
+
+const RawMessageName = "/o5.messaging.v1.topic.RawMessageTopic/Raw"
+
+// maxRetryDelay caps the exponential nak delay
+const maxRetryDelay = 5 * time.Minute
+
+// Messages are pulled in small batches, so that few wait out their AckWait
+// behind the one being handled.
+const (
+	fetchBatchSize = 10
+	fetchMaxWait   = 5 * time.Second
+)
+
+type Worker struct {
+	connector         *Connector
+	config            NATSConfig
+	handler           messaging.Handler
+	deadLetterHandler messaging.DeadLetterHandler
+	readiness         messaging.Readiness
+}
+
+func NewWorker(config NATSConfig, handler messaging.Handler, deadLetter messaging.DeadLetterHandler) (*Worker, error) {
+	if config.Stream == "" {
+		return nil, fmt.Errorf("NATS_CONSUMER set but NATS_STREAM is empty")
+	}
+
+	return &Worker{
+		connector:         NewConnector(config),
+		config:            config,
+		handler:           handler,
+		deadLetterHandler: deadLetter,
+	}, nil
+}
+
+// SetReadiness pauses pulling while the app is not ready, rather than taking
+// deliveries which would run out their AckWait and use up MaxDeliver.
+func (ww *Worker) SetReadiness(readiness messaging.Readiness) {
+	ww.readiness = readiness
+}
+
+func (ww *Worker) Run(ctx context.Context) error {
+	defer ww.connector.Close()
+
+	for {
+		err := ww.runLoopOnce(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.WithError(ctx, err).Error("Worker: Error in run loop, restarting")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (ww *Worker) consumer(ctx context.Context) (jetstream.Consumer, error) {
+	js, err := ww.connector.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ww.config.FilterSubjects) == 0 {
+		return js.Consumer(ctx, ww.config.Stream, ww.config.Consumer)
+	}
+
+	return js.CreateOrUpdateConsumer(ctx, ww.config.Stream, jetstream.ConsumerConfig{
+		Durable:        ww.config.Consumer,
+		FilterSubjects: ww.config.FilterSubjects,
+		AckPolicy:      jetstream.AckExplicitPolicy,
+		MaxDeliver:     ww.config.MaxDeliver,
+	})
+}
+
+func (ww *Worker) runLoopOnce(ctx context.Context) error {
+	cons, err := ww.consumer(ctx)
+	if err != nil {
+		return fmt.Errorf("binding consumer %s: %w", ww.config.Consumer, err)
+	}
+
+	for {
+		if ww.readiness != nil {
+			if err := ww.readiness.WaitReady(ctx); err != nil {
+				return err
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		batch, err := cons.Fetch(fetchBatchSize, jetstream.FetchMaxWait(fetchMaxWait))
+		if err != nil {
+			return err
+		}
+		for msg := range batch.Messages() {
+			if err := ww.handleMsg(ctx, msg); err != nil {
+				return err
+			}
+		}
+		if err := batch.Error(); err != nil {
+			return err
+		}
+	}
+}
+
+func (ww *Worker) handleMsg(ctx context.Context, jsMsg jetstream.Msg) error {
+	ctx = log.WithField(ctx, "subject", jsMsg.Subject())
+	log.Info(ctx, "Message Handler: Received message")
+
+	metadata, err := jsMsg.Metadata()
+	if err != nil {
+		return fmt.Errorf("message metadata: %w", err)
+	}
+	ctx = log.WithFields(ctx, "deliveryCount", metadata.NumDelivered, "streamSequence", metadata.Sequence.Stream)
+
+	msg, err := parseMsg(jsMsg.Subject(), jsMsg.Headers(), jsMsg.Data(), metadata)
+	if err != nil {
+		log.WithError(ctx, err).Error("Message Handler: Failed to parse message")
+		if ww.deadLetterHandler == nil {
+			return jsMsg.TermWithReason(err.Error())
+		}
+		return ww.killMessage(ctx, jsMsg, metadata, nil, err)
+	}
+
+	ctx, span := tracing.StartConsumer(ctx, msg)
+	defer span.End()
+
+	handlerError := ww.handler.HandleMessage(ctx, msg)
+	if handlerError == nil {
+		log.Info(ctx, "Message Handler: Success")
+		return jsMsg.Ack()
+	}
+	tracing.SetError(span, handlerError)
+	log.WithError(ctx, handlerError).Error("Message Handler: Error")
+
+	// MaxDeliver on the consumer stops redelivery after the last nak, so the
+	// message must be killed on the final attempt rather than after it.
+	if ww.config.MaxDeliver > 0 && metadata.NumDelivered >= uint64(ww.config.MaxDeliver) && ww.deadLetterHandler != nil {
+		log.Info(ctx, "Message Handler: Killing after max deliveries")
+		return ww.killMessage(ctx, jsMsg, metadata, msg, handlerError)
+	}
+
+	delay := ww.config.RetryDelay * time.Duration(1<<min(metadata.NumDelivered-1, 10))
+	delay = min(delay, maxRetryDelay)
+	log.WithField(ctx, "delay", delay.String()).Info("Message Handler: Nak with delay")
+	return jsMsg.NakWithDelay(delay)
+}
+
+func (ww *Worker) killMessage(ctx context.Context, jsMsg jetstream.Msg, metadata *jetstream.MsgMetadata, msg *messaging_pb.Message, killError error) error {
+	if msg == nil {
+		// Unparsable message
+		msg = &messaging_pb.Message{
+			MessageId: uuid.New().String(),
+			Body: &messaging_pb.Any{
+				TypeUrl:  RawMessageName,
+				Encoding: messaging_pb.WireEncoding_RAW,
+				Value:    jsMsg.Data(),
+			},
+		}
+	}
+
+	meta := map[string]string{
+		"subject":        jsMsg.Subject(),
+		"stream":         metadata.Stream,
+		"consumer":       metadata.Consumer,
+		"streamSequence": fmt.Sprint(metadata.Sequence.Stream),
+	}
+
+	for k := range jsMsg.Headers() {
+		meta["header:"+k] = jsMsg.Headers().Get(k)
+	}
+
+	problem := &messaging_tpb.Problem{
+		Type: &messaging_tpb.Problem_UnhandledError_{
+			UnhandledError: &messaging_tpb.Problem_UnhandledError{
+				Error: killError.Error(),
+			},
+		},
+	}
+
+	death := &messaging_tpb.DeadMessage{
+		DeathId: uuid.New().String(),
+		Problem: problem,
+		Message: msg,
+		Infra: &messaging_tpb.Infra{
+			Type:     "NATS",
+			Metadata: meta,
+		},
+	}
+
+	if err := ww.deadLetterHandler.DeadMessage(ctx, death); err != nil {
+		return err
+	}
+
+	if err := jsMsg.Term(); err != nil && !errors.Is(err, jetstream.ErrMsgAlreadyAckd) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_tpb"
+	"github.com/pentops/o5-runtime-sidecar/apps/queueworker/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMsg is a delivered JetStream message, recording how it was settled.
+// Methods the worker shouldn't call panic through the nil embedded Msg.
+type fakeMsg struct {
+	jetstream.Msg
+
+	subject      string
+	headers      nats.Header
+	data         []byte
+	numDelivered uint64
+
+	settled  []string
+	nakDelay time.Duration
+}
+
+func (fm *fakeMsg) Subject() string      { return fm.subject }
+func (fm *fakeMsg) Headers() nats.Header { return fm.headers }
+func (fm *fakeMsg) Data() []byte         { return fm.data }
+
+func (fm *fakeMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{
+		Sequence: jetstream.SequencePair{
+			Stream:   42,
+			Consumer: fm.numDelivered,
+		},
+		NumDelivered: fm.numDelivered,
+		Stream:       "stream",
+		Consumer:     "consumer",
+		Timestamp:    time.Now(),
+	}, nil
+}
+
+func (fm *fakeMsg) Ack() error {
+	fm.settled = append(fm.settled, "ack")
+	return nil
+}
+
+func (fm *fakeMsg) NakWithDelay(delay time.Duration) error {
+	fm.settled = append(fm.settled, "nak")
+	fm.nakDelay = delay
+	return nil
+}
+
+func (fm *fakeMsg) Term() error {
+	fm.settled = append(fm.settled, "term")
+	return nil
+}
+
+func (fm *fakeMsg) TermWithReason(reason string) error {
+	fm.settled = append(fm.settled, "term")
+	return nil
+}
+
+type deadLetters []*messaging_tpb.DeadMessage
+
+func (dl *deadLetters) DeadMessage(ctx context.Context, death *messaging_tpb.DeadMessage) error {
+	*dl = append(*dl, death)
+	return nil
+}
+
+func testMsg(numDelivered uint64) *fakeMsg {
+	return &fakeMsg{
+		subject:      "o5.test.raw",
+		headers:      nats.Header{"X-Custom": []string{"val"}},
+		data:         []byte("FOOBAR"),
+		numDelivered: numDelivered,
+	}
+}
+
+func testWorker(handlerErr error, dlh messaging.DeadLetterHandler) *Worker {
+	return &Worker{
+		config: NATSConfig{
+			MaxDeliver: 3,
+			RetryDelay: time.Second,
+		},
+		handler: messaging.HandlerFunc(func(ctx context.Context, msg *messaging_pb.Message) error {
+			return handlerErr
+		}),
+		deadLetterHandler: dlh,
+	}
+}
+
+func TestWorkerAck(t *testing.T) {
+	dead := &deadLetters{}
+	msg := testMsg(1)
+	require.NoError(t, testWorker(nil, dead).handleMsg(t.Context(), msg))
+
+	assert.Equal(t, []string{"ack"}, msg.settled)
+	assert.Empty(t, *dead)
+}
+
+func TestWorkerNakBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		numDelivered uint64
+		delay        time.Duration
+	}{
+		{numDelivered: 1, delay: time.Second},
+		{numDelivered: 2, delay: 2 * time.Second},
+		{numDelivered: 6, delay: 32 * time.Second},
+		{numDelivered: 100, delay: maxRetryDelay},
+	} {
+		// Without a dead letter handler, MaxDeliver is left to the consumer
+		msg := testMsg(tc.numDelivered)
+		require.NoError(t, testWorker(errors.New("handler failed"), nil).handleMsg(t.Context(), msg))
+
+		assert.Equal(t, []string{"nak"}, msg.settled, "delivery %d", tc.numDelivered)
+		assert.Equal(t, tc.delay, msg.nakDelay, "delivery %d", tc.numDelivered)
+	}
+}
+
+func TestWorkerDeadLetters(t *testing.T) {
+	dead := &deadLetters{}
+	ww := testWorker(errors.New("handler failed"), dead)
+
+	msg := testMsg(2)
+	require.NoError(t, ww.handleMsg(t.Context(), msg))
+	assert.Equal(t, []string{"nak"}, msg.settled)
+	assert.Empty(t, *dead)
+
+	msg = testMsg(3)
+	require.NoError(t, ww.handleMsg(t.Context(), msg))
+	assert.Equal(t, []string{"term"}, msg.settled, "killed on the final delivery")
+
+	require.Len(t, *dead, 1)
+	death := (*dead)[0]
+	assert.Equal(t, "handler failed", death.Problem.GetUnhandledError().Error)
+	assert.Equal(t, []byte("FOOBAR"), death.Message.Body.Value)
+	assert.Equal(t, "NATS", death.Infra.Type)
+	assert.Equal(t, map[string]string{
+		"subject":         "o5.test.raw",
+		"stream":          "stream",
+		"consumer":        "consumer",
+		"streamSequence":  "42",
+		"header:X-Custom": "val",
+	}, death.Infra.Metadata)
+}
+
+func TestWorkerUnparsable(t *testing.T) {
+	msg := testMsg(1)
+	msg.headers = nats.Header{contentTypeHeader: []string{o5MessageContentType}}
+	msg.data = []byte("not a message")
+
+	dead := &deadLetters{}
+	require.NoError(t, testWorker(nil, dead).handleMsg(t.Context(), msg))
+
+	assert.Equal(t, []string{"term"}, msg.settled)
+	require.Len(t, *dead, 1)
+	assert.Equal(t, RawMessageName, (*dead)[0].Message.Body.TypeUrl)
+	assert.Equal(t, []byte("not a message"), (*dead)[0].Message.Body.Value)
+
+	msg = testMsg(1)
+	msg.headers = nats.Header{contentTypeHeader: []string{o5MessageContentType}}
+	msg.data = []byte("not a message")
+	require.NoError(t, testWorker(nil, nil).handleMsg(t.Context(), msg))
+	assert.Equal(t, []string{"term"}, msg.settled, "terminated without a dead letter handler")
+}
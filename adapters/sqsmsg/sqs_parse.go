@@ -13,6 +13,7 @@ import (
 	"github.com/pentops/j5/lib/j5codec"
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
 	"github.com/pentops/o5-runtime-sidecar/adapters/eventbridge"
+	"github.com/pentops/o5-runtime-sidecar/adapters/wire"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -23,11 +24,17 @@ const (
 )
 
 type SNSMessageWrapper struct {
-	Type      string `json:"Type"`
-	Message   string `json:"Message"`
-	MessageID string `json:"MessageId"`
-	TopicArn  string `json:"TopicArn"`
-	Timestamp string `json:"Timestamp"`
+	Type              string                         `json:"Type"`
+	Message           string                         `json:"Message"`
+	MessageID         string                         `json:"MessageId"`
+	TopicArn          string                         `json:"TopicArn"`
+	Timestamp         string                         `json:"Timestamp"`
+	MessageAttributes map[string]SNSMessageAttribute `json:"MessageAttributes"`
+}
+
+type SNSMessageAttribute struct {
+	Type  string `json:"Type"`
+	Value string `json:"Value"`
 }
 
 var messageIDNamespace = uuid.MustParse("B71AFF66-460A-424C-8927-9AF8C9135BF9")
@@ -38,7 +45,26 @@ var SQSMessageAttributes = []string{
 	grpcMessageAttribute,
 }
 
+// ParseSQSMessage converts an SQS message to an o5 message, decompressing the
+// body if it was compressed by the publishing sidecar.
 func ParseSQSMessage(msg types.Message) (*messaging_pb.Message, error) {
+	parsed, err := parseSQSMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := wire.Decompress(parsed); err != nil {
+		return nil, fmt.Errorf("failed to decompress message: %w", err)
+	}
+
+	return parsed, nil
+}
+
+func parseSQSMessage(msg types.Message) (*messaging_pb.Message, error) {
+	if attr, ok := msg.MessageAttributes[contentTypeAttribute]; ok && attr.StringValue != nil && wire.IsO5Message(*attr.StringValue) {
+		return parseO5Message(*attr.StringValue, *msg.Body)
+	}
+
 	serviceNameAttributeValue, ok := msg.MessageAttributes[serviceAttribute]
 	if ok && serviceNameAttributeValue.StringValue != nil {
 		return parseServiceMessage(msg, *serviceNameAttributeValue.StringValue)
@@ -66,6 +92,11 @@ func ParseSQSMessage(msg types.Message) (*messaging_pb.Message, error) {
 	snsWrapper := &SNSMessageWrapper{}
 	if err := json.Unmarshal([]byte(*msg.Body), snsWrapper); err == nil {
 		if snsWrapper.Type == "Notification" && strings.HasPrefix(snsWrapper.TopicArn, "arn:aws:sns:") {
+			// Without raw message delivery, the attributes are in the wrapper
+			if attr, ok := snsWrapper.MessageAttributes[contentTypeAttribute]; ok && wire.IsO5Message(attr.Value) {
+				return parseO5Message(attr.Value, snsWrapper.Message)
+			}
+
 			topicParts := strings.Split(snsWrapper.TopicArn, ":")
 			if len(topicParts) != 6 {
 				return nil, fmt.Errorf("invalid SNS topic ARN: %s", snsWrapper.TopicArn)
@@ -99,6 +130,21 @@ func ParseSQSMessage(msg types.Message) (*messaging_pb.Message, error) {
 	return nil, fmt.Errorf("unsupported message format")
 }
 
+// parseO5Message decodes a whole o5 message published by another sidecar.
+// SQS bodies are text, so the protobuf encoding is base64 encoded.
+func parseO5Message(contentType string, body string) (*messaging_pb.Message, error) {
+	data := []byte(body)
+	if contentType == wire.O5MessageProtobufContentType {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode base64: %w", err)
+		}
+		data = decoded
+	}
+
+	return wire.Unmarshal(contentType, data)
+}
+
 func looksLikeJSON(body []byte) bool {
 	body = bytes.TrimLeft(body, " \t\n")
 	body = bytes.TrimRight(body, " \t\n")
@@ -11,6 +11,7 @@ import (
 	"github.com/pentops/log.go/log"
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_tpb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/tracing"
 	"github.com/pentops/o5-runtime-sidecar/apps/queueworker/messaging"
 )
 
@@ -112,8 +113,12 @@ func (ww *Worker) handleMessage(ctx context.Context, msg types.Message) {
 
 	ctx = log.WithField(ctx, "sqs-message-id", msg.MessageId)
 
+	ctx, span := tracing.StartConsumer(ctx, parsed)
+	defer span.End()
+
 	err = ww.router.HandleMessage(ctx, parsed)
 	if err != nil {
+		tracing.SetError(span, err)
 		ctx = log.WithError(ctx, err)
 		log.Error(ctx, "Message Handler: Error")
 		if ww.deadLetterHandler == nil && getReceiveCount(msg) <= 3 {
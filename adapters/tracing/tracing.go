@@ -0,0 +1,198 @@
+// Package tracing propagates W3C trace context through HTTP requests, gRPC
+// calls to the app and message headers, and exports the sidecar's spans.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-runtime-sidecar/sidecar"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+
+	instrumentationName = "github.com/pentops/o5-runtime-sidecar"
+	traceparentHeader   = "traceparent"
+)
+
+type TracingConfig struct {
+	// otlp or stdout, spans are not exported when empty, but trace context
+	// is still passed through.
+	Exporter string `env:"TRACING_EXPORTER" default:""`
+
+	OTLPEndpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT" default:""`
+	OTLPInsecure bool   `env:"OTEL_EXPORTER_OTLP_INSECURE" default:"false"`
+}
+
+// propagator is used directly rather than through the otel globals, so that
+// context is passed on whether or not an exporter is configured.
+var propagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// Provider exports the sidecar's spans until it is stopped.
+type Provider struct {
+	provider *sdktrace.TracerProvider
+}
+
+// NewProvider builds the configured exporter and installs it as the global
+// tracer provider.
+func NewProvider(ctx context.Context, config TracingConfig, info sidecar.AppInfo) (*Provider, error) {
+	var exporter sdktrace.SpanExporter
+	switch config.Exporter {
+	case ExporterOTLP:
+		opts := []otlptracegrpc.Option{}
+		if config.OTLPEndpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(config.OTLPEndpoint))
+		}
+		if config.OTLPInsecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		ee, err := otlptracegrpc.New(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("creating otlp exporter: %w", err)
+		}
+		exporter = ee
+
+	case ExporterStdout:
+		ee, err := stdouttrace.New()
+		if err != nil {
+			return nil, fmt.Errorf("creating stdout exporter: %w", err)
+		}
+		exporter = ee
+
+	default:
+		return nil, fmt.Errorf("unknown TRACING_EXPORTER %q, expected otlp or stdout", config.Exporter)
+	}
+
+	res := resource.NewSchemaless(
+		attribute.String("service.name", info.SourceApp),
+		attribute.String("deployment.environment", info.SourceEnv),
+		attribute.String("o5.sidecar.version", info.SidecarVersion),
+	)
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return &Provider{
+		provider: provider,
+	}, nil
+}
+
+// Run flushes remaining spans when the context is done.
+func (pp *Provider) Run(ctx context.Context) error {
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return pp.provider.Shutdown(shutdownCtx)
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// ExtractHTTP restores the trace context of an incoming request.
+func ExtractHTTP(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// ExtractIncoming restores the trace context of a gRPC call from the app.
+func ExtractIncoming(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return propagator.Extract(ctx, metadataCarrier(md))
+}
+
+// InjectOutgoing adds the trace context to a gRPC call to the app.
+func InjectOutgoing(ctx context.Context) context.Context {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	for key, val := range carrier {
+		ctx = metadata.AppendToOutgoingContext(ctx, key, val)
+	}
+	return ctx
+}
+
+// InjectMessage adds the trace context to the message headers, unless the app
+// already set its own.
+func InjectMessage(ctx context.Context, msg *messaging_pb.Message) {
+	if _, ok := msg.Headers[traceparentHeader]; ok {
+		return
+	}
+	if msg.Headers == nil {
+		msg.Headers = map[string]string{}
+	}
+	propagator.Inject(ctx, propagation.MapCarrier(msg.Headers))
+}
+
+// ExtractMessage restores the trace context from the message headers.
+func ExtractMessage(ctx context.Context, msg *messaging_pb.Message) context.Context {
+	if msg.Headers == nil {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(msg.Headers))
+}
+
+// StartConsumer starts the span for handling a received message, as a child
+// of the span which published it.
+func StartConsumer(ctx context.Context, msg *messaging_pb.Message) (context.Context, trace.Span) {
+	ctx = ExtractMessage(ctx, msg)
+	return Tracer().Start(ctx, "receive "+msg.GrpcService+"/"+msg.GrpcMethod,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.message.id", msg.MessageId),
+			attribute.String("o5.source.app", msg.SourceApp),
+			attribute.String("o5.source.env", msg.SourceEnv),
+		),
+	)
+}
+
+// SetError marks the span as failed.
+func SetError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+type metadataCarrier metadata.MD
+
+func (mc metadataCarrier) Get(key string) string {
+	vals := metadata.MD(mc).Get(key)
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+func (mc metadataCarrier) Set(key, value string) {
+	metadata.MD(mc).Set(key, value)
+}
+
+func (mc metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(mc))
+	for key := range mc {
+		keys = append(keys, key)
+	}
+	return keys
+}
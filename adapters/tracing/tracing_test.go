@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+func testSpan(t *testing.T) (context.Context, trace.Span, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, span := provider.Tracer("test").Start(t.Context(), "test")
+	t.Cleanup(func() { span.End() })
+	return ctx, span, recorder
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	ctx, span, _ := testSpan(t)
+
+	msg := &messaging_pb.Message{
+		MessageId:   "msg-1",
+		GrpcService: "test.v1.FooTopic",
+		GrpcMethod:  "Foo",
+	}
+	InjectMessage(ctx, msg)
+	require.Contains(t, msg.Headers, traceparentHeader)
+
+	received := trace.SpanContextFromContext(ExtractMessage(context.Background(), msg))
+	assert.Equal(t, span.SpanContext().TraceID(), received.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), received.SpanID())
+	assert.True(t, received.IsRemote())
+}
+
+func TestInjectMessageKeepsAppTrace(t *testing.T) {
+	ctx, _, _ := testSpan(t)
+
+	appParent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	msg := &messaging_pb.Message{
+		Headers: map[string]string{
+			traceparentHeader: appParent,
+		},
+	}
+	InjectMessage(ctx, msg)
+	assert.Equal(t, appParent, msg.Headers[traceparentHeader])
+}
+
+func TestGRPCRoundTrip(t *testing.T) {
+	ctx, span, _ := testSpan(t)
+
+	outgoing, ok := metadata.FromOutgoingContext(InjectOutgoing(ctx))
+	require.True(t, ok)
+
+	incoming := metadata.NewIncomingContext(context.Background(), outgoing)
+	received := trace.SpanContextFromContext(ExtractIncoming(incoming))
+	assert.Equal(t, span.SpanContext().TraceID(), received.TraceID())
+}
@@ -0,0 +1,157 @@
+package wire
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+)
+
+// ContentEncodingHeader is set on messages with a compressed body, to the
+// algorithm used. The body type URL and encoding describe the uncompressed
+// value.
+const ContentEncodingHeader = "o5-content-encoding"
+
+const (
+	CompressionOff  = "off"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// maxDecompressedBytes bounds the size of a decompressed body, so that a
+// small message can't expand to exhaust memory.
+const maxDecompressedBytes = 64 << 20
+
+type CompressionConfig struct {
+	// off, gzip or zstd. Workers decompress either regardless of this setting.
+	MessageCompression string `env:"MESSAGE_COMPRESSION" default:"off"`
+
+	// Bodies smaller than this are sent uncompressed
+	CompressionThreshold int `env:"MESSAGE_COMPRESSION_THRESHOLD" default:"65536"`
+}
+
+type Compressor struct {
+	algorithm string
+	threshold int
+	zstd      *zstd.Encoder
+}
+
+// NewCompressor returns nil when compression is off.
+func NewCompressor(config CompressionConfig) (*Compressor, error) {
+	cc := &Compressor{
+		algorithm: config.MessageCompression,
+		threshold: config.CompressionThreshold,
+	}
+
+	switch config.MessageCompression {
+	case "", CompressionOff:
+		return nil, nil
+
+	case CompressionGzip:
+
+	case CompressionZstd:
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, fmt.Errorf("creating zstd encoder: %w", err)
+		}
+		cc.zstd = enc
+
+	default:
+		return nil, fmt.Errorf("unknown MESSAGE_COMPRESSION %q, expected off, gzip or zstd", config.MessageCompression)
+	}
+
+	return cc, nil
+}
+
+// Compress replaces the body value with its compressed form when it is over
+// the threshold, and sets the content encoding header.
+func (cc *Compressor) Compress(msg *messaging_pb.Message) error {
+	if msg.Body == nil || len(msg.Body.Value) < cc.threshold {
+		return nil
+	}
+
+	if _, ok := msg.Headers[ContentEncodingHeader]; ok {
+		// already compressed
+		return nil
+	}
+
+	var compressed []byte
+	switch cc.algorithm {
+	case CompressionGzip:
+		buf := &bytes.Buffer{}
+		gz := gzip.NewWriter(buf)
+		if _, err := gz.Write(msg.Body.Value); err != nil {
+			return fmt.Errorf("gzip: %w", err)
+		}
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("gzip: %w", err)
+		}
+		compressed = buf.Bytes()
+
+	case CompressionZstd:
+		compressed = cc.zstd.EncodeAll(msg.Body.Value, nil)
+	}
+
+	if msg.Headers == nil {
+		msg.Headers = map[string]string{}
+	}
+	msg.Headers[ContentEncodingHeader] = cc.algorithm
+	msg.Body.Value = compressed
+	return nil
+}
+
+var zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+	return zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedBytes))
+})
+
+// Decompress restores a body compressed by Compress and removes the header.
+// Messages without the header are left as they are, so it is safe to call
+// more than once. Encrypted bodies are left for the handler to decompress
+// after decrypting.
+func Decompress(msg *messaging_pb.Message) error {
+	algorithm, ok := msg.Headers[ContentEncodingHeader]
+	if !ok || IsEncrypted(msg) {
+		return nil
+	}
+
+	if msg.Body == nil {
+		return fmt.Errorf("compressed message has no body")
+	}
+
+	var value []byte
+	switch algorithm {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(msg.Body.Value))
+		if err != nil {
+			return fmt.Errorf("gzip: %w", err)
+		}
+		value, err = io.ReadAll(io.LimitReader(gz, maxDecompressedBytes+1))
+		if err != nil {
+			return fmt.Errorf("gzip: %w", err)
+		}
+		if len(value) > maxDecompressedBytes {
+			return fmt.Errorf("gzip: decompressed body exceeds %d bytes", maxDecompressedBytes)
+		}
+
+	case CompressionZstd:
+		dec, err := zstdDecoder()
+		if err != nil {
+			return fmt.Errorf("creating zstd decoder: %w", err)
+		}
+		value, err = dec.DecodeAll(msg.Body.Value, nil)
+		if err != nil {
+			return fmt.Errorf("zstd: %w", err)
+		}
+
+	default:
+		return fmt.Errorf("unknown %s %q", ContentEncodingHeader, algorithm)
+	}
+
+	delete(msg.Headers, ContentEncodingHeader)
+	msg.Body.Value = value
+	return nil
+}
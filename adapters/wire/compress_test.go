@@ -0,0 +1,81 @@
+package wire
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func bodyMessage(value []byte) *messaging_pb.Message {
+	return &messaging_pb.Message{
+		MessageId: "m1",
+		Body: &messaging_pb.Any{
+			TypeUrl:  "type.googleapis.com/test.v1.FooMessage",
+			Encoding: messaging_pb.WireEncoding_J5_JSON,
+			Value:    value,
+		},
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	large := bytes.Repeat([]byte(`{"name": "thing"},`), 100)
+
+	for _, algorithm := range []string{CompressionGzip, CompressionZstd} {
+		t.Run(algorithm, func(t *testing.T) {
+			cc, err := NewCompressor(CompressionConfig{
+				MessageCompression:   algorithm,
+				CompressionThreshold: 100,
+			})
+			require.NoError(t, err)
+
+			small := bodyMessage([]byte(`{"name": "thing"}`))
+			require.NoError(t, cc.Compress(small))
+			assert.NotContains(t, small.Headers, ContentEncodingHeader, "under threshold")
+
+			msg := bodyMessage(bytes.Clone(large))
+			require.NoError(t, cc.Compress(msg))
+			assert.Equal(t, algorithm, msg.Headers[ContentEncodingHeader])
+			assert.Less(t, len(msg.Body.Value), len(large))
+
+			// Compressing again is a no-op
+			compressed := bytes.Clone(msg.Body.Value)
+			require.NoError(t, cc.Compress(msg))
+			assert.Equal(t, compressed, msg.Body.Value)
+
+			// Round trip through the protobuf wire encoding
+			data, err := Marshal(O5MessageProtobufContentType, msg)
+			require.NoError(t, err)
+			parsed, err := Unmarshal(O5MessageProtobufContentType, data)
+			require.NoError(t, err)
+
+			require.NoError(t, Decompress(parsed))
+			assert.Equal(t, large, parsed.Body.Value)
+			assert.NotContains(t, parsed.Headers, ContentEncodingHeader)
+
+			// Decompressing again is a no-op
+			require.NoError(t, Decompress(parsed))
+			assert.Equal(t, large, parsed.Body.Value)
+		})
+	}
+}
+
+func TestCompressConfig(t *testing.T) {
+	cc, err := NewCompressor(CompressionConfig{MessageCompression: CompressionOff})
+	require.NoError(t, err)
+	assert.Nil(t, cc)
+
+	_, err = NewCompressor(CompressionConfig{MessageCompression: "brotli"})
+	assert.Error(t, err)
+}
+
+func TestDecompressInvalid(t *testing.T) {
+	msg := bodyMessage([]byte("not compressed"))
+	msg.Headers = map[string]string{ContentEncodingHeader: CompressionGzip}
+	assert.Error(t, Decompress(msg))
+
+	msg.Headers[ContentEncodingHeader] = "brotli"
+	assert.Error(t, Decompress(msg))
+}
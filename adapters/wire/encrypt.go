@@ -0,0 +1,18 @@
+package wire
+
+import "github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+
+// Headers set on messages with an encrypted body, see package envelope. The
+// body is encrypted with a data key, which is itself encrypted under the key
+// named in the key ID header, so that keys can be rotated while older
+// messages are still in flight.
+const (
+	EncryptionHeader       = "o5-encryption"
+	EncryptionKeyIDHeader  = "o5-encryption-key-id"
+	EncryptedDataKeyHeader = "o5-encrypted-data-key"
+)
+
+func IsEncrypted(msg *messaging_pb.Message) bool {
+	_, ok := msg.Headers[EncryptionHeader]
+	return ok
+}
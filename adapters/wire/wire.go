@@ -0,0 +1,70 @@
+// Package wire encodes whole o5 messages for transports which carry the
+// message envelope, rather than just the body, between sidecars.
+package wire
+
+import (
+	"fmt"
+
+	"github.com/pentops/j5/lib/j5codec"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// O5MessageContentType is the j5 JSON encoding of messaging_pb.Message
+	O5MessageContentType = "application/o5-message"
+
+	// O5MessageProtobufContentType is the protobuf wire encoding of
+	// messaging_pb.Message
+	O5MessageProtobufContentType = "application/o5-message+protobuf"
+)
+
+const (
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf"
+)
+
+// ContentType returns the content type for a configured encoding name.
+func ContentType(encoding string) (string, error) {
+	switch encoding {
+	case "", EncodingJSON:
+		return O5MessageContentType, nil
+	case EncodingProtobuf:
+		return O5MessageProtobufContentType, nil
+	default:
+		return "", fmt.Errorf("unknown wire encoding %q, expected json or protobuf", encoding)
+	}
+}
+
+// IsO5Message returns true for the content types of either encoding.
+func IsO5Message(contentType string) bool {
+	return contentType == O5MessageContentType || contentType == O5MessageProtobufContentType
+}
+
+func Marshal(contentType string, msg *messaging_pb.Message) ([]byte, error) {
+	switch contentType {
+	case O5MessageContentType:
+		return j5codec.Global.ProtoToJSON(msg.ProtoReflect())
+	case O5MessageProtobufContentType:
+		return proto.Marshal(msg)
+	default:
+		return nil, fmt.Errorf("unknown o5 message content type %q", contentType)
+	}
+}
+
+func Unmarshal(contentType string, data []byte) (*messaging_pb.Message, error) {
+	msg := &messaging_pb.Message{}
+	switch contentType {
+	case O5MessageContentType:
+		if err := j5codec.Global.JSONToProto(data, msg.ProtoReflect()); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal o5-message: %w", err)
+		}
+	case O5MessageProtobufContentType:
+		if err := proto.Unmarshal(data, msg); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal o5-message protobuf: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown o5 message content type %q", contentType)
+	}
+	return msg, nil
+}
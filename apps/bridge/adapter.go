@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/pentops/log.go/log"
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_tpb"
+	"github.com/pentops/o5-runtime-sidecar/gen/o5/sidecar/v1/sidecar_spb"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 )
@@ -14,24 +17,66 @@ import (
 type BridgeConfig struct {
 	// Port to expose locally to the running service(s). 0 disables
 	AdapterAddr string `env:"ADAPTER_ADDR" default:""`
+
+	// Maximum number of messages passed to the publisher in one call, for
+	// both SendBatch and concurrent unary Sends. 10 is the EventBridge
+	// PutEvents limit.
+	BatchSize int `env:"ADAPTER_BATCH_SIZE" default:"10"`
+
+	// Number of publisher calls which unary Sends may have in flight at once,
+	// Sends beyond this queue and are published together.
+	BatchConcurrency int `env:"ADAPTER_BATCH_CONCURRENCY" default:"4"`
+
+	// Directory for the durable spool. When set, Send acknowledges once the
+	// message is written to disk, and a background forwarder publishes it.
+	SpoolDir          string        `env:"ADAPTER_SPOOL_DIR" default:""`
+	SpoolMaxBytes     int           `env:"ADAPTER_SPOOL_MAX_BYTES" default:"1073741824"`
+	SpoolSegmentBytes int           `env:"ADAPTER_SPOOL_SEGMENT_BYTES" default:"16777216"`
+	SpoolRetryDelay   time.Duration `env:"ADAPTER_SPOOL_RETRY_DELAY" default:"5s"`
+
+	// Failed forwards back off exponentially up to this delay, and are retried
+	// until they succeed.
+	SpoolMaxRetryDelay time.Duration `env:"ADAPTER_SPOOL_MAX_RETRY_DELAY" default:"5m"`
 }
 
 type App struct {
 	addr      string
 	server    *grpc.Server
+	spool     *Spool
 	listening chan struct{}
 }
 
-func NewApp(bind string, sender Publisher, conv Converter) *App {
-	messageBridge := NewMessageBridge(sender, conv)
+func NewApp(config BridgeConfig, sender Publisher, conv Converter) (*App, error) {
+	messageBridge := NewMessageBridge(config, sender, conv)
+
+	var spool *Spool
+	if config.SpoolDir != "" {
+		var err error
+		spool, err = OpenSpool(SpoolConfig{
+			Dir:           config.SpoolDir,
+			MaxBytes:      config.SpoolMaxBytes,
+			SegmentBytes:  config.SpoolSegmentBytes,
+			BatchSize:     messageBridge.batchSize,
+			RetryDelay:    config.SpoolRetryDelay,
+			MaxRetryDelay: config.SpoolMaxRetryDelay,
+		}, sender)
+		if err != nil {
+			return nil, fmt.Errorf("opening spool: %w", err)
+		}
+		messageBridge.spool = spool
+	}
+
 	server := grpc.NewServer()
 	messaging_tpb.RegisterMessageBridgeTopicServer(server, messageBridge)
+	sidecar_spb.RegisterMessageBridgeBatchServiceServer(server, messageBridge)
 	reflection.Register(server)
 
 	return &App{
-		addr:   bind,
-		server: server,
-	}
+		addr:      config.AdapterAddr,
+		server:    server,
+		spool:     spool,
+		listening: make(chan struct{}),
+	}, nil
 }
 
 func (gg *App) Run(ctx context.Context) error {
@@ -45,12 +90,31 @@ func (gg *App) Run(ctx context.Context) error {
 
 	log.WithField(ctx, "addr", gg.addr).Info("Listening")
 
+	eg, ctx := errgroup.WithContext(ctx)
+
+	if gg.spool != nil {
+		// Replays anything left from a previous run before new messages.
+		eg.Go(func() error {
+			return gg.spool.Run(ctx)
+		})
+	}
+
 	go func() {
 		<-ctx.Done()
 		gg.server.GracefulStop()
 	}()
 
-	return gg.server.Serve(lis)
+	eg.Go(func() error {
+		return gg.server.Serve(lis)
+	})
+
+	err = eg.Wait()
+	if gg.spool != nil {
+		if closeErr := gg.spool.Close(); closeErr != nil {
+			log.WithError(ctx, closeErr).Error("Error closing spool")
+		}
+	}
+	return err
 }
 
 func (gg *App) Addr() string {
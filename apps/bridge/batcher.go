@@ -0,0 +1,116 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+)
+
+// sendBatcher groups concurrent unary Sends into PublishBatch calls.
+//
+// There is no linger timer: a flusher publishes the first message straight
+// away, and messages which arrive while the concurrency limit is reached
+// queue up and are published together by whichever flusher frees up first.
+// Callers only wait for their own message, not for the flushers to drain.
+type sendBatcher struct {
+	publisher   Publisher
+	maxSize     int
+	maxInFlight int
+
+	lock     sync.Mutex
+	pending  []*pendingSend
+	inFlight int
+}
+
+type pendingSend struct {
+	msg  *messaging_pb.Message
+	done chan error
+}
+
+func newSendBatcher(publisher Publisher, maxSize, maxInFlight int) *sendBatcher {
+	return &sendBatcher{
+		publisher:   publisher,
+		maxSize:     maxSize,
+		maxInFlight: maxInFlight,
+	}
+}
+
+func (sb *sendBatcher) send(ctx context.Context, msg *messaging_pb.Message) error {
+	ps := &pendingSend{
+		msg:  msg,
+		done: make(chan error, 1),
+	}
+
+	sb.lock.Lock()
+	sb.pending = append(sb.pending, ps)
+	if sb.inFlight < sb.maxInFlight {
+		sb.inFlight++
+		// The batch may contain messages from other callers, so the publish
+		// must not be cancelled when this caller goes away.
+		go sb.flush(context.WithoutCancel(ctx))
+	}
+	sb.lock.Unlock()
+
+	select {
+	case err := <-ps.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flush publishes pending messages until none are left, it holds one of the
+// in flight slots, which the caller has taken.
+func (sb *sendBatcher) flush(ctx context.Context) {
+	for {
+		sb.lock.Lock()
+		if len(sb.pending) == 0 {
+			sb.inFlight--
+			sb.lock.Unlock()
+			return
+		}
+
+		n := min(len(sb.pending), sb.maxSize)
+		batch := sb.pending[:n:n]
+		sb.pending = sb.pending[n:]
+		sb.lock.Unlock()
+
+		msgs := make([]*messaging_pb.Message, len(batch))
+		for idx, ps := range batch {
+			msgs[idx] = ps.msg
+		}
+
+		errs := publishBatch(ctx, sb.publisher, msgs)
+		for idx, ps := range batch {
+			ps.done <- errs[idx]
+		}
+	}
+}
+
+// publishBatch publishes the messages, returning the error for each message
+// which was not accepted by the publisher, in the same order as msgs.
+func publishBatch(ctx context.Context, publisher Publisher, msgs []*messaging_pb.Message) []error {
+	successIDs, err := publisher.PublishBatch(ctx, msgs)
+
+	published := make(map[string]bool, len(successIDs))
+	for _, id := range successIDs {
+		published[id] = true
+	}
+
+	errs := make([]error, len(msgs))
+	for idx, msg := range msgs {
+		if published[msg.MessageId] {
+			continue
+		}
+
+		if err != nil {
+			errs[idx] = err
+		} else {
+			errs[idx] = fmt.Errorf("message %s was not published", msg.MessageId)
+		}
+	}
+
+	return errs
+}
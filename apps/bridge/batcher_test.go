@@ -0,0 +1,65 @@
+package bridge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendBatcherReturnsOwnResult(t *testing.T) {
+	release := map[string]chan struct{}{
+		"m1": make(chan struct{}),
+		"m2": make(chan struct{}),
+	}
+	publisher := publisherFunc(func(ctx context.Context, msgs []*messaging_pb.Message) ([]string, error) {
+		ids := []string{}
+		for _, msg := range msgs {
+			<-release[msg.MessageId]
+			ids = append(ids, msg.MessageId)
+		}
+		return ids, nil
+	})
+	sb := newSendBatcher(publisher, 1, 1)
+
+	ctx := context.Background()
+	first := make(chan error)
+	go func() {
+		first <- sb.send(ctx, testMessage("m1"))
+	}()
+	assert.Eventually(t, func() bool {
+		sb.lock.Lock()
+		defer sb.lock.Unlock()
+		return sb.inFlight == 1 && len(sb.pending) == 0
+	}, time.Second, time.Millisecond)
+
+	second := make(chan error)
+	go func() {
+		second <- sb.send(ctx, testMessage("m2"))
+	}()
+
+	// m2 waits behind m1 for the only in flight slot
+	assert.Eventually(t, func() bool {
+		sb.lock.Lock()
+		defer sb.lock.Unlock()
+		return len(sb.pending) == 1 && sb.pending[0].msg.MessageId == "m2"
+	}, time.Second, time.Millisecond)
+
+	close(release["m1"])
+	select {
+	case err := <-first:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("m1 waited for m2 to be published")
+	}
+
+	close(release["m2"])
+	require.NoError(t, <-second)
+
+	sb.lock.Lock()
+	defer sb.lock.Unlock()
+	assert.Equal(t, 0, sb.inFlight)
+}
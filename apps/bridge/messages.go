@@ -2,43 +2,149 @@ package bridge
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_tpb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/msgconvert"
+	"github.com/pentops/o5-runtime-sidecar/adapters/tracing"
+	"github.com/pentops/o5-runtime-sidecar/gen/o5/sidecar/v1/sidecar_spb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
+// Publisher matches pgoutbox.Batcher, returning the IDs of the messages which
+// were successfully published.
 type Publisher interface {
-	Publish(ctx context.Context, msg *messaging_pb.Message) error
+	PublishBatch(ctx context.Context, msgs []*messaging_pb.Message) ([]string, error)
 }
 
 type Converter interface {
-	ConvertMessage(*messaging_pb.Message) (*messaging_pb.Message, error)
+	ConvertMessage(context.Context, *messaging_pb.Message) (*messaging_pb.Message, error)
 }
 
 type MessageBridge struct {
 	publisher Publisher
 	messaging_tpb.UnimplementedMessageBridgeTopicServer
+	sidecar_spb.UnimplementedMessageBridgeBatchServiceServer
 	converter Converter
+	batcher   *sendBatcher
+	batchSize int
+	spool     *Spool
 }
 
-func NewMessageBridge(publisher Publisher, converter Converter) *MessageBridge {
+func NewMessageBridge(config BridgeConfig, publisher Publisher, converter Converter) *MessageBridge {
+	batchSize := max(config.BatchSize, 1)
 	return &MessageBridge{
 		publisher: publisher,
 		converter: converter,
+		batcher:   newSendBatcher(publisher, batchSize, max(config.BatchConcurrency, 1)),
+		batchSize: batchSize,
 	}
 }
 
 func (mb *MessageBridge) Send(ctx context.Context, req *messaging_tpb.SendMessage) (*emptypb.Empty, error) {
-	msg, err := mb.converter.ConvertMessage(req.Message)
+	msg, err := mb.converter.ConvertMessage(ctx, req.Message)
 	if err != nil {
-		return nil, fmt.Errorf("couldn't convert message: %w", err)
+		return nil, convertError(err)
 	}
+	tracing.InjectMessage(tracing.ExtractIncoming(ctx), msg)
 
-	if err := mb.publisher.Publish(ctx, msg); err != nil {
+	if mb.spool != nil {
+		if err := mb.spool.Append(msg); err != nil {
+			return nil, spoolError(err)
+		}
+		return &emptypb.Empty{}, nil
+	}
+
+	if err := mb.batcher.send(ctx, msg); err != nil {
 		return nil, fmt.Errorf("couldn't send marshalled msg: %w", err)
 	}
 
 	return &emptypb.Empty{}, nil
 }
+
+func (mb *MessageBridge) SendBatch(ctx context.Context, req *sidecar_spb.SendBatchRequest) (*sidecar_spb.SendBatchResponse, error) {
+	traceCtx := tracing.ExtractIncoming(ctx)
+	results := make([]*sidecar_spb.SendResult, len(req.Messages))
+	converted := make([]*messaging_pb.Message, 0, len(req.Messages))
+	convertedIdx := make([]int, 0, len(req.Messages))
+
+	for idx, reqMsg := range req.Messages {
+		if reqMsg == nil {
+			results[idx] = &sidecar_spb.SendResult{
+				Error: "message is required",
+			}
+			continue
+		}
+
+		messageID := reqMsg.MessageId
+		msg, err := mb.converter.ConvertMessage(ctx, reqMsg)
+		if err != nil {
+			results[idx] = &sidecar_spb.SendResult{
+				MessageId: messageID,
+				Error:     convertError(err).Error(),
+			}
+			continue
+		}
+
+		tracing.InjectMessage(traceCtx, msg)
+		converted = append(converted, msg)
+		convertedIdx = append(convertedIdx, idx)
+	}
+
+	if mb.spool != nil {
+		err := mb.spool.Append(converted...)
+		for offset, msg := range converted {
+			result := &sidecar_spb.SendResult{
+				MessageId: msg.MessageId,
+				Published: err == nil,
+			}
+			if err != nil {
+				result.Error = spoolError(err).Error()
+			}
+			results[convertedIdx[offset]] = result
+		}
+
+		return &sidecar_spb.SendBatchResponse{
+			Results: results,
+		}, nil
+	}
+
+	for start := 0; start < len(converted); start += mb.batchSize {
+		end := min(start+mb.batchSize, len(converted))
+		errs := publishBatch(ctx, mb.publisher, converted[start:end])
+		for offset, err := range errs {
+			msg := converted[start+offset]
+			result := &sidecar_spb.SendResult{
+				MessageId: msg.MessageId,
+				Published: err == nil,
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			results[convertedIdx[start+offset]] = result
+		}
+	}
+
+	return &sidecar_spb.SendBatchResponse{
+		Results: results,
+	}, nil
+}
+
+func spoolError(err error) error {
+	if errors.Is(err, ErrSpoolFull) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return fmt.Errorf("couldn't spool msg: %w", err)
+}
+
+func convertError(err error) error {
+	invalid := &msgconvert.ValidationError{}
+	if errors.As(err, &invalid) {
+		return status.Error(codes.InvalidArgument, invalid.Error())
+	}
+	return fmt.Errorf("couldn't convert message: %w", err)
+}
@@ -0,0 +1,150 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_tpb"
+	"github.com/pentops/o5-runtime-sidecar/gen/o5/sidecar/v1/sidecar_spb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testConverter struct{}
+
+func (testConverter) ConvertMessage(ctx context.Context, msg *messaging_pb.Message) (*messaging_pb.Message, error) {
+	if msg.GrpcService == "" {
+		return nil, errors.New("missing service")
+	}
+	return msg, nil
+}
+
+type testPublisher struct {
+	lock    sync.Mutex
+	batches [][]string
+	fail    map[string]bool
+	err     error
+	block   chan struct{}
+}
+
+func (tp *testPublisher) PublishBatch(ctx context.Context, msgs []*messaging_pb.Message) ([]string, error) {
+	if tp.block != nil {
+		<-tp.block
+	}
+
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+
+	ids := []string{}
+	success := []string{}
+	for _, msg := range msgs {
+		ids = append(ids, msg.MessageId)
+		if !tp.fail[msg.MessageId] {
+			success = append(success, msg.MessageId)
+		}
+	}
+	tp.batches = append(tp.batches, ids)
+
+	return success, tp.err
+}
+
+func testMessage(id string) *messaging_pb.Message {
+	return &messaging_pb.Message{
+		MessageId:   id,
+		GrpcService: "test.v1.topic.TestTopic",
+		GrpcMethod:  "Test",
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	publisher := &testPublisher{
+		fail: map[string]bool{"m3": true},
+		err:  errors.New("publish failed"),
+	}
+	mb := NewMessageBridge(BridgeConfig{BatchSize: 2, BatchConcurrency: 1}, publisher, testConverter{})
+
+	res, err := mb.SendBatch(context.Background(), &sidecar_spb.SendBatchRequest{
+		Messages: []*messaging_pb.Message{
+			testMessage("m1"),
+			{MessageId: "bad"},
+			testMessage("m2"),
+			testMessage("m3"),
+			nil,
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, res.Results, 5)
+
+	assert.Equal(t, [][]string{{"m1", "m2"}, {"m3"}}, publisher.batches)
+
+	assert.Equal(t, "m1", res.Results[0].MessageId)
+	assert.True(t, res.Results[0].Published)
+
+	assert.Equal(t, "bad", res.Results[1].MessageId)
+	assert.False(t, res.Results[1].Published)
+	assert.Contains(t, res.Results[1].Error, "missing service")
+
+	assert.True(t, res.Results[2].Published)
+
+	assert.Equal(t, "m3", res.Results[3].MessageId)
+	assert.False(t, res.Results[3].Published)
+	assert.Equal(t, "publish failed", res.Results[3].Error)
+
+	assert.False(t, res.Results[4].Published)
+	assert.Equal(t, "message is required", res.Results[4].Error)
+}
+
+func TestSendMicroBatching(t *testing.T) {
+	publisher := &testPublisher{
+		fail:  map[string]bool{"m4": true},
+		block: make(chan struct{}),
+	}
+	mb := NewMessageBridge(BridgeConfig{BatchSize: 10, BatchConcurrency: 1}, publisher, testConverter{})
+
+	ctx := context.Background()
+	errs := map[string]error{}
+	var errLock sync.Mutex
+	var wg sync.WaitGroup
+	send := func(id string) {
+		defer wg.Done()
+		_, err := mb.Send(ctx, &messaging_tpb.SendMessage{Message: testMessage(id)})
+		errLock.Lock()
+		errs[id] = err
+		errLock.Unlock()
+	}
+
+	// The first send holds the only in-flight slot
+	wg.Add(1)
+	go send("m1")
+	assert.Eventually(t, func() bool {
+		mb.batcher.lock.Lock()
+		defer mb.batcher.lock.Unlock()
+		return mb.batcher.inFlight == 1
+	}, time.Second, time.Millisecond)
+
+	for _, id := range []string{"m2", "m3", "m4"} {
+		wg.Add(1)
+		go send(id)
+	}
+	assert.Eventually(t, func() bool {
+		mb.batcher.lock.Lock()
+		defer mb.batcher.lock.Unlock()
+		return len(mb.batcher.pending) == 3
+	}, time.Second, time.Millisecond)
+
+	close(publisher.block)
+	wg.Wait()
+
+	require.Len(t, publisher.batches, 2)
+	assert.Equal(t, []string{"m1"}, publisher.batches[0])
+	assert.ElementsMatch(t, []string{"m2", "m3", "m4"}, publisher.batches[1])
+
+	assert.NoError(t, errs["m1"])
+	assert.NoError(t, errs["m2"])
+	assert.NoError(t, errs["m3"])
+	assert.Error(t, errs["m4"])
+}
@@ -0,0 +1,481 @@
+package bridge
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pentops/log.go/log"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrSpoolFull = errors.New("spool is full")
+
+const (
+	segmentExt   = ".seg"
+	cursorFile   = "cursor"
+	recordHeader = 8 // uint32 length, uint32 crc32
+)
+
+type SpoolConfig struct {
+	Dir          string
+	MaxBytes     int
+	SegmentBytes int
+	BatchSize    int
+	RetryDelay   time.Duration
+
+	// MaxRetryDelay caps the backoff between attempts. Messages are kept
+	// until they are published, however long the publisher is down.
+	MaxRetryDelay time.Duration
+}
+
+// Spool is a write-ahead log of messages which have been acknowledged to the
+// app but not yet published.
+//
+// Messages are appended to numbered segment files, each record is
+// length-prefixed and checksummed. The forwarder reads from a cursor,
+// publishes, then persists the cursor and deletes fully forwarded segments,
+// so a restart replays anything which was spooled but not confirmed. Delivery
+// is at-least-once, a crash between publishing and saving the cursor
+// re-publishes the batch.
+type Spool struct {
+	config    SpoolConfig
+	publisher Publisher
+
+	lock       sync.Mutex
+	segments   []*segment // oldest first, the last is the active segment
+	active     *os.File
+	totalBytes int
+	notify     chan struct{}
+
+	cursor spoolPosition
+}
+
+type segment struct {
+	seq  uint64
+	size int
+}
+
+type spoolPosition struct {
+	seq    uint64
+	offset int
+}
+
+func OpenSpool(config SpoolConfig, publisher Publisher) (*Spool, error) {
+	if config.SegmentBytes <= 0 {
+		return nil, fmt.Errorf("spool segment size must be positive")
+	}
+	if config.MaxRetryDelay < config.RetryDelay {
+		return nil, fmt.Errorf("spool max retry delay %s is less than the retry delay %s", config.MaxRetryDelay, config.RetryDelay)
+	}
+	if config.MaxBytes < config.SegmentBytes {
+		return nil, fmt.Errorf("spool max size %d is less than the segment size %d", config.MaxBytes, config.SegmentBytes)
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating spool dir: %w", err)
+	}
+
+	sp := &Spool{
+		config:    config,
+		publisher: publisher,
+		notify:    make(chan struct{}, 1),
+	}
+
+	if err := sp.load(); err != nil {
+		return nil, err
+	}
+
+	return sp, nil
+}
+
+func (sp *Spool) load() error {
+	entries, err := os.ReadDir(sp.config.Dir)
+	if err != nil {
+		return fmt.Errorf("reading spool dir: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("stat spool segment %s: %w", name, err)
+		}
+
+		sp.segments = append(sp.segments, &segment{
+			seq:  seq,
+			size: int(info.Size()),
+		})
+	}
+
+	sort.Slice(sp.segments, func(i, j int) bool {
+		return sp.segments[i].seq < sp.segments[j].seq
+	})
+
+	for _, seg := range sp.segments {
+		sp.totalBytes += seg.size
+	}
+
+	cursor, err := sp.readCursor()
+	if err != nil {
+		return err
+	}
+	sp.cursor = cursor
+
+	// Segments before the cursor were forwarded, but the process stopped
+	// before they were removed.
+	if err := sp.removeBefore(cursor.seq); err != nil {
+		return err
+	}
+
+	// Never append to a segment from a previous run, the tail may be torn.
+	nextSeq := sp.cursor.seq
+	if len(sp.segments) > 0 {
+		if sp.segments[0].seq > sp.cursor.seq {
+			sp.cursor = spoolPosition{seq: sp.segments[0].seq}
+		}
+		nextSeq = sp.segments[len(sp.segments)-1].seq + 1
+	} else {
+		sp.cursor = spoolPosition{seq: nextSeq}
+	}
+
+	return sp.openSegment(nextSeq)
+}
+
+func (sp *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(sp.config.Dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// openSegment creates a new active segment, the caller must hold the lock (or
+// be loading).
+func (sp *Spool) openSegment(seq uint64) error {
+	file, err := os.OpenFile(sp.segmentPath(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("creating spool segment: %w", err)
+	}
+
+	if sp.active != nil {
+		if err := sp.active.Close(); err != nil {
+			return fmt.Errorf("closing spool segment: %w", err)
+		}
+	}
+
+	sp.active = file
+	sp.segments = append(sp.segments, &segment{seq: seq})
+	return nil
+}
+
+// Append durably writes the messages to the spool, all or nothing.
+func (sp *Spool) Append(msgs ...*messaging_pb.Message) error {
+	buf := []byte{}
+	for _, msg := range msgs {
+		data, err := proto.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("marshalling message %s: %w", msg.MessageId, err)
+		}
+
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(data)))
+		buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(data))
+		buf = append(buf, data...)
+	}
+
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+
+	if sp.active == nil {
+		return fmt.Errorf("spool is closed")
+	}
+
+	if sp.totalBytes+len(buf) > sp.config.MaxBytes {
+		return ErrSpoolFull
+	}
+
+	activeSeg := sp.segments[len(sp.segments)-1]
+	if activeSeg.size > 0 && activeSeg.size+len(buf) > sp.config.SegmentBytes {
+		if err := sp.openSegment(activeSeg.seq + 1); err != nil {
+			return err
+		}
+		activeSeg = sp.segments[len(sp.segments)-1]
+	}
+
+	if err := writeSynced(sp.active, buf); err != nil {
+		// Some or all of the records may be on disk beyond activeSeg.size, so
+		// are never read, but later appends would follow them and be read
+		// from the wrong offset. Start a clean segment.
+		if rotateErr := sp.openSegment(activeSeg.seq + 1); rotateErr != nil {
+			return errors.Join(err, rotateErr)
+		}
+		return err
+	}
+
+	activeSeg.size += len(buf)
+	sp.totalBytes += len(buf)
+
+	select {
+	case sp.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func writeSynced(file *os.File, buf []byte) error {
+	if _, err := file.Write(buf); err != nil {
+		return fmt.Errorf("writing spool: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("syncing spool: %w", err)
+	}
+	return nil
+}
+
+// Run forwards spooled messages to the publisher until the context is done.
+func (sp *Spool) Run(ctx context.Context) error {
+	for {
+		msgs, next, err := sp.readBatch(ctx)
+		if err != nil {
+			return err
+		}
+
+		if len(msgs) == 0 && next == sp.cursor {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-sp.notify:
+			}
+			continue
+		}
+
+		if err := sp.forward(ctx, msgs); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		if err := sp.commit(next); err != nil {
+			return err
+		}
+	}
+}
+
+// forward publishes the messages, retrying the failures with backoff until
+// all have been accepted.
+func (sp *Spool) forward(ctx context.Context, msgs []*messaging_pb.Message) error {
+	delay := sp.config.RetryDelay
+	for len(msgs) > 0 {
+		errs := publishBatch(ctx, sp.publisher, msgs)
+
+		failed := make([]*messaging_pb.Message, 0)
+		for idx, err := range errs {
+			if err != nil {
+				failed = append(failed, msgs[idx])
+			}
+		}
+		if len(failed) == 0 {
+			return nil
+		}
+
+		log.WithFields(ctx, map[string]any{
+			"failedCount": len(failed),
+			"retryDelay":  delay.String(),
+			"error":       errors.Join(errs...).Error(),
+		}).Warn("Failed to forward spooled messages, retrying")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay = min(delay*2, sp.config.MaxRetryDelay)
+		msgs = failed
+	}
+
+	return nil
+}
+
+// readBatch reads up to BatchSize records from the cursor, returning the
+// position after the last record read.
+func (sp *Spool) readBatch(ctx context.Context) ([]*messaging_pb.Message, spoolPosition, error) {
+	pos := sp.cursor
+	msgs := make([]*messaging_pb.Message, 0, sp.config.BatchSize)
+
+	for len(msgs) < sp.config.BatchSize {
+		sp.lock.Lock()
+		var seg *segment
+		var nextSeq uint64
+		for idx, s := range sp.segments {
+			if s.seq == pos.seq {
+				seg = s
+				if idx+1 < len(sp.segments) {
+					nextSeq = sp.segments[idx+1].seq
+				}
+				break
+			}
+		}
+		var limit int
+		if seg != nil {
+			limit = seg.size
+		}
+		sp.lock.Unlock()
+
+		if seg == nil {
+			return nil, pos, fmt.Errorf("spool segment %d is missing", pos.seq)
+		}
+
+		if pos.offset >= limit {
+			if nextSeq == 0 {
+				// Caught up with the active segment
+				break
+			}
+			pos = spoolPosition{seq: nextSeq}
+			continue
+		}
+
+		read, n, err := sp.readSegment(pos, limit, sp.config.BatchSize-len(msgs))
+		if err != nil {
+			return nil, pos, err
+		}
+		msgs = append(msgs, read...)
+		pos.offset += n
+
+		if n == 0 || (pos.offset < limit && len(msgs) < sp.config.BatchSize) {
+			// A torn or corrupt record, only possible at the tail of a
+			// segment from a crashed run. Skip the rest of the segment.
+			log.WithFields(ctx, map[string]any{
+				"segment": pos.seq,
+				"offset":  pos.offset,
+			}).Warn("Skipping corrupt spool record")
+			pos.offset = limit
+		}
+	}
+
+	return msgs, pos, nil
+}
+
+func (sp *Spool) readSegment(pos spoolPosition, limit int, count int) ([]*messaging_pb.Message, int, error) {
+	file, err := os.Open(sp.segmentPath(pos.seq))
+	if err != nil {
+		return nil, 0, fmt.Errorf("opening spool segment: %w", err)
+	}
+	defer file.Close()
+
+	reader := io.NewSectionReader(file, int64(pos.offset), int64(limit-pos.offset))
+	msgs := []*messaging_pb.Message{}
+	read := 0
+	header := make([]byte, recordHeader)
+	for len(msgs) < count {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			break
+		}
+		length := binary.BigEndian.Uint32(header[0:4])
+		checksum := binary.BigEndian.Uint32(header[4:8])
+		if int(length) > limit {
+			break
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(data) != checksum {
+			break
+		}
+
+		msg := &messaging_pb.Message{}
+		if err := proto.Unmarshal(data, msg); err != nil {
+			break
+		}
+
+		msgs = append(msgs, msg)
+		read += recordHeader + int(length)
+	}
+
+	return msgs, read, nil
+}
+
+// commit persists the cursor, then removes the segments it has moved past.
+func (sp *Spool) commit(pos spoolPosition) error {
+	data := []byte(fmt.Sprintf("%d %d\n", pos.seq, pos.offset))
+	tmpPath := filepath.Join(sp.config.Dir, cursorFile+".tmp")
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("writing spool cursor: %w", err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(sp.config.Dir, cursorFile)); err != nil {
+		return fmt.Errorf("writing spool cursor: %w", err)
+	}
+
+	sp.cursor = pos
+
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+	return sp.removeBefore(pos.seq)
+}
+
+func (sp *Spool) readCursor() (spoolPosition, error) {
+	data, err := os.ReadFile(filepath.Join(sp.config.Dir, cursorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return spoolPosition{}, nil
+	} else if err != nil {
+		return spoolPosition{}, fmt.Errorf("reading spool cursor: %w", err)
+	}
+
+	pos := spoolPosition{}
+	if _, err := fmt.Sscanf(string(data), "%d %d", &pos.seq, &pos.offset); err != nil {
+		return spoolPosition{}, fmt.Errorf("parsing spool cursor: %w", err)
+	}
+
+	return pos, nil
+}
+
+// removeBefore deletes segments older than seq, the caller must hold the lock
+// (or be loading).
+func (sp *Spool) removeBefore(seq uint64) error {
+	keep := sp.segments[:0]
+	for _, seg := range sp.segments {
+		if seg.seq >= seq {
+			keep = append(keep, seg)
+			continue
+		}
+
+		if err := os.Remove(sp.segmentPath(seg.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing spool segment: %w", err)
+		}
+		sp.totalBytes -= seg.size
+	}
+	sp.segments = keep
+	return nil
+}
+
+// Close closes the active segment, further appends fail.
+func (sp *Spool) Close() error {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+	if sp.active == nil {
+		return nil
+	}
+	err := sp.active.Close()
+	sp.active = nil
+	return err
+}
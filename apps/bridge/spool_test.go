@@ -0,0 +1,202 @@
+package bridge
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type publisherFunc func(ctx context.Context, msgs []*messaging_pb.Message) ([]string, error)
+
+func (pf publisherFunc) PublishBatch(ctx context.Context, msgs []*messaging_pb.Message) ([]string, error) {
+	return pf(ctx, msgs)
+}
+
+// collectingPublisher fails each message ID in failOnce the first time it is
+// published.
+type collectingPublisher struct {
+	lock      sync.Mutex
+	published []string
+	failOnce  map[string]bool
+}
+
+func (cp *collectingPublisher) PublishBatch(ctx context.Context, msgs []*messaging_pb.Message) ([]string, error) {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+
+	success := []string{}
+	for _, msg := range msgs {
+		if cp.failOnce[msg.MessageId] {
+			delete(cp.failOnce, msg.MessageId)
+			continue
+		}
+		success = append(success, msg.MessageId)
+		cp.published = append(cp.published, msg.MessageId)
+	}
+	return success, nil
+}
+
+func (cp *collectingPublisher) ids() []string {
+	cp.lock.Lock()
+	defer cp.lock.Unlock()
+	return append([]string{}, cp.published...)
+}
+
+func testSpoolConfig(dir string) SpoolConfig {
+	return SpoolConfig{
+		Dir:           dir,
+		MaxBytes:      1024 * 1024,
+		SegmentBytes:  1024,
+		BatchSize:     2,
+		RetryDelay:    time.Millisecond,
+		MaxRetryDelay: 4 * time.Millisecond,
+	}
+}
+
+func runSpool(t *testing.T, sp *Spool) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- sp.Run(ctx)
+	}()
+	return func() {
+		cancel()
+		assert.NoError(t, <-done)
+	}
+}
+
+func countSegments(t *testing.T, dir string) int {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	count := 0
+	for _, entry := range entries {
+		if entry.Name() != cursorFile {
+			count++
+		}
+	}
+	return count
+}
+
+func TestSpoolForward(t *testing.T) {
+	dir := t.TempDir()
+	publisher := &collectingPublisher{
+		failOnce: map[string]bool{"m2": true},
+	}
+
+	sp, err := OpenSpool(testSpoolConfig(dir), publisher)
+	require.NoError(t, err)
+	stop := runSpool(t, sp)
+
+	require.NoError(t, sp.Append(testMessage("m1"), testMessage("m2")))
+	require.NoError(t, sp.Append(testMessage("m3")))
+
+	assert.Eventually(t, func() bool {
+		return len(publisher.ids()) == 3
+	}, time.Second, time.Millisecond)
+	stop()
+
+	assert.Equal(t, []string{"m1", "m2", "m3"}, publisher.ids())
+	assert.Equal(t, 1, countSegments(t, dir))
+}
+
+func TestSpoolRetriesUntilForwarded(t *testing.T) {
+	dir := t.TempDir()
+
+	lock := sync.Mutex{}
+	attempts := []time.Time{}
+	publisher := &collectingPublisher{}
+	flaky := publisherFunc(func(ctx context.Context, msgs []*messaging_pb.Message) ([]string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts = append(attempts, time.Now())
+		if len(attempts) <= 20 {
+			return nil, assert.AnError
+		}
+		return publisher.PublishBatch(ctx, msgs)
+	})
+
+	sp, err := OpenSpool(testSpoolConfig(dir), flaky)
+	require.NoError(t, err)
+	stop := runSpool(t, sp)
+
+	require.NoError(t, sp.Append(testMessage("m1"), testMessage("m2")))
+	require.NoError(t, sp.Append(testMessage("m3")))
+
+	assert.Eventually(t, func() bool {
+		return len(publisher.ids()) == 3
+	}, 5*time.Second, time.Millisecond, "kept until the publisher recovers")
+	stop()
+
+	assert.Equal(t, []string{"m1", "m2", "m3"}, publisher.ids())
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.GreaterOrEqual(t, attempts[20].Sub(attempts[19]), 4*time.Millisecond, "backed off to the max delay")
+}
+
+func TestSpoolReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	failing := publisherFunc(func(ctx context.Context, msgs []*messaging_pb.Message) ([]string, error) {
+		return nil, assert.AnError
+	})
+
+	sp, err := OpenSpool(testSpoolConfig(dir), failing)
+	require.NoError(t, err)
+	stop := runSpool(t, sp)
+
+	ids := []string{}
+	for _, id := range []string{"m1", "m2", "m3", "m4", "m5", "m6"} {
+		msg := testMessage(id)
+		msg.Body = &messaging_pb.Any{Value: make([]byte, 200)}
+		require.NoError(t, sp.Append(msg))
+		ids = append(ids, id)
+	}
+
+	stop()
+	require.NoError(t, sp.Close())
+	assert.Greater(t, countSegments(t, dir), 1, "expected segment rotation")
+
+	// Truncate the last record, as if the process died mid-write
+	segPath := sp.segmentPath(sp.segments[len(sp.segments)-1].seq)
+	info, err := os.Stat(segPath)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(segPath, info.Size()-10))
+
+	publisher := &collectingPublisher{}
+	sp, err = OpenSpool(testSpoolConfig(dir), publisher)
+	require.NoError(t, err)
+	stop = runSpool(t, sp)
+
+	require.NoError(t, sp.Append(testMessage("m7")))
+
+	assert.Eventually(t, func() bool {
+		return len(publisher.ids()) == len(ids)
+	}, time.Second, time.Millisecond)
+	stop()
+
+	assert.Equal(t, []string{"m1", "m2", "m3", "m4", "m5", "m7"}, publisher.ids())
+	assert.Equal(t, 1, countSegments(t, dir))
+}
+
+func TestSpoolFull(t *testing.T) {
+	config := testSpoolConfig(t.TempDir())
+	config.MaxBytes = 1024
+
+	sp, err := OpenSpool(config, &collectingPublisher{})
+	require.NoError(t, err)
+
+	msg := testMessage("m1")
+	msg.Body = &messaging_pb.Any{Value: make([]byte, 600)}
+	require.NoError(t, sp.Append(msg))
+
+	msg = testMessage("m2")
+	msg.Body = &messaging_pb.Any{Value: make([]byte, 600)}
+	assert.ErrorIs(t, sp.Append(msg), ErrSpoolFull)
+}
@@ -0,0 +1,204 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/pentops/jwtauth/httpjwt"
+	"github.com/pentops/log.go/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// APIKeys is the document loaded from an APIKeySource.
+type APIKeys struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// APIKey maps the hash of a key to the claims passed to the app, as if they
+// came from a verified JWT.
+type APIKey struct {
+	Name string `json:"name"`
+
+	// SHA256 is the hex encoded SHA-256 of the key
+	SHA256 string `json:"sha256"`
+
+	// Actor claims, 'sub' defaults to the name
+	Actor map[string]any `json:"actor,omitempty"`
+}
+
+// APIKeySource loads the APIKeys JSON document. It is re-read periodically, so
+// that keys can be rotated without a restart.
+type APIKeySource interface {
+	LoadAPIKeys(ctx context.Context) ([]byte, error)
+}
+
+type FileAPIKeySource string
+
+func (fs FileAPIKeySource) LoadAPIKeys(ctx context.Context) ([]byte, error) {
+	return os.ReadFile(string(fs))
+}
+
+type SecretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// SecretAPIKeySource reads the document from a Secrets Manager secret string.
+type SecretAPIKeySource struct {
+	Client   SecretsManagerAPI
+	SecretID string
+}
+
+func (ss *SecretAPIKeySource) LoadAPIKeys(ctx context.Context) ([]byte, error) {
+	out, err := ss.Client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(ss.SecretID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting secret %s: %w", ss.SecretID, err)
+	}
+	if out.SecretString == nil {
+		return nil, fmt.Errorf("secret %s has no string value", ss.SecretID)
+	}
+	return []byte(*out.SecretString), nil
+}
+
+type apiKeyStore struct {
+	source  APIKeySource
+	header  string
+	refresh time.Duration
+
+	lock   sync.RWMutex
+	actors map[string]string // actor header by key hash
+}
+
+func newAPIKeyStore(source APIKeySource, header string, refresh time.Duration) *apiKeyStore {
+	return &apiKeyStore{
+		source:  source,
+		header:  header,
+		refresh: refresh,
+		actors:  map[string]string{},
+	}
+}
+
+func parseAPIKeys(data []byte) (map[string]string, error) {
+	doc := APIKeys{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing api keys: %w", err)
+	}
+
+	actors := make(map[string]string, len(doc.Keys))
+	for _, key := range doc.Keys {
+		hash := strings.ToLower(key.SHA256)
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("api key %q: sha256 must be 64 hex characters", key.Name)
+		}
+		if _, ok := actors[hash]; ok {
+			return nil, fmt.Errorf("api key %q: duplicate hash", key.Name)
+		}
+
+		claims := map[string]any{}
+		for k, v := range key.Actor {
+			claims[k] = v
+		}
+		if _, ok := claims["sub"]; !ok {
+			claims["sub"] = key.Name
+		}
+		actor, err := json.Marshal(claims)
+		if err != nil {
+			return nil, fmt.Errorf("api key %q: %w", key.Name, err)
+		}
+		actors[hash] = string(actor)
+	}
+	return actors, nil
+}
+
+func (ks *apiKeyStore) load(ctx context.Context) error {
+	data, err := ks.source.LoadAPIKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("loading api keys: %w", err)
+	}
+
+	actors, err := parseAPIKeys(data)
+	if err != nil {
+		return err
+	}
+
+	ks.lock.Lock()
+	ks.actors = actors
+	ks.lock.Unlock()
+	return nil
+}
+
+// run reloads the keys until the context is done, keeping the previous keys
+// when loading fails.
+func (ks *apiKeyStore) run(ctx context.Context) error {
+	if ks.refresh <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(ks.refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if err := ks.load(ctx); err != nil {
+			log.WithError(ctx, err).Error("Failed to reload API keys")
+		}
+	}
+}
+
+// lookup returns the actor for the key, and the key's hash which identifies
+// the client without holding on to the key itself.
+func (ks *apiKeyStore) lookup(key string) (string, string, bool) {
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+
+	ks.lock.RLock()
+	defer ks.lock.RUnlock()
+	actor, ok := ks.actors[hash]
+	return actor, hash, ok
+}
+
+// authFunc accepts an API key in place of the next auth func's credentials.
+// Without a next func, requests with no key are anonymous when allowed by the
+// auth policies.
+func (ks *apiKeyStore) authFunc(next func(context.Context, *http.Request) (map[string]string, error), anonymous bool) func(context.Context, *http.Request) (map[string]string, error) {
+	return func(ctx context.Context, req *http.Request) (map[string]string, error) {
+		if key := req.Header.Get(ks.header); key != "" {
+			actor, hash, ok := ks.lookup(key)
+			if !ok {
+				return nil, status.Error(codes.Unauthenticated, "invalid API key")
+			}
+			if info := getRequestInfo(req.Context()); info != nil {
+				// Only known keys get their own rate limit bucket
+				info.apiKey = hash
+			}
+			return map[string]string{
+				httpjwt.VerifiedJWTHeader: actor,
+			}, nil
+		}
+
+		if next != nil {
+			return next(ctx, req)
+		}
+		if anonymous {
+			return map[string]string{}, nil
+		}
+		return nil, status.Error(codes.Unauthenticated, "API key required")
+	}
+}
@@ -0,0 +1,85 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pentops/jwtauth/httpjwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testAPIKeySource string
+
+func (ts testAPIKeySource) LoadAPIKeys(ctx context.Context) ([]byte, error) {
+	return []byte(ts), nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	source := testAPIKeySource(fmt.Sprintf(`{"keys": [
+		{"name": "partner-a", "sha256": %q, "actor": {"scope": "read"}}
+	]}`, hashKey("secret-a")))
+
+	store := newAPIKeyStore(source, "X-API-Key", 0)
+	require.NoError(t, store.load(t.Context()))
+
+	jwtCalled := false
+	auth := store.authFunc(func(ctx context.Context, req *http.Request) (map[string]string, error) {
+		jwtCalled = true
+		return map[string]string{httpjwt.VerifiedJWTHeader: `{"sub":"jwt-user"}`}, nil
+	}, false)
+
+	ctx, info := withRequestInfo(t.Context())
+	req := httptest.NewRequestWithContext(ctx, "GET", "/", nil)
+	req.Header.Set("X-API-Key", "secret-a")
+	headers, err := auth(t.Context(), req)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"sub":"partner-a","scope":"read"}`, headers[httpjwt.VerifiedJWTHeader])
+	assert.False(t, jwtCalled)
+	assert.Equal(t, hashKey("secret-a"), info.apiKey, "rate limited by the hash, not the key")
+
+	ctx, info = withRequestInfo(t.Context())
+	req = httptest.NewRequestWithContext(ctx, "GET", "/", nil)
+	req.Header.Set("X-API-Key", "wrong")
+	_, err = auth(t.Context(), req)
+	assert.Error(t, err)
+	assert.Empty(t, info.apiKey, "unknown keys don't get a bucket")
+
+	// Without a key, JWT auth applies
+	req = httptest.NewRequest("GET", "/", nil)
+	headers, err = auth(t.Context(), req)
+	require.NoError(t, err)
+	assert.True(t, jwtCalled)
+	assert.Equal(t, `{"sub":"jwt-user"}`, headers[httpjwt.VerifiedJWTHeader])
+}
+
+func TestAPIKeyOnly(t *testing.T) {
+	store := newAPIKeyStore(testAPIKeySource(`{"keys": []}`), "X-API-Key", 0)
+	require.NoError(t, store.load(t.Context()))
+
+	_, err := store.authFunc(nil, false)(t.Context(), httptest.NewRequest("GET", "/", nil))
+	assert.Error(t, err, "key required")
+
+	headers, err := store.authFunc(nil, true)(t.Context(), httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err, "anonymous allowed by policy")
+	assert.Empty(t, headers)
+}
+
+func TestAPIKeyParse(t *testing.T) {
+	_, err := parseAPIKeys([]byte(`{"keys": [{"name": "short", "sha256": "abc"}]}`))
+	assert.Error(t, err)
+
+	hash := hashKey("dup")
+	_, err = parseAPIKeys(fmt.Appendf(nil, `{"keys": [{"name": "a", "sha256": %q}, {"name": "b", "sha256": %q}]}`, hash, hash))
+	assert.Error(t, err)
+}
@@ -0,0 +1,170 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// AuthPolicies is loaded from AUTH_POLICY_FILE. The most specific policy
+// applies: the method's, then the service's, then the default.
+type AuthPolicies struct {
+	Default AuthPolicy `json:"default"`
+
+	// Services by full name, e.g. foo.v1.FooService
+	Services map[string]AuthPolicy `json:"services,omitempty"`
+
+	// Methods by full gRPC method name, e.g. /foo.v1.FooService/GetFoo
+	Methods map[string]AuthPolicy `json:"methods,omitempty"`
+}
+
+// AuthPolicy requires a verified token, unless Anonymous, carrying all of the
+// Scopes and Claims.
+type AuthPolicy struct {
+	Anonymous bool `json:"anonymous,omitempty"`
+
+	// Scopes are read from the space separated 'scope' claim, or the 'scp'
+	// array.
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Claims must equal the value, or contain it when the claim is an array.
+	Claims map[string]string `json:"claims,omitempty"`
+}
+
+func (ap AuthPolicy) validate() error {
+	if ap.Anonymous && (len(ap.Scopes) > 0 || len(ap.Claims) > 0) {
+		return fmt.Errorf("anonymous policy cannot require scopes or claims")
+	}
+	return nil
+}
+
+func LoadAuthPolicies(filename string) (AuthPolicies, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return AuthPolicies{}, fmt.Errorf("reading auth policies: %w", err)
+	}
+
+	policies := AuthPolicies{}
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return AuthPolicies{}, fmt.Errorf("parsing auth policies: %w", err)
+	}
+	return policies, nil
+}
+
+type authorizer struct {
+	policies AuthPolicies
+}
+
+func newAuthorizer(policies AuthPolicies) (*authorizer, error) {
+	if err := policies.Default.validate(); err != nil {
+		return nil, fmt.Errorf("default auth policy: %w", err)
+	}
+	for name, policy := range policies.Services {
+		if err := policy.validate(); err != nil {
+			return nil, fmt.Errorf("auth policy for %s: %w", name, err)
+		}
+	}
+	for name, policy := range policies.Methods {
+		if err := policy.validate(); err != nil {
+			return nil, fmt.Errorf("auth policy for %s: %w", name, err)
+		}
+	}
+
+	return &authorizer{
+		policies: policies,
+	}, nil
+}
+
+func (az *authorizer) policyFor(method string) AuthPolicy {
+	if policy, ok := az.policies.Methods[method]; ok {
+		return policy
+	}
+
+	service, _, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if policy, ok := az.policies.Services[service]; ok {
+		return policy
+	}
+
+	return az.policies.Default
+}
+
+// authorize checks the verified actor against the method's policy, returning
+// the HTTP status to reject the request with.
+func (az *authorizer) authorize(method string, actor string) (int, error) {
+	policy := az.policyFor(method)
+	if policy.Anonymous {
+		return 0, nil
+	}
+
+	if actor == "" {
+		return http.StatusUnauthorized, fmt.Errorf("authentication required")
+	}
+
+	if len(policy.Scopes) == 0 && len(policy.Claims) == 0 {
+		return 0, nil
+	}
+
+	claims := map[string]any{}
+	if err := json.Unmarshal([]byte(actor), &claims); err != nil {
+		return http.StatusForbidden, fmt.Errorf("actor has no claims")
+	}
+
+	scopes := claimScopes(claims)
+	for _, scope := range policy.Scopes {
+		if !slices.Contains(scopes, scope) {
+			return http.StatusForbidden, fmt.Errorf("missing scope %s", scope)
+		}
+	}
+
+	for name, want := range policy.Claims {
+		if !claimMatches(claims[name], want) {
+			return http.StatusForbidden, fmt.Errorf("claim %s does not match", name)
+		}
+	}
+
+	return 0, nil
+}
+
+func claimScopes(claims map[string]any) []string {
+	scopes := []string{}
+	if scope, ok := claims["scope"].(string); ok {
+		scopes = append(scopes, strings.Fields(scope)...)
+	}
+	if scp, ok := claims["scp"].([]any); ok {
+		for _, val := range scp {
+			if str, ok := val.(string); ok {
+				scopes = append(scopes, str)
+			}
+		}
+	}
+	return scopes
+}
+
+func claimMatches(claim any, want string) bool {
+	switch claim := claim.(type) {
+	case string:
+		return claim == want
+	case []any:
+		for _, val := range claim {
+			if str, ok := val.(string); ok && str == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowAnonymous passes requests without a token through unauthenticated,
+// leaving the method's policy to decide.
+func allowAnonymous(auth func(context.Context, *http.Request) (map[string]string, error)) func(context.Context, *http.Request) (map[string]string, error) {
+	return func(ctx context.Context, req *http.Request) (map[string]string, error) {
+		if req.Header.Get("Authorization") == "" {
+			return map[string]string{}, nil
+		}
+		return auth(ctx, req)
+	}
+}
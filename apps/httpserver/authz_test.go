@@ -0,0 +1,67 @@
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorize(t *testing.T) {
+	az, err := newAuthorizer(AuthPolicies{
+		Default: AuthPolicy{},
+		Services: map[string]AuthPolicy{
+			"test.v1.AdminService": {
+				Scopes: []string{"admin"},
+			},
+		},
+		Methods: map[string]AuthPolicy{
+			"/test.v1.FooService/GetPublic": {Anonymous: true},
+			"/test.v1.AdminService/Delete": {
+				Scopes: []string{"admin", "delete"},
+				Claims: map[string]string{"groups": "ops"},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	reader := `{"sub":"alice","scope":"read"}`
+	admin := `{"sub":"bob","scope":"admin delete","groups":["ops","dev"]}`
+	adminNotOps := `{"sub":"carol","scp":["admin","delete"],"groups":["dev"]}`
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		actor  string
+		want   int
+	}{
+		{"anonymous method", "/test.v1.FooService/GetPublic", "", 0},
+		{"default without token", "/test.v1.FooService/GetFoo", "", http.StatusUnauthorized},
+		{"default with token", "/test.v1.FooService/GetFoo", reader, 0},
+		{"service scope missing", "/test.v1.AdminService/List", reader, http.StatusForbidden},
+		{"service scope", "/test.v1.AdminService/List", admin, 0},
+		{"method scopes and claim", "/test.v1.AdminService/Delete", admin, 0},
+		{"method claim missing", "/test.v1.AdminService/Delete", adminNotOps, http.StatusForbidden},
+		{"method without token", "/test.v1.AdminService/Delete", "", http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status, err := az.authorize(tc.method, tc.actor)
+			assert.Equal(t, tc.want, status)
+			if tc.want == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestAuthPolicyValidation(t *testing.T) {
+	_, err := newAuthorizer(AuthPolicies{
+		Methods: map[string]AuthPolicy{
+			"/test.v1.FooService/GetFoo": {Anonymous: true, Scopes: []string{"read"}},
+		},
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,343 @@
+package httpserver
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pentops/jwtauth/httpjwt"
+)
+
+// Responses larger than this fraction of the cache are not stored, so that
+// one response can't evict everything else.
+const cacheEntryFraction = 16
+
+type cachedResponse struct {
+	key        string
+	grpcMethod string
+	status     int
+	header     http.Header
+	body       []byte
+	etag       string
+	stored     time.Time
+	expires    time.Time
+}
+
+func (cr *cachedResponse) size() int64 {
+	size := len(cr.key) + len(cr.body)
+	for key, vals := range cr.header {
+		size += len(key)
+		for _, val := range vals {
+			size += len(val)
+		}
+	}
+	return int64(size)
+}
+
+// responseCache is an LRU of responses, bounded by their total size.
+type responseCache struct {
+	maxBytes int64
+	now      func() time.Time
+
+	lock    sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+func newResponseCache(maxBytes int64) *responseCache {
+	return &responseCache{
+		maxBytes: maxBytes,
+		now:      time.Now,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (rc *responseCache) get(key string) (*cachedResponse, bool) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	el, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cachedResponse)
+	if !rc.now().Before(entry.expires) {
+		rc.remove(el)
+		return nil, false
+	}
+	rc.lru.MoveToFront(el)
+	return entry, true
+}
+
+func (rc *responseCache) put(entry *cachedResponse) {
+	size := entry.size()
+	if size > rc.maxBytes/cacheEntryFraction {
+		return
+	}
+
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if el, ok := rc.entries[entry.key]; ok {
+		rc.remove(el)
+	}
+	rc.entries[entry.key] = rc.lru.PushFront(entry)
+	rc.size += size
+
+	for rc.size > rc.maxBytes {
+		rc.remove(rc.lru.Back())
+	}
+}
+
+// remove drops the entry, the caller holds the lock.
+func (rc *responseCache) remove(el *list.Element) {
+	entry := rc.lru.Remove(el).(*cachedResponse)
+	delete(rc.entries, entry.key)
+	rc.size -= entry.size()
+}
+
+// cacheTTL reads how long a response may be cached for from the app's
+// Cache-Control, zero when it must not be. Private responses are only cached
+// when keyed by a verified caller.
+func cacheTTL(cacheControl string, perCaller bool) time.Duration {
+	var maxAge, sharedMaxAge time.Duration
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, val, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache":
+			return 0
+		case "private":
+			if !perCaller {
+				return 0
+			}
+		case "max-age":
+			if seconds, err := strconv.Atoi(val); err == nil {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		case "s-maxage":
+			if seconds, err := strconv.Atoi(val); err == nil {
+				sharedMaxAge = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	if sharedMaxAge > 0 {
+		return sharedMaxAge
+	}
+	return maxAge
+}
+
+func requestNoCache(r *http.Request) bool {
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "no-cache", "no-store":
+			return true
+		}
+	}
+	return false
+}
+
+func etagFor(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheWriter holds back the response of a gRPC method so that it can be
+// cached, and the ETag set from the whole body. Other responses, bodies too
+// large to cache and flushed responses are passed straight through.
+type cacheWriter struct {
+	http.ResponseWriter
+	info     *requestInfo
+	maxBytes int64
+
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	passThrough bool
+}
+
+func (cw *cacheWriter) Header() http.Header {
+	if cw.passThrough {
+		return cw.ResponseWriter.Header()
+	}
+	return cw.header
+}
+
+func (cw *cacheWriter) WriteHeader(status int) {
+	if cw.passThrough {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	if cw.info.grpcMethod == "" {
+		cw.passOn()
+	}
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 && !cw.passThrough {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.passThrough && int64(cw.body.Len()+len(b)) > cw.maxBytes {
+		cw.passOn()
+	}
+	if cw.passThrough {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.body.Write(b)
+}
+
+func (cw *cacheWriter) Flush() {
+	if !cw.passThrough {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.passOn()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush() // nolint: errcheck
+}
+
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// passOn writes what has been held back, and stops buffering.
+func (cw *cacheWriter) passOn() {
+	cw.passThrough = true
+	dst := cw.ResponseWriter.Header()
+	for key, vals := range cw.header {
+		dst[key] = vals
+	}
+	if cw.info.cacheControl != "" && dst.Get("Cache-Control") == "" {
+		dst.Set("Cache-Control", cw.info.cacheControl)
+	}
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	if cw.body.Len() > 0 {
+		cw.ResponseWriter.Write(cw.body.Bytes()) // nolint: errcheck
+		cw.body.Reset()
+	}
+}
+
+// cacheMiddleware serves GET requests from the cache, keyed by the caller's
+// verified identity, and adds ETags. Auth policies and rate limits still
+// apply to cached responses.
+func (hs *Router) cacheMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := getRequestInfo(r.Context())
+		if r.Method != http.MethodGet || info == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity := ""
+		if hs.authFunc != nil {
+			headers, err := hs.authenticate(r.Context(), r)
+			if err != nil {
+				// Let the proxy reject it
+				next.ServeHTTP(w, r)
+				return
+			}
+			identity = headers[httpjwt.VerifiedJWTHeader]
+		} else if r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != "" {
+			// The app authenticates the caller, so the response may be theirs
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := identity + "\n" + r.URL.Path + "?" + r.URL.Query().Encode()
+
+		if !requestNoCache(r) {
+			if entry, ok := hs.cache.get(key); ok && hs.admission().admit(entry.grpcMethod, info) == nil {
+				info.grpcMethod = entry.grpcMethod
+				hs.metrics.cacheResults.WithLabelValues("hit").Inc()
+				age := hs.cache.now().Sub(entry.stored)
+				w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+				writeCached(w, r, entry.status, entry.header, entry.body, entry.etag)
+				return
+			}
+		}
+		hs.metrics.cacheResults.WithLabelValues("miss").Inc()
+
+		cw := &cacheWriter{
+			ResponseWriter: w,
+			info:           info,
+			maxBytes:       hs.cache.maxBytes / cacheEntryFraction,
+			header:         http.Header{},
+		}
+		next.ServeHTTP(cw, r)
+		if cw.passThrough {
+			return
+		}
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+
+		body := cw.body.Bytes()
+		etag := ""
+		if cw.status == http.StatusOK && cw.header.Get("ETag") == "" {
+			etag = etagFor(body)
+		}
+
+		if info.cacheControl != "" && cw.header.Get("Cache-Control") == "" {
+			cw.header.Set("Cache-Control", info.cacheControl)
+		}
+
+		if ttl := cacheTTL(info.cacheControl, identity != ""); ttl > 0 && cw.status == http.StatusOK && info.grpcMethod != "" {
+			now := hs.cache.now()
+			hs.cache.put(&cachedResponse{
+				key:        key,
+				grpcMethod: info.grpcMethod,
+				status:     cw.status,
+				header:     cw.header.Clone(),
+				body:       bytes.Clone(body),
+				etag:       etag,
+				stored:     now,
+				expires:    now.Add(ttl),
+			})
+		}
+
+		writeCached(w, r, cw.status, cw.header, body, etag)
+	})
+}
+
+func writeCached(w http.ResponseWriter, r *http.Request, status int, header http.Header, body []byte, etag string) {
+	dst := w.Header()
+	for key, vals := range header {
+		dst[key] = slices.Clone(vals)
+	}
+
+	if etag != "" {
+		dst.Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			dst.Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	dst.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body) // nolint: errcheck
+}
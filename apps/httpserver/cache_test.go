@@ -0,0 +1,205 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pentops/jwtauth/httpjwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheTTL(t *testing.T) {
+	assert.Equal(t, time.Minute, cacheTTL("public, max-age=60", false))
+	assert.Equal(t, 10*time.Second, cacheTTL("max-age=60, s-maxage=10", false))
+	assert.Equal(t, time.Duration(0), cacheTTL("max-age=60, no-store", true))
+	assert.Equal(t, time.Duration(0), cacheTTL("private", true))
+	assert.Equal(t, time.Duration(0), cacheTTL("", true))
+	assert.Equal(t, time.Minute, cacheTTL("private, max-age=60", true))
+	assert.Equal(t, time.Duration(0), cacheTTL("private, max-age=60", false), "private without a verified caller")
+}
+
+func TestResponseCacheLRU(t *testing.T) {
+	now := time.Now()
+	cache := newResponseCache(16 * 100)
+	cache.now = func() time.Time { return now }
+
+	// Each entry is 100 bytes, the most one entry can be
+	put := func(key string, ttl time.Duration) {
+		cache.put(&cachedResponse{
+			key:     key,
+			body:    make([]byte, 97),
+			expires: now.Add(ttl),
+		})
+	}
+
+	for i := range 16 {
+		put(fmt.Sprintf("k%02d", i), time.Minute)
+	}
+	_, ok := cache.get("k00")
+	require.True(t, ok, "k00 is now most recently used")
+
+	put("k16", time.Minute)
+	_, ok = cache.get("k01")
+	assert.False(t, ok, "least recently used is evicted")
+	_, ok = cache.get("k00")
+	assert.True(t, ok)
+
+	cache.put(&cachedResponse{key: "big", body: make([]byte, 200), expires: now.Add(time.Minute)})
+	_, ok = cache.get("big")
+	assert.False(t, ok, "too large to cache")
+
+	put("k99", time.Second)
+	now = now.Add(2 * time.Second)
+	_, ok = cache.get("k99")
+	assert.False(t, ok, "expired")
+}
+
+func TestCacheMiddleware(t *testing.T) {
+	hs := &Router{
+		metrics: newRouterMetrics(),
+		cache:   newResponseCache(1 << 20),
+		authFunc: recordActor(func(ctx context.Context, req *http.Request) (map[string]string, error) {
+			return map[string]string{
+				httpjwt.VerifiedJWTHeader: `{"sub":"` + req.Header.Get("Authorization") + `"}`,
+			}, nil
+		}),
+	}
+
+	calls := 0
+	cacheControl := "max-age=60"
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		info := getRequestInfo(r.Context())
+		info.grpcMethod = "/foo.v1.FooService/GetFoo"
+		info.cacheControl = cacheControl
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"call":%d}`, calls)
+	})
+	handler := hs.observeMiddleware(hs.cacheMiddleware(app))
+
+	get := func(path string, user string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", user)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	first := get("/foo?b=2&a=1", "alice")
+	assert.Equal(t, `{"call":1}`, first.Body.String())
+	assert.Equal(t, "max-age=60", first.Header().Get("Cache-Control"))
+	etag := first.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	second := get("/foo?a=1&b=2", "alice")
+	assert.Equal(t, `{"call":1}`, second.Body.String(), "query order doesn't matter")
+	assert.Equal(t, etag, second.Header().Get("ETag"))
+	assert.Equal(t, "application/json", second.Header().Get("Content-Type"))
+
+	other := get("/foo?a=1&b=2", "bob")
+	assert.Equal(t, `{"call":2}`, other.Body.String(), "keyed by identity")
+
+	notModified := get("/foo?a=1&b=2", "alice", "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, notModified.Code)
+	assert.Empty(t, notModified.Body.String())
+
+	refreshed := get("/foo?a=1&b=2", "alice", "Cache-Control", "no-cache")
+	assert.Equal(t, `{"call":3}`, refreshed.Body.String())
+
+	cacheControl = "no-store"
+	get("/bar", "alice")
+	get("/bar", "alice")
+	assert.Equal(t, 5, calls, "app's no-store is honored")
+}
+
+func TestCacheMiddlewareUnverified(t *testing.T) {
+	hs := &Router{
+		metrics: newRouterMetrics(),
+		cache:   newResponseCache(1 << 20),
+	}
+
+	calls := 0
+	cacheControl := "private, max-age=60"
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		info := getRequestInfo(r.Context())
+		info.grpcMethod = "/foo.v1.FooService/GetFoo"
+		info.cacheControl = cacheControl
+		fmt.Fprintf(w, `{"call":%d}`, calls)
+	})
+	handler := hs.observeMiddleware(hs.cacheMiddleware(app))
+
+	get := func(headers ...string) string {
+		req := httptest.NewRequest("GET", "/foo", nil)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw.Body.String()
+	}
+
+	get()
+	assert.Equal(t, `{"call":2}`, get(), "private responses aren't shared")
+
+	cacheControl = "max-age=60"
+	get("Authorization", "Bearer alice")
+	assert.Equal(t, `{"call":4}`, get("Authorization", "Bearer bob"), "the app authenticates the caller")
+	get("Cookie", "session=alice")
+	assert.Equal(t, `{"call":6}`, get("Cookie", "session=bob"))
+
+	get()
+	assert.Equal(t, `{"call":7}`, get(), "public responses are shared")
+}
+
+func TestCacheMiddlewarePassThrough(t *testing.T) {
+	hs := &Router{
+		metrics: newRouterMetrics(),
+		cache:   newResponseCache(16 * 100),
+	}
+
+	var buffered bool
+	handler := hs.observeMiddleware(hs.cacheMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := getRequestInfo(r.Context())
+		if r.URL.Path == "/large" {
+			info.grpcMethod = "/foo.v1.FooService/GetFoo"
+			info.cacheControl = "max-age=60"
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(make([]byte, 60)) // nolint: errcheck
+		cw, ok := w.(*cacheWriter)
+		buffered = ok && !cw.passThrough
+		w.Write(make([]byte, 60)) // nolint: errcheck
+		if r.URL.Path == "/static" {
+			http.NewResponseController(w).Flush() // nolint: errcheck
+		}
+	})))
+
+	req := httptest.NewRequest("GET", "/static", nil)
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	assert.False(t, buffered, "not routed to a gRPC method")
+	assert.True(t, rw.Flushed)
+	assert.Empty(t, rw.Header().Get("ETag"))
+	assert.Equal(t, 120, rw.Body.Len())
+
+	req = httptest.NewRequest("GET", "/large", nil)
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	assert.True(t, buffered, "buffered up to the largest cacheable size")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "max-age=60", rw.Header().Get("Cache-Control"))
+	assert.Empty(t, rw.Header().Get("ETag"), "passed through once too large to cache")
+	assert.Equal(t, 120, rw.Body.Len())
+
+	_, ok := hs.cache.get("\n/large?")
+	assert.False(t, ok)
+}
@@ -0,0 +1,222 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rs/cors"
+)
+
+var (
+	defaultCORSMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	defaultCORSHeaders = []string{"*"}
+
+	// gRPC-Web clients read the status from the headers of trailers-only
+	// responses
+	grpcExposedHeaders = []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"}
+)
+
+// CORSPolicies is loaded from CORS_POLICY_FILE.
+type CORSPolicies struct {
+	Default CORSPolicy `json:"default"`
+
+	// Routes override the default by path prefix, e.g. /foo/v1/, the longest
+	// matching prefix applies. Fields which are not set are inherited from
+	// the default.
+	Routes map[string]CORSPolicy `json:"routes,omitempty"`
+}
+
+type CORSPolicy struct {
+	// AllowedOrigins are exact origins, '*', or subdomain wildcards like
+	// https://*.example.com
+	AllowedOrigins []string `json:"allowedOrigins,omitempty"`
+
+	// Default GET, POST, PUT, PATCH, DELETE
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+
+	// Default '*'
+	AllowedHeaders []string `json:"allowedHeaders,omitempty"`
+
+	// The gRPC status headers are always exposed
+	ExposedHeaders []string `json:"exposedHeaders,omitempty"`
+
+	// MaxAge in seconds that browsers may cache preflight responses for
+	MaxAge *int `json:"maxAge,omitempty"`
+
+	// Default true
+	AllowCredentials *bool `json:"allowCredentials,omitempty"`
+}
+
+func LoadCORSPolicies(filename string) (CORSPolicies, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return CORSPolicies{}, fmt.Errorf("reading cors policies: %w", err)
+	}
+
+	policies := CORSPolicies{}
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return CORSPolicies{}, fmt.Errorf("parsing cors policies: %w", err)
+	}
+	return policies, nil
+}
+
+// inherit fills the fields which aren't set from the parent.
+func (cp CORSPolicy) inherit(parent CORSPolicy) CORSPolicy {
+	if cp.AllowedOrigins == nil {
+		cp.AllowedOrigins = parent.AllowedOrigins
+	}
+	if cp.AllowedMethods == nil {
+		cp.AllowedMethods = parent.AllowedMethods
+	}
+	if cp.AllowedHeaders == nil {
+		cp.AllowedHeaders = parent.AllowedHeaders
+	}
+	if cp.ExposedHeaders == nil {
+		cp.ExposedHeaders = parent.ExposedHeaders
+	}
+	if cp.MaxAge == nil {
+		cp.MaxAge = parent.MaxAge
+	}
+	if cp.AllowCredentials == nil {
+		cp.AllowCredentials = parent.AllowCredentials
+	}
+	return cp
+}
+
+func (cp CORSPolicy) options() (cors.Options, error) {
+	opts := cors.Options{
+		AllowedOrigins:   cp.AllowedOrigins,
+		AllowedMethods:   cp.AllowedMethods,
+		AllowedHeaders:   cp.AllowedHeaders,
+		ExposedHeaders:   append(append([]string{}, grpcExposedHeaders...), cp.ExposedHeaders...),
+		AllowCredentials: true,
+	}
+	if opts.AllowedMethods == nil {
+		opts.AllowedMethods = defaultCORSMethods
+	}
+	if opts.AllowedHeaders == nil {
+		opts.AllowedHeaders = defaultCORSHeaders
+	}
+	if cp.AllowCredentials != nil {
+		opts.AllowCredentials = *cp.AllowCredentials
+	}
+	if cp.MaxAge != nil {
+		if *cp.MaxAge < 0 {
+			return opts, errors.New("maxAge cannot be negative")
+		}
+		opts.MaxAge = *cp.MaxAge
+	}
+
+	if len(opts.AllowedOrigins) == 0 {
+		return opts, errors.New("no allowed origins")
+	}
+	for _, origin := range opts.AllowedOrigins {
+		if origin == "*" {
+			if opts.AllowCredentials {
+				return opts, errors.New("origin '*' cannot be used with credentials, list the origins or set allowCredentials false")
+			}
+			continue
+		}
+		if err := validateOrigin(origin); err != nil {
+			return opts, err
+		}
+	}
+
+	return opts, nil
+}
+
+// validateOrigin accepts scheme://host[:port], where the host may start with
+// a '*.' wildcard for any subdomain.
+func validateOrigin(origin string) error {
+	scheme, host, ok := strings.Cut(origin, "://")
+	if !ok || (scheme != "https" && scheme != "http") {
+		return fmt.Errorf("origin %q must be http(s)://host", origin)
+	}
+
+	wildcard := strings.HasPrefix(host, "*.")
+	if wildcard {
+		host = strings.TrimPrefix(host, "*.")
+		// Otherwise *.com would allow every .com origin
+		if !strings.Contains(host, ".") && !strings.HasPrefix(host, "localhost") {
+			return fmt.Errorf("origin %q wildcard must be for subdomains of a domain", origin)
+		}
+	}
+	if strings.Contains(host, "*") {
+		return fmt.Errorf("origin %q may only have a wildcard as the first label", origin)
+	}
+
+	parsed, err := url.Parse(scheme + "://" + host)
+	if err != nil || parsed.Host != host || parsed.Hostname() == "" {
+		return fmt.Errorf("origin %q must be http(s)://host with no path", origin)
+	}
+	return nil
+}
+
+type corsRoute struct {
+	prefix string
+	cors   *cors.Cors
+}
+
+// corsRouter applies the policy for the longest matching route prefix.
+type corsRouter struct {
+	routes []corsRoute // longest prefix first
+	base   *cors.Cors
+}
+
+func newCORSRouter(policies CORSPolicies) (*corsRouter, error) {
+	base, err := policies.Default.options()
+	if err != nil {
+		return nil, fmt.Errorf("default cors policy: %w", err)
+	}
+
+	cr := &corsRouter{
+		base: cors.New(base),
+	}
+
+	for prefix, policy := range policies.Routes {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("cors route %q must start with /", prefix)
+		}
+		opts, err := policy.inherit(policies.Default).options()
+		if err != nil {
+			return nil, fmt.Errorf("cors policy for %s: %w", prefix, err)
+		}
+		cr.routes = append(cr.routes, corsRoute{
+			prefix: prefix,
+			cors:   cors.New(opts),
+		})
+	}
+	sort.Slice(cr.routes, func(i, j int) bool {
+		return len(cr.routes[i].prefix) > len(cr.routes[j].prefix)
+	})
+
+	return cr, nil
+}
+
+func (cr *corsRouter) policyFor(path string) *cors.Cors {
+	for _, route := range cr.routes {
+		if strings.HasPrefix(path, route.prefix) {
+			return route.cors
+		}
+	}
+	return cr.base
+}
+
+func (cr *corsRouter) middleware(next http.Handler) http.Handler {
+	handlers := map[*cors.Cors]http.Handler{
+		cr.base: cr.base.Handler(next),
+	}
+	for _, route := range cr.routes {
+		handlers[route.cors] = route.cors.Handler(next)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers[cr.policyFor(r.URL.Path)].ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,154 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pentops/o5-runtime-sidecar/sidecar"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORSPolicyValidation(t *testing.T) {
+	no := false
+
+	for _, tc := range []struct {
+		name    string
+		policy  CORSPolicy
+		wantErr string
+	}{{
+		name:   "wildcard subdomain",
+		policy: CORSPolicy{AllowedOrigins: []string{"https://*.example.com", "http://localhost:3000"}},
+	}, {
+		name:   "any origin without credentials",
+		policy: CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: &no},
+	}, {
+		name:    "any origin with credentials",
+		policy:  CORSPolicy{AllowedOrigins: []string{"*"}},
+		wantErr: "credentials",
+	}, {
+		name:    "no origins",
+		policy:  CORSPolicy{},
+		wantErr: "no allowed origins",
+	}, {
+		name:    "wildcard tld",
+		policy:  CORSPolicy{AllowedOrigins: []string{"https://*.com"}},
+		wantErr: "subdomains",
+	}, {
+		name:    "wildcard suffix",
+		policy:  CORSPolicy{AllowedOrigins: []string{"https://*example.com"}},
+		wantErr: "first label",
+	}, {
+		name:    "path",
+		policy:  CORSPolicy{AllowedOrigins: []string{"https://example.com/app"}},
+		wantErr: "no path",
+	}, {
+		name:    "no scheme",
+		policy:  CORSPolicy{AllowedOrigins: []string{"example.com"}},
+		wantErr: "http(s)",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newCORSRouter(CORSPolicies{Default: tc.policy})
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.wantErr)
+			}
+		})
+	}
+
+	_, err := newCORSRouter(CORSPolicies{
+		Default: CORSPolicy{AllowedOrigins: []string{"https://example.com"}},
+		Routes: map[string]CORSPolicy{
+			"/public/": {AllowedOrigins: []string{"*"}},
+		},
+	})
+	assert.ErrorContains(t, err, "/public/: origin '*' cannot be used with credentials")
+}
+
+func TestCORSPolicyRoutes(t *testing.T) {
+	no := false
+	maxAge := 600
+
+	cr, err := newCORSRouter(CORSPolicies{
+		Default: CORSPolicy{
+			AllowedOrigins: []string{"https://*.example.com"},
+			ExposedHeaders: []string{"X-Request-Id"},
+			MaxAge:         &maxAge,
+		},
+		Routes: map[string]CORSPolicy{
+			"/public/": {
+				AllowedOrigins:   []string{"*"},
+				AllowedMethods:   []string{"GET"},
+				AllowCredentials: &no,
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	handler := cr.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	preflight := func(path, origin, method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := preflight("/foo/v1/bar", "https://app.example.com", "PATCH")
+	assert.Equal(t, "https://app.example.com", rw.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rw.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "600", rw.Header().Get("Access-Control-Max-Age"))
+
+	rw = preflight("/foo/v1/bar", "https://example.org", "GET")
+	assert.Empty(t, rw.Header().Get("Access-Control-Allow-Origin"))
+
+	rw = preflight("/public/thing", "https://example.org", "GET")
+	assert.Equal(t, "*", rw.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rw.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "600", rw.Header().Get("Access-Control-Max-Age"), "inherited from default")
+
+	rw = preflight("/public/thing", "https://example.org", "DELETE")
+	assert.Empty(t, rw.Header().Get("Access-Control-Allow-Origin"), "method not allowed on route")
+
+	req := httptest.NewRequest("GET", "/foo/v1/bar", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	assert.Contains(t, rw.Header().Get("Access-Control-Expose-Headers"), "X-Request-Id")
+	assert.Contains(t, rw.Header().Get("Access-Control-Expose-Headers"), "Grpc-Status")
+}
+
+func TestCORSConfig(t *testing.T) {
+	hs, err := NewRouter(ServerConfig{
+		CORSOrigins: []string{"*"},
+	}, sidecar.AppInfo{}, nil)
+	require.NoError(t, err, "CORS_ORIGINS=* allows any origin without credentials")
+
+	req := httptest.NewRequest("OPTIONS", "/foo/v1/bar", nil)
+	req.Header.Set("Origin", "https://example.org")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rw := httptest.NewRecorder()
+	hs.corsMiddleware(http.NotFoundHandler()).ServeHTTP(rw, req)
+	assert.Equal(t, "*", rw.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rw.Header().Get("Access-Control-Allow-Credentials"))
+
+	_, err = NewRouter(ServerConfig{
+		CORSOrigins: []string{"*", "https://example.com"},
+	}, sidecar.AppInfo{}, nil)
+	assert.ErrorContains(t, err, "origin '*' cannot be used with credentials")
+
+	policyFile := filepath.Join(t.TempDir(), "cors.json")
+	require.NoError(t, os.WriteFile(policyFile, []byte(`{"default": {"allowedOrigins": ["*"]}}`), 0o600))
+	_, err = NewRouter(ServerConfig{
+		CORSPolicyFile: policyFile,
+	}, sidecar.AppInfo{}, nil)
+	assert.ErrorContains(t, err, "origin '*' cannot be used with credentials", "policy files must set allowCredentials false")
+}
@@ -0,0 +1,295 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pentops/jwtauth/httpjwt"
+	"github.com/pentops/o5-runtime-sidecar/adapters/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// rawFrame is a message passed through without being decoded.
+type rawFrame struct {
+	data []byte
+}
+
+// rawCodec passes message bytes through as rawFrames. It is named proto so
+// that the content type is unchanged on either side.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	frame, ok := v.(*rawFrame)
+	if !ok {
+		return nil, fmt.Errorf("raw codec cannot marshal %T", v)
+	}
+	return frame.data, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	frame, ok := v.(*rawFrame)
+	if !ok {
+		return fmt.Errorf("raw codec cannot unmarshal into %T", v)
+	}
+	frame.data = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// httpStatusClientClosed is nginx's status for a request the client gave up
+// on.
+const httpStatusClientClosed = 499
+
+var passthroughStreamDesc = &grpc.StreamDesc{
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+// grpcMethods maps full method names, e.g. /foo.v1.FooService/GetFoo, to the
+// app connection serving them.
+type grpcMethods map[string]grpc.ClientConnInterface
+
+func (gm grpcMethods) add(service protoreflect.ServiceDescriptor, conn grpc.ClientConnInterface) {
+	methods := service.Methods()
+	for i := range methods.Len() {
+		gm["/"+string(service.FullName())+"/"+string(methods.Get(i).Name())] = conn
+	}
+}
+
+func isGRPCRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// isGRPC routes native gRPC and gRPC-Web requests, and CORS preflights for
+// gRPC methods, away from the JSON proxy.
+func (hs *Router) isGRPC(r *http.Request) bool {
+	if hs.grpcHandler == nil {
+		return false
+	}
+	if isGRPCRequest(r) {
+		return true
+	}
+	if r.Method == http.MethodOptions {
+		_, ok := hs.routes.Load().grpcMethods[r.URL.Path]
+		return ok
+	}
+	return false
+}
+
+// newGRPCHandler serves the app's services as native gRPC over h2c and as
+// gRPC-Web, behind the same middleware as the JSON proxy.
+func (hs *Router) newGRPCHandler() http.Handler {
+	server := grpc.NewServer(
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(hs.proxyStream),
+	)
+
+	var handler http.Handler = server
+	handler = grpcWebHandler(handler)
+	handler = hs.grpcAuthMiddleware(handler)
+	handler = hs.corsMiddleware(handler)
+	handler = hs.versionMiddleware(handler)
+	handler = hs.observeMiddleware(handler)
+	return handler
+}
+
+// grpcAuthMiddleware runs the auth func against the HTTP request, leaving the
+// stream handler to reject it once the method is known.
+func (hs *Router) grpcAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := getRequestInfo(r.Context()); info != nil {
+			info.grpc = true
+			if hs.authFunc != nil {
+				hs.authenticate(r.Context(), r) // nolint: errcheck
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// proxyStream forwards any method the app serves, passing messages through as
+// bytes.
+func (hs *Router) proxyStream(_ any, serverStream grpc.ServerStream) error {
+	ctx := serverStream.Context()
+
+	method, ok := grpc.MethodFromServerStream(serverStream)
+	if !ok {
+		return status.Error(codes.Internal, "no method in stream")
+	}
+
+	info := getRequestInfo(ctx)
+
+	conn, ok := hs.routes.Load().grpcMethods[method]
+	if !ok {
+		if info != nil {
+			info.setGRPCResult("", codes.Unimplemented)
+		}
+		return status.Errorf(codes.Unimplemented, "unknown method %s", method)
+	}
+
+	err := hs.forwardStream(ctx, method, conn, info, serverStream)
+	if info != nil {
+		info.setGRPCResult(method, status.Code(err))
+	}
+	return err
+}
+
+func (hs *Router) forwardStream(ctx context.Context, method string, conn grpc.ClientConnInterface, info *requestInfo, serverStream grpc.ServerStream) error {
+	var authHeaders map[string]string
+	if info != nil {
+		if info.authErr != nil {
+			if _, ok := status.FromError(info.authErr); ok {
+				return info.authErr
+			}
+			return status.Error(codes.Unauthenticated, info.authErr.Error())
+		}
+		if err := hs.admission().admit(method, info); err != nil {
+			return err
+		}
+		authHeaders = info.authHeaders
+	}
+
+	ctx = metadata.NewOutgoingContext(ctx, hs.outgoingMetadata(ctx, authHeaders))
+	ctx = tracing.InjectOutgoing(ctx)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	clientStream, err := conn.NewStream(ctx, passthroughStreamDesc, method, grpc.ForceCodec(rawCodec{}))
+	if err != nil {
+		return err
+	}
+
+	upstream := make(chan error, 1)
+	go func() {
+		upstream <- forwardToApp(serverStream, clientStream)
+	}()
+
+	downstream := make(chan error, 1)
+	go func() {
+		downstream <- forwardToCaller(clientStream, serverStream)
+	}()
+
+	for {
+		select {
+		case err := <-upstream:
+			if err != nil {
+				// The caller went away, cancelling the app's stream
+				cancel()
+				return err
+			}
+			upstream = nil
+
+		case err := <-downstream:
+			serverStream.SetTrailer(clientStream.Trailer())
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// outgoingMetadata passes on the caller's metadata, replacing credentials
+// with the verified actor as the JSON proxy does.
+func (hs *Router) outgoingMetadata(ctx context.Context, authHeaders map[string]string) metadata.MD {
+	incoming, _ := metadata.FromIncomingContext(ctx)
+	md := metadata.MD{}
+	for key, vals := range incoming {
+		switch {
+		case strings.HasPrefix(key, ":"),
+			strings.HasPrefix(key, "grpc-"),
+			key == "content-type",
+			key == "user-agent",
+			key == "te",
+			key == "authorization",
+			key == strings.ToLower(httpjwt.VerifiedJWTHeader),
+			key == strings.ToLower(hs.config.APIKeyHeader):
+			continue
+		}
+		md[key] = vals
+	}
+	for key, val := range authHeaders {
+		md.Set(key, val)
+	}
+	return md
+}
+
+func forwardToApp(src grpc.ServerStream, dst grpc.ClientStream) error {
+	for {
+		frame := &rawFrame{}
+		if err := src.RecvMsg(frame); err != nil {
+			if errors.Is(err, io.EOF) {
+				return dst.CloseSend()
+			}
+			return err
+		}
+		if err := dst.SendMsg(frame); err != nil {
+			// The app's error is returned by RecvMsg downstream
+			return nil
+		}
+	}
+}
+
+func forwardToCaller(src grpc.ClientStream, dst grpc.ServerStream) error {
+	for i := 0; ; i++ {
+		frame := &rawFrame{}
+		if err := src.RecvMsg(frame); err != nil {
+			return err
+		}
+		if i == 0 {
+			header, err := src.Header()
+			if err != nil {
+				return err
+			}
+			if err := dst.SendHeader(header); err != nil {
+				return err
+			}
+		}
+		if err := dst.SendMsg(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// httpStatusFromCode maps a gRPC status to the HTTP status the JSON proxy
+// would have used, so that metrics are comparable.
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return httpStatusClientClosed
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,212 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pentops/jwtauth/httpjwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+type grpcTestApp struct {
+	lock     sync.Mutex
+	metadata metadata.MD
+}
+
+func (ta *grpcTestApp) lastMetadata() metadata.MD {
+	ta.lock.Lock()
+	defer ta.lock.Unlock()
+	return ta.metadata
+}
+
+// runGRPCTestApp serves the health service as the app, behind a router with
+// native gRPC enabled.
+func runGRPCTestApp(t *testing.T) (*grpcTestApp, *httptest.Server) {
+	t.Helper()
+
+	app := &grpcTestApp{}
+	appServer := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		app.lock.Lock()
+		app.metadata = md
+		app.lock.Unlock()
+		return handler(ctx, req)
+	}))
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("foo", grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(appServer, healthServer)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go appServer.Serve(lis) // nolint: errcheck
+	t.Cleanup(appServer.Stop)
+
+	appConn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { appConn.Close() }) // nolint: errcheck
+
+	hs := &Router{
+		config: ServerConfig{
+			APIKeyHeader: "X-API-Key",
+		},
+		metrics: newRouterMetrics(),
+		authFunc: recordActor(func(ctx context.Context, req *http.Request) (map[string]string, error) {
+			if req.Header.Get("Authorization") != "Bearer good" {
+				return nil, errors.New("invalid token")
+			}
+			return map[string]string{
+				httpjwt.VerifiedJWTHeader: `{"sub":"user"}`,
+			}, nil
+		}),
+	}
+	hs.grpcHandler = hs.newGRPCHandler()
+
+	methods := grpcMethods{}
+	methods.add(grpc_health_v1.File_grpc_health_v1_health_proto.Services().Get(0), appConn)
+	hs.routes.Store(&ServiceRoutes{grpcMethods: methods})
+
+	srv := httptest.NewUnstartedServer(hs)
+	srv.Config.Protocols = &http.Protocols{}
+	srv.Config.Protocols.SetHTTP1(true)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return app, srv
+}
+
+func TestNativeGRPC(t *testing.T) {
+	app, srv := runGRPCTestApp(t)
+
+	conn, err := grpc.NewClient(strings.TrimPrefix(srv.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close() // nolint: errcheck
+
+	client := grpc_health_v1.NewHealthClient(conn)
+
+	ctx := metadata.AppendToOutgoingContext(t.Context(),
+		"authorization", "Bearer good",
+		strings.ToLower(httpjwt.VerifiedJWTHeader), `{"sub":"forged"}`,
+		"x-custom", "val",
+	)
+	res, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "foo"})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, res.Status)
+
+	md := app.lastMetadata()
+	assert.Equal(t, []string{`{"sub":"user"}`}, md.Get(httpjwt.VerifiedJWTHeader))
+	assert.Equal(t, []string{"val"}, md.Get("x-custom"))
+	assert.Empty(t, md.Get("authorization"))
+
+	_, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err), "app errors pass through")
+
+	stream, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{Service: "foo"})
+	require.NoError(t, err)
+	update, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, update.Status)
+
+	_, err = client.Check(t.Context(), &grpc_health_v1.HealthCheckRequest{Service: "foo"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	err = conn.Invoke(ctx, "/foo.v1.FooService/GetFoo", &grpc_health_v1.HealthCheckRequest{}, &grpc_health_v1.HealthCheckResponse{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func grpcWebFrame(t *testing.T, msg proto.Message) []byte {
+	data, err := proto.Marshal(msg)
+	require.NoError(t, err)
+	frame := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	return append(frame, data...)
+}
+
+// readGRPCWebFrames splits the body into the message and trailer frames.
+func readGRPCWebFrames(t *testing.T, body []byte) ([][]byte, string) {
+	var messages [][]byte
+	trailers := ""
+	for len(body) > 0 {
+		require.GreaterOrEqual(t, len(body), 5)
+		length := binary.BigEndian.Uint32(body[1:5])
+		data := body[5 : 5+length]
+		if body[0]&grpcWebTrailerFlag != 0 {
+			trailers += string(data)
+		} else {
+			messages = append(messages, data)
+		}
+		body = body[5+length:]
+	}
+	return messages, trailers
+}
+
+func TestGRPCWeb(t *testing.T) {
+	_, srv := runGRPCTestApp(t)
+
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		encode      func([]byte) []byte
+		decode      func([]byte) []byte
+	}{{
+		name:        "binary",
+		contentType: "application/grpc-web+proto",
+		encode:      func(b []byte) []byte { return b },
+		decode:      func(b []byte) []byte { return b },
+	}, {
+		name:        "text",
+		contentType: "application/grpc-web-text",
+		encode: func(b []byte) []byte {
+			return []byte(base64.StdEncoding.EncodeToString(b))
+		},
+		decode: func(b []byte) []byte {
+			decoded, err := base64.StdEncoding.DecodeString(string(b))
+			require.NoError(t, err)
+			return decoded
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := tc.encode(grpcWebFrame(t, &grpc_health_v1.HealthCheckRequest{Service: "foo"}))
+			req, err := http.NewRequest("POST", srv.URL+"/grpc.health.v1.Health/Check", bytes.NewReader(body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", tc.contentType)
+			req.Header.Set("Authorization", "Bearer good")
+
+			res, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, tc.contentType, res.Header.Get("Content-Type"))
+
+			raw, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			messages, trailers := readGRPCWebFrames(t, tc.decode(raw))
+
+			require.Len(t, messages, 1)
+			msg := &grpc_health_v1.HealthCheckResponse{}
+			require.NoError(t, proto.Unmarshal(messages[0], msg))
+			assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, msg.Status)
+			assert.Contains(t, trailers, "grpc-status: 0\r\n")
+		})
+	}
+}
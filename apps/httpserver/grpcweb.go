@@ -0,0 +1,160 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+
+	// grpcWebTrailerFlag marks the frame carrying the trailers at the end of
+	// a gRPC-Web response body.
+	grpcWebTrailerFlag = 0x80
+)
+
+// grpcWebHandler translates gRPC-Web requests into gRPC for the gRPC server,
+// and its responses back, moving the trailers into the body. Other requests
+// pass through.
+func grpcWebHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType := r.Header.Get("Content-Type")
+		if !strings.HasPrefix(contentType, grpcWebContentType) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		text := strings.HasPrefix(contentType, grpcWebTextContentType)
+		subtype := strings.TrimPrefix(contentType, grpcWebContentType)
+		if text {
+			subtype = strings.TrimPrefix(contentType, grpcWebTextContentType)
+		}
+
+		// Streaming over HTTP/1.1 reads the request while writing the response
+		http.NewResponseController(w).EnableFullDuplex() // nolint: errcheck
+
+		req := r.Clone(r.Context())
+		req.Proto = "HTTP/2.0"
+		req.ProtoMajor = 2
+		req.ProtoMinor = 0
+		req.ContentLength = -1
+		req.Header.Del("Content-Length")
+		req.Header.Set("Content-Type", "application/grpc"+subtype)
+		if text {
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{
+				Reader: base64.NewDecoder(base64.StdEncoding, r.Body),
+				Closer: r.Body,
+			}
+		}
+
+		gw := newGRPCWebWriter(w, contentType, text)
+		next.ServeHTTP(gw, req)
+		gw.finish()
+	})
+}
+
+type grpcWebWriter struct {
+	w           http.ResponseWriter
+	out         io.Writer
+	encoder     io.WriteCloser
+	header      http.Header
+	contentType string
+
+	// headers already sent, anything set later is a trailer
+	sent map[string]bool
+}
+
+func newGRPCWebWriter(w http.ResponseWriter, contentType string, text bool) *grpcWebWriter {
+	gw := &grpcWebWriter{
+		w:           w,
+		out:         w,
+		header:      http.Header{},
+		contentType: contentType,
+	}
+	if text {
+		gw.encoder = base64.NewEncoder(base64.StdEncoding, w)
+		gw.out = gw.encoder
+	}
+	return gw
+}
+
+func (gw *grpcWebWriter) Header() http.Header {
+	return gw.header
+}
+
+func (gw *grpcWebWriter) WriteHeader(status int) {
+	if gw.sent != nil {
+		return
+	}
+
+	gw.sent = map[string]bool{}
+	dst := gw.w.Header()
+	for key, vals := range gw.header {
+		gw.sent[key] = true
+		if key == "Trailer" || strings.HasPrefix(key, http.TrailerPrefix) {
+			continue
+		}
+		dst[key] = vals
+	}
+	dst.Set("Content-Type", gw.contentType)
+	gw.w.WriteHeader(status)
+}
+
+func (gw *grpcWebWriter) Write(b []byte) (int, error) {
+	if gw.sent == nil {
+		gw.WriteHeader(http.StatusOK)
+	}
+	return gw.out.Write(b)
+}
+
+func (gw *grpcWebWriter) Flush() {
+	if gw.sent == nil {
+		gw.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(gw.w).Flush() // nolint: errcheck
+}
+
+// finish writes the trailers as the last frame of the body.
+func (gw *grpcWebWriter) finish() {
+	if gw.sent == nil {
+		gw.WriteHeader(http.StatusOK)
+	}
+
+	trailers := http.Header{}
+	for key, vals := range gw.header {
+		switch {
+		case strings.HasPrefix(key, http.TrailerPrefix):
+			trailers[strings.TrimPrefix(key, http.TrailerPrefix)] = vals
+		case !gw.sent[key]:
+			trailers[key] = vals
+		}
+	}
+
+	if len(trailers) > 0 {
+		body := &bytes.Buffer{}
+		for key, vals := range trailers {
+			for _, val := range vals {
+				body.WriteString(strings.ToLower(key) + ": " + val + "\r\n")
+			}
+		}
+
+		frame := make([]byte, 5, 5+body.Len())
+		frame[0] = grpcWebTrailerFlag
+		binary.BigEndian.PutUint32(frame[1:], uint32(body.Len()))
+		frame = append(frame, body.Bytes()...)
+		gw.out.Write(frame) // nolint: errcheck
+	}
+
+	if gw.encoder != nil {
+		gw.encoder.Close() // nolint: errcheck
+	}
+	http.NewResponseController(gw.w).Flush() // nolint: errcheck
+}
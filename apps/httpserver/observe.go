@@ -0,0 +1,325 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pentops/j5/lib/proxy"
+	"github.com/pentops/jwtauth/httpjwt"
+	"github.com/pentops/log.go/log"
+	"github.com/pentops/o5-runtime-sidecar/adapters/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requestInfo is filled in as the request passes through the proxy, and
+// logged once it completes.
+type requestInfo struct {
+	grpcMethod string
+	actor      string
+	apiKey     string // hash of a verified API key
+	clientIP   string
+
+	// set when the request is rejected before reaching the app, overriding
+	// the status the proxy would respond with
+	rejected   int
+	retryAfter time.Duration
+
+	// the auth func runs once, whichever of the cache, the gRPC handler or
+	// the proxy needs it first
+	authenticated bool
+	authHeaders   map[string]string
+	authErr       error
+
+	// from the app's response metadata
+	cacheControl string
+
+	// native gRPC and gRPC-Web requests respond 200 with the status in the
+	// trailers, and are authenticated before the method is known
+	grpc bool
+
+	// the stream handler can outlive the request when the caller goes away
+	grpcLock     sync.Mutex
+	grpcDone     bool
+	grpcCode     grpccodes.Code
+	streamMethod string
+}
+
+type requestInfoKey struct{}
+
+func withRequestInfo(ctx context.Context) (context.Context, *requestInfo) {
+	info := &requestInfo{}
+	return context.WithValue(ctx, requestInfoKey{}, info), info
+}
+
+func getRequestInfo(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// setGRPCResult records the outcome of a native gRPC call, method is empty
+// when the app doesn't serve it.
+func (ri *requestInfo) setGRPCResult(method string, code grpccodes.Code) {
+	ri.grpcLock.Lock()
+	defer ri.grpcLock.Unlock()
+	ri.grpcDone = true
+	ri.streamMethod = method
+	ri.grpcCode = code
+}
+
+// observedGRPC returns the gRPC method and the HTTP equivalent of its
+// status, or 499 if the caller went away first.
+func (ri *requestInfo) observedGRPC() (string, int) {
+	ri.grpcLock.Lock()
+	defer ri.grpcLock.Unlock()
+	if !ri.grpcDone {
+		return "", httpStatusClientClosed
+	}
+	return ri.streamMethod, httpStatusFromCode(ri.grpcCode)
+}
+
+// admission applies auth policies and rate limits once both the method and
+// the client are known.
+type admission struct {
+	authorizer *authorizer
+	limiter    *rateLimiter
+}
+
+func (ad admission) admit(method string, info *requestInfo) error {
+	if ad.authorizer != nil {
+		if httpStatus, err := ad.authorizer.authorize(method, info.actor); err != nil {
+			info.rejected = httpStatus
+			if httpStatus == http.StatusUnauthorized {
+				return status.Error(grpccodes.Unauthenticated, err.Error())
+			}
+			return status.Error(grpccodes.PermissionDenied, err.Error())
+		}
+	}
+
+	if ad.limiter != nil {
+		if delay, ok := ad.limiter.allow(method, info); !ok {
+			info.rejected = http.StatusTooManyRequests
+			info.retryAfter = delay
+			return status.Error(grpccodes.ResourceExhausted, "rate limit exceeded")
+		}
+	}
+
+	return nil
+}
+
+// observedConn records the gRPC method each request is proxied to, admits it,
+// and passes the trace context on to the app.
+type observedConn struct {
+	proxy.AppConn
+	admission
+}
+
+func (oc observedConn) Invoke(ctx context.Context, method string, req any, res any, opts ...grpc.CallOption) error {
+	info := getRequestInfo(ctx)
+	if info == nil {
+		return oc.AppConn.Invoke(tracing.InjectOutgoing(ctx), method, req, res, opts...)
+	}
+
+	info.grpcMethod = method
+	if err := oc.admit(method, info); err != nil {
+		return err
+	}
+
+	header := metadata.MD{}
+	opts = append(opts, grpc.Header(&header))
+	err := oc.AppConn.Invoke(tracing.InjectOutgoing(ctx), method, req, res, opts...)
+	if vals := header.Get("cache-control"); len(vals) > 0 {
+		info.cacheControl = vals[0]
+	}
+	return err
+}
+
+// authenticate runs the auth func once per request.
+func (hs *Router) authenticate(ctx context.Context, req *http.Request) (map[string]string, error) {
+	info := getRequestInfo(req.Context())
+	if info == nil {
+		return hs.authFunc(ctx, req)
+	}
+	if !info.authenticated {
+		info.authenticated = true
+		info.authHeaders, info.authErr = hs.authFunc(ctx, req)
+	}
+	return info.authHeaders, info.authErr
+}
+
+// recordActor records the verified actor which the auth func passes on to the
+// app.
+func recordActor(auth func(context.Context, *http.Request) (map[string]string, error)) func(context.Context, *http.Request) (map[string]string, error) {
+	return func(ctx context.Context, req *http.Request) (map[string]string, error) {
+		headers, err := auth(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if info := getRequestInfo(req.Context()); info != nil {
+			info.actor = headers[httpjwt.VerifiedJWTHeader]
+		}
+		return headers, nil
+	}
+}
+
+type routerMetrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+
+	cacheResults *prometheus.CounterVec
+}
+
+func newRouterMetrics() *routerMetrics {
+	rm := &routerMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "o5_sidecar_http_requests_total",
+			Help: "Requests proxied to the app, by gRPC method and HTTP status code",
+		}, []string{"grpc_method", "code"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "o5_sidecar_http_request_errors_total",
+			Help: "Requests proxied to the app which failed with a 5xx status",
+		}, []string{"grpc_method"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "o5_sidecar_http_request_duration_seconds",
+			Help:    "Latency of requests proxied to the app",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"grpc_method"}),
+		cacheResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "o5_sidecar_http_cache_requests_total",
+			Help: "GET requests checked against the response cache, by hit or miss",
+		}, []string{"result"}),
+	}
+	rm.registry.MustRegister(rm.requests, rm.errors, rm.duration, rm.cacheResults)
+	return rm
+}
+
+func (rm *routerMetrics) observe(grpcMethod string, status int, duration time.Duration) {
+	rm.requests.WithLabelValues(grpcMethod, strconv.Itoa(status)).Inc()
+	if status >= 500 {
+		rm.errors.WithLabelValues(grpcMethod).Inc()
+	}
+	rm.duration.WithLabelValues(grpcMethod).Observe(duration.Seconds())
+}
+
+func (rm *routerMetrics) Handler() http.Handler {
+	return promhttp.HandlerFor(rm.registry, promhttp.HandlerOpts{})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	info   *requestInfo
+	status int
+	bytes  int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.info != nil && !sr.info.grpc && sr.info.rejected != 0 {
+		status = sr.info.rejected
+		if sr.info.retryAfter > 0 {
+			sr.Header().Set("Retry-After", retryAfterSeconds(sr.info.retryAfter))
+		}
+	}
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.WriteHeader(http.StatusOK)
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += n
+	return n, err
+}
+
+// Flush is required by the gRPC server
+func (sr *statusRecorder) Flush() {
+	if sr.status == 0 {
+		sr.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(sr.ResponseWriter).Flush() // nolint: errcheck
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// observeMiddleware traces and logs each request, and records metrics for
+// those proxied to a gRPC method.
+func (hs *Router) observeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		route := ""
+		if current := mux.CurrentRoute(r); current != nil {
+			route, _ = current.GetPathTemplate()
+		}
+
+		ctx := tracing.ExtractHTTP(r.Context(), r.Header)
+		ctx, span := tracing.Tracer().Start(ctx, r.Method+" "+route, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		ctx, info := withRequestInfo(ctx)
+		if hs.rateLimiter != nil {
+			hs.rateLimiter.describeRequest(r, info)
+		}
+		rec := &statusRecorder{ResponseWriter: w, info: info}
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		duration := time.Since(start)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		grpcMethod := info.grpcMethod
+		if info.grpc {
+			grpcMethod, rec.status = info.observedGRPC()
+		}
+
+		if grpcMethod != "" {
+			span.SetName(grpcMethod)
+		}
+		span.SetAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.Int("http.response.status_code", rec.status),
+		)
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+
+		if grpcMethod == "" && r.URL.Path == "/healthz" {
+			// Too noisy to log every load balancer check
+			return
+		}
+
+		log.WithFields(ctx, map[string]any{
+			"method":     r.Method,
+			"route":      route,
+			"grpcMethod": grpcMethod,
+			"status":     rec.status,
+			"durationMs": duration.Milliseconds(),
+			"bytes":      rec.bytes,
+			"actor":      info.actor,
+		}).Info("HTTP Request")
+
+		if grpcMethod != "" {
+			hs.metrics.observe(grpcMethod, rec.status, duration)
+		}
+	})
+}
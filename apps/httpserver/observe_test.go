@@ -0,0 +1,56 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pentops/jwtauth/httpjwt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObserveMiddleware(t *testing.T) {
+	hs := &Router{metrics: newRouterMetrics()}
+
+	handler := hs.observeMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := getRequestInfo(r.Context())
+		require.NotNil(t, info)
+		info.grpcMethod = "/test.v1.FooService/GetFoo"
+
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+
+	for _, path := range []string{"/ok", "/ok", "/fail"} {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest("GET", path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(hs.metrics.requests.WithLabelValues("/test.v1.FooService/GetFoo", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(hs.metrics.requests.WithLabelValues("/test.v1.FooService/GetFoo", "500")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(hs.metrics.errors.WithLabelValues("/test.v1.FooService/GetFoo")))
+
+	rw := httptest.NewRecorder()
+	hs.metrics.Handler().ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rw.Body.String(), "o5_sidecar_http_request_duration_seconds_count{grpc_method=\"/test.v1.FooService/GetFoo\"} 3")
+}
+
+func TestRecordActor(t *testing.T) {
+	auth := recordActor(func(ctx context.Context, req *http.Request) (map[string]string, error) {
+		return map[string]string{
+			httpjwt.VerifiedJWTHeader: "actor-value",
+		}, nil
+	})
+
+	ctx, info := withRequestInfo(t.Context())
+	req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	_, err := auth(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, "actor-value", info.actor)
+}
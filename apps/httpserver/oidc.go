@@ -0,0 +1,311 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pentops/jwtauth/httpjwt"
+	"github.com/pentops/jwtauth/jwks"
+	"github.com/pentops/log.go/log"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+
+	// Discovery is retried from this delay, doubling up to oidcRetryMaxDelay
+	oidcRetryDelay    = time.Second
+	oidcRetryMaxDelay = time.Minute
+)
+
+type oidcConfiguration struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// discoverJWKS resolves the issuer's key set URL from its OIDC discovery
+// document.
+func discoverJWKS(ctx context.Context, client *http.Client, issuer string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(issuer, "/")+oidcDiscoveryPath, nil)
+	if err != nil {
+		return "", fmt.Errorf("oidc discovery for %s: %w", issuer, err)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc discovery for %s: %w", issuer, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc discovery for %s: status %d", issuer, res.StatusCode)
+	}
+
+	config := oidcConfiguration{}
+	if err := json.NewDecoder(res.Body).Decode(&config); err != nil {
+		return "", fmt.Errorf("oidc discovery for %s: %w", issuer, err)
+	}
+
+	// The document must be for the issuer it was fetched from, otherwise its
+	// keys would vouch for tokens from another issuer.
+	if strings.TrimSuffix(config.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return "", fmt.Errorf("oidc discovery for %s: document is for issuer %q", issuer, config.Issuer)
+	}
+	if config.JWKSURI == "" {
+		return "", fmt.Errorf("oidc discovery for %s: no jwks_uri", issuer)
+	}
+
+	return config.JWKSURI, nil
+}
+
+// retryDiscoverJWKS retries discovery until it succeeds or the context is
+// done.
+func retryDiscoverJWKS(ctx context.Context, client *http.Client, issuer string, delay time.Duration) (string, error) {
+	for {
+		jwksURI, err := discoverJWKS(ctx, client, issuer)
+		if err == nil {
+			return jwksURI, nil
+		}
+
+		log.WithFields(ctx, map[string]any{
+			"issuer":     issuer,
+			"retryDelay": delay.String(),
+			"error":      err.Error(),
+		}).Error("OIDC discovery failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, oidcRetryMaxDelay)
+	}
+}
+
+// issuerKeys verifies each token with the key set of the issuer it claims, so
+// that a key from one issuer can't sign for another. OIDC issuers each have
+// their own discovered key set, the JWKS URLs are for any other issuer.
+type issuerKeys struct {
+	static  *jwks.JWKSManager
+	issuers map[string]*jwks.JWKSManager
+
+	staticAuth  func(context.Context, *http.Request) (map[string]string, error)
+	issuerAuths map[string]func(context.Context, *http.Request) (map[string]string, error)
+}
+
+func newIssuerKeys(jwksURLs []string, oidcIssuers []string) (*issuerKeys, error) {
+	ik := &issuerKeys{
+		issuers:     map[string]*jwks.JWKSManager{},
+		issuerAuths: map[string]func(context.Context, *http.Request) (map[string]string, error){},
+	}
+	if len(jwksURLs) > 0 {
+		ik.static = jwks.NewKeyManager()
+		if err := ik.static.AddSourceURLs(jwksURLs...); err != nil {
+			return nil, fmt.Errorf("configuring JWKS: %w", err)
+		}
+		ik.staticAuth = httpjwt.JWKSAuthFunc(ik.static)
+	}
+	for _, issuer := range oidcIssuers {
+		issuer = strings.TrimSuffix(issuer, "/")
+		keys := jwks.NewKeyManager()
+		ik.issuers[issuer] = keys
+		ik.issuerAuths[issuer] = httpjwt.JWKSAuthFunc(keys)
+	}
+	return ik, nil
+}
+
+// run loads the JWKS URLs straight away, and each issuer's keys once its
+// discovery succeeds, so that an issuer which is down only rejects its own
+// tokens.
+func (ik *issuerKeys) run(ctx context.Context) error {
+	eg, ctx := errgroup.WithContext(ctx)
+
+	if ik.static != nil {
+		eg.Go(func() error {
+			return ik.static.Run(ctx)
+		})
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	for issuer, keys := range ik.issuers {
+		eg.Go(func() error {
+			jwksURI, err := retryDiscoverJWKS(ctx, client, issuer, oidcRetryDelay)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			if err := keys.AddSourceURLs(jwksURI); err != nil {
+				return fmt.Errorf("configuring JWKS for %s: %w", issuer, err)
+			}
+			return keys.Run(ctx)
+		})
+	}
+
+	return eg.Wait()
+}
+
+// authFunc verifies the token against the keys of the issuer it claims.
+func (ik *issuerKeys) authFunc(ctx context.Context, req *http.Request) (map[string]string, error) {
+	issuer, ok := unverifiedIssuer(req)
+	if !ok {
+		// No bearer token to route, the key set handles it as it would
+		// any other
+		if ik.staticAuth != nil {
+			return ik.staticAuth(ctx, req)
+		}
+		for _, auth := range ik.issuerAuths {
+			return auth(ctx, req)
+		}
+	}
+
+	auth, isOIDC := ik.issuerAuths[issuer]
+	if !isOIDC {
+		if ik.staticAuth == nil {
+			return nil, status.Errorf(codes.Unauthenticated, "untrusted issuer %q", issuer)
+		}
+		auth = ik.staticAuth
+	}
+
+	headers, err := auth(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	actor, ok := headers[httpjwt.VerifiedJWTHeader]
+	if !ok {
+		return headers, nil
+	}
+
+	// The verified claims must be the ones the key set was chosen by
+	claims := registeredClaims{}
+	if err := json.Unmarshal([]byte(actor), &claims); err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "parsing claims: %s", err)
+	}
+	verified := strings.TrimSuffix(claims.Issuer, "/")
+	_, verifiedOIDC := ik.issuerAuths[verified]
+	if verified != issuer || verifiedOIDC != isOIDC {
+		return nil, status.Errorf(codes.Unauthenticated, "token for issuer %q is not signed by its keys", claims.Issuer)
+	}
+	return headers, nil
+}
+
+// unverifiedIssuer reads 'iss' from the bearer token, before its signature
+// is checked, to choose the keys to check it with.
+func unverifiedIssuer(req *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", false
+	}
+	claims := registeredClaims{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", false
+	}
+	return strings.TrimSuffix(claims.Issuer, "/"), true
+}
+
+// claimsValidator checks the registered claims of tokens which have already
+// had their signature verified.
+type claimsValidator struct {
+	issuers   []string
+	audiences []string
+	skew      time.Duration
+	now       func() time.Time
+}
+
+type registeredClaims struct {
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	Expiry    *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+}
+
+func (cv *claimsValidator) validate(actor string) error {
+	claims := registeredClaims{}
+	if err := json.Unmarshal([]byte(actor), &claims); err != nil {
+		return fmt.Errorf("parsing claims: %w", err)
+	}
+
+	if len(cv.issuers) > 0 && !slices.Contains(cv.issuers, strings.TrimSuffix(claims.Issuer, "/")) {
+		return fmt.Errorf("untrusted issuer %q", claims.Issuer)
+	}
+
+	if len(cv.audiences) > 0 {
+		audiences, err := parseAudience(claims.Audience)
+		if err != nil {
+			return err
+		}
+		if !slices.ContainsFunc(audiences, func(aud string) bool {
+			return slices.Contains(cv.audiences, aud)
+		}) {
+			return fmt.Errorf("token is not for this audience")
+		}
+	}
+
+	now := cv.now()
+	if claims.Expiry == nil {
+		return fmt.Errorf("token has no expiry")
+	}
+	if now.Add(-cv.skew).After(unixTime(*claims.Expiry)) {
+		return fmt.Errorf("token has expired")
+	}
+	if claims.NotBefore != nil && now.Add(cv.skew).Before(unixTime(*claims.NotBefore)) {
+		return fmt.Errorf("token is not yet valid")
+	}
+
+	return nil
+}
+
+// parseAudience reads 'aud', which is either a single string or an array.
+func parseAudience(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}, nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(raw, &multiple); err != nil {
+		return nil, fmt.Errorf("parsing aud claim: %w", err)
+	}
+	return multiple, nil
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// authFunc rejects verified tokens whose claims are not valid here.
+func (cv *claimsValidator) authFunc(auth func(context.Context, *http.Request) (map[string]string, error)) func(context.Context, *http.Request) (map[string]string, error) {
+	return func(ctx context.Context, req *http.Request) (map[string]string, error) {
+		headers, err := auth(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		actor, ok := headers[httpjwt.VerifiedJWTHeader]
+		if !ok {
+			return headers, nil
+		}
+		if err := cv.validate(actor); err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return headers, nil
+	}
+}
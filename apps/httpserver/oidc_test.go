@@ -0,0 +1,367 @@
+package httpserver
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pentops/jwtauth/httpjwt"
+	"github.com/pentops/o5-runtime-sidecar/sidecar"
+	"github.com/pentops/o5-runtime-sidecar/testproto/gen/test/v1/test_spb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testKeyID = "test-key"
+
+// testIssuer stands in for an OIDC provider, serving discovery and the public
+// half of the signing key.
+func testIssuer(t *testing.T, claimIssuer func(self string) string, key *rsa.PrivateKey) *httptest.Server {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	mux.HandleFunc(oidcDiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{ // nolint: errcheck
+			"issuer":   claimIssuer(srv.URL),
+			"jwks_uri": srv.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		keys := []map[string]string{}
+		if key != nil {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": testKeyID,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys}) // nolint: errcheck
+	})
+	return srv
+}
+
+// signToken builds an RS256 JWT with the claims.
+func signToken(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": testKeyID})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// fooApp accepts every call, the request only needs to get past auth.
+type fooApp struct{}
+
+func (fooApp) Invoke(ctx context.Context, method string, req any, res any, opts ...grpc.CallOption) error {
+	return nil
+}
+
+func TestOIDCTokens(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	issuer := testIssuer(t, func(self string) string { return self }, key)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherIssuer := testIssuer(t, func(self string) string { return self }, otherKey)
+
+	// Discovery never succeeds, which mustn't hold up the others
+	downIssuer := httptest.NewServer(http.NotFoundHandler())
+	downIssuer.Close()
+
+	hs, err := NewRouter(ServerConfig{
+		PublicAddr:   "127.0.0.1:0",
+		OIDCIssuers:  []string{issuer.URL, downIssuer.URL, otherIssuer.URL},
+		JWTAudiences: []string{"api"},
+		JWTClockSkew: time.Second,
+	}, sidecar.AppInfo{}, nil)
+	require.NoError(t, err)
+
+	fooService := test_spb.File_test_v1_service_test_p_j5s_proto.Services().ByName("FooService")
+	require.NoError(t, hs.ReplaceServices(t.Context(), []ServiceRegistration{{
+		Service: fooService,
+		Invoker: fooApp{},
+	}}))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	go hs.Run(ctx) // nolint: errcheck
+
+	now := time.Now()
+	get := func(signer *rsa.PrivateKey, mod func(map[string]any)) int {
+		claims := map[string]any{
+			"iss": issuer.URL,
+			"aud": "api",
+			"sub": "user",
+			"iat": now.Unix(),
+			"exp": now.Add(time.Hour).Unix(),
+			"nbf": now.Add(-time.Minute).Unix(),
+		}
+		mod(claims)
+
+		req := httptest.NewRequest("GET", "/test/v1/foo/abc", nil)
+		req.Header.Set("Authorization", "Bearer "+signToken(t, signer, claims))
+		rw := httptest.NewRecorder()
+		hs.ServeHTTP(rw, req)
+		return rw.Code
+	}
+
+	// The keys are loaded in the background once discovery completes
+	assert.Eventually(t, func() bool {
+		return get(key, func(map[string]any) {}) == http.StatusOK &&
+			get(otherKey, func(cc map[string]any) { cc["iss"] = otherIssuer.URL }) == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for _, tc := range []struct {
+		name string
+		mod  func(map[string]any)
+	}{{
+		name: "wrong audience",
+		mod:  func(cc map[string]any) { cc["aud"] = "other" },
+	}, {
+		name: "wrong issuer",
+		mod:  func(cc map[string]any) { cc["iss"] = "https://evil.example.com" },
+	}, {
+		name: "expired",
+		mod:  func(cc map[string]any) { cc["exp"] = now.Add(-time.Minute).Unix() },
+	}, {
+		name: "not yet valid",
+		mod:  func(cc map[string]any) { cc["nbf"] = now.Add(time.Minute).Unix() },
+	}, {
+		name: "signed by another issuer",
+		mod:  func(cc map[string]any) { cc["iss"] = otherIssuer.URL },
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, http.StatusUnauthorized, get(key, tc.mod))
+		})
+	}
+}
+
+// keySetAuth stands in for a key set holding only the key.
+func keySetAuth(key *rsa.PublicKey) func(context.Context, *http.Request) (map[string]string, error) {
+	return func(ctx context.Context, req *http.Request) (map[string]string, error) {
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			return nil, nil
+		}
+		parts := strings.Split(token, ".")
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, err
+		}
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid signature")
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{httpjwt.VerifiedJWTHeader: string(payload)}, nil
+	}
+}
+
+func TestIssuerKeys(t *testing.T) {
+	keyA, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyB, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	staticKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ik := &issuerKeys{
+		staticAuth: keySetAuth(&staticKey.PublicKey),
+		issuerAuths: map[string]func(context.Context, *http.Request) (map[string]string, error){
+			"https://a.example.com": keySetAuth(&keyA.PublicKey),
+			"https://b.example.com": keySetAuth(&keyB.PublicKey),
+		},
+	}
+
+	auth := func(signer *rsa.PrivateKey, issuer string) error {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+signToken(t, signer, map[string]any{"iss": issuer}))
+		_, err := ik.authFunc(t.Context(), req)
+		return err
+	}
+
+	assert.NoError(t, auth(keyA, "https://a.example.com"))
+	assert.NoError(t, auth(keyB, "https://b.example.com/"))
+	assert.NoError(t, auth(staticKey, "https://other.example.com"), "JWKS keys for other issuers")
+
+	assert.Error(t, auth(keyA, "https://b.example.com"), "issuer A signing for issuer B")
+	assert.Error(t, auth(staticKey, "https://a.example.com"), "JWKS keys signing for an OIDC issuer")
+	assert.Error(t, auth(keyA, "https://other.example.com"))
+
+	headers, err := ik.authFunc(t.Context(), httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err, "no token")
+	assert.Empty(t, headers)
+}
+
+func TestRetryDiscoverJWKS(t *testing.T) {
+	failures := atomic.Int32{}
+	failures.Store(2)
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{ // nolint: errcheck
+			"issuer":   srv.URL,
+			"jwks_uri": srv.URL + "/keys",
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	jwksURI, err := retryDiscoverJWKS(t.Context(), srv.Client(), srv.URL, time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/keys", jwksURI)
+
+	failures.Store(1000)
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	_, err = retryDiscoverJWKS(ctx, srv.Client(), srv.URL, time.Millisecond)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "retries until the context is done")
+}
+
+func TestDiscoverJWKS(t *testing.T) {
+	srv := testIssuer(t, func(self string) string { return self + "/" }, nil)
+
+	jwksURI, err := discoverJWKS(t.Context(), srv.Client(), srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/keys", jwksURI)
+
+	res, err := srv.Client().Get(jwksURI)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestDiscoverJWKSIssuerMismatch(t *testing.T) {
+	srv := testIssuer(t, func(string) string { return "https://other.example.com" }, nil)
+
+	_, err := discoverJWKS(t.Context(), srv.Client(), srv.URL)
+	assert.ErrorContains(t, err, "document is for issuer")
+}
+
+func TestClaimsValidator(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	validator := &claimsValidator{
+		issuers:   []string{"https://auth.example.com"},
+		audiences: []string{"api"},
+		skew:      30 * time.Second,
+		now:       func() time.Time { return now },
+	}
+
+	claims := func(mod func(map[string]any)) string {
+		cc := map[string]any{
+			"iss": "https://auth.example.com/",
+			"aud": "api",
+			"sub": "user",
+			"exp": now.Add(time.Minute).Unix(),
+			"nbf": now.Add(-time.Minute).Unix(),
+		}
+		mod(cc)
+		data, err := json.Marshal(cc)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		mod     func(map[string]any)
+		wantErr string
+	}{{
+		name: "valid",
+		mod:  func(map[string]any) {},
+	}, {
+		name: "audience array",
+		mod:  func(cc map[string]any) { cc["aud"] = []string{"other", "api"} },
+	}, {
+		name:    "wrong audience",
+		mod:     func(cc map[string]any) { cc["aud"] = []string{"other"} },
+		wantErr: "audience",
+	}, {
+		name:    "no audience",
+		mod:     func(cc map[string]any) { delete(cc, "aud") },
+		wantErr: "audience",
+	}, {
+		name:    "wrong issuer",
+		mod:     func(cc map[string]any) { cc["iss"] = "https://evil.example.com" },
+		wantErr: "issuer",
+	}, {
+		name:    "expired",
+		mod:     func(cc map[string]any) { cc["exp"] = now.Add(-time.Minute).Unix() },
+		wantErr: "expired",
+	}, {
+		name: "expired within skew",
+		mod:  func(cc map[string]any) { cc["exp"] = now.Add(-10 * time.Second).Unix() },
+	}, {
+		name:    "no expiry",
+		mod:     func(cc map[string]any) { delete(cc, "exp") },
+		wantErr: "expiry",
+	}, {
+		name:    "not yet valid",
+		mod:     func(cc map[string]any) { cc["nbf"] = now.Add(time.Minute).Unix() },
+		wantErr: "not yet valid",
+	}, {
+		name: "not yet valid within skew",
+		mod:  func(cc map[string]any) { cc["nbf"] = now.Add(10 * time.Second).Unix() },
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validator.validate(claims(tc.mod))
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestClaimsValidatorAuthFunc(t *testing.T) {
+	validator := &claimsValidator{
+		skew: time.Second,
+		now:  time.Now,
+	}
+
+	exp := time.Now().Add(-time.Hour).Unix()
+	auth := validator.authFunc(func(ctx context.Context, req *http.Request) (map[string]string, error) {
+		return map[string]string{
+			httpjwt.VerifiedJWTHeader: fmt.Sprintf(`{"sub":"user","exp":%d}`, exp),
+		}, nil
+	})
+
+	_, err := auth(t.Context(), httptest.NewRequest("GET", "/", nil))
+	assert.ErrorContains(t, err, "expired")
+
+	exp = time.Now().Add(time.Hour).Unix()
+	headers, err := auth(t.Context(), httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err)
+	assert.Contains(t, headers[httpjwt.VerifiedJWTHeader], `"sub":"user"`)
+}
@@ -0,0 +1,273 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	KeyBySubject = "subject"
+	KeyByAPIKey  = "apiKey"
+	KeyByIP      = "ip"
+
+	// Limiters for clients which have been idle this long are dropped
+	limiterIdle = 10 * time.Minute
+)
+
+// RateLimits is loaded from RATE_LIMIT_FILE.
+type RateLimits struct {
+	// Default applies to every method without an override, methods are not
+	// limited when it is not set.
+	Default *RateLimit `json:"default,omitempty"`
+
+	// Methods overrides the default by full gRPC method name, e.g.
+	// /foo.v1.FooService/GetFoo
+	Methods map[string]RateLimit `json:"methods,omitempty"`
+
+	// KeyBy is the order of preference for identifying the client, from
+	// 'subject', 'apiKey' and 'ip'. Defaults to all three in that order.
+	// Requests are only keyed by an API key which has been verified.
+	KeyBy []string `json:"keyBy,omitempty"`
+
+	// TrustedProxies are CIDRs whose X-Forwarded-For is trusted to give the
+	// client IP.
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
+}
+
+// RateLimit is a token bucket, refilling at Rate requests per second up to
+// Burst.
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+func (rl RateLimit) validate() error {
+	if rl.Rate <= 0 {
+		return fmt.Errorf("rate must be positive")
+	}
+	if rl.Burst < 1 {
+		return fmt.Errorf("burst must be at least 1")
+	}
+	return nil
+}
+
+func LoadRateLimits(filename string) (RateLimits, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return RateLimits{}, fmt.Errorf("reading rate limits: %w", err)
+	}
+
+	limits := RateLimits{}
+	if err := json.Unmarshal(data, &limits); err != nil {
+		return RateLimits{}, fmt.Errorf("parsing rate limits: %w", err)
+	}
+	return limits, nil
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type rateLimiter struct {
+	limits         RateLimits
+	keyBy          []string
+	trustedProxies []netip.Prefix
+
+	lock      sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+func newRateLimiter(limits RateLimits) (*rateLimiter, error) {
+	rl := &rateLimiter{
+		limits:  limits,
+		keyBy:   limits.KeyBy,
+		clients: map[string]*clientLimiter{},
+	}
+
+	if limits.Default != nil {
+		if err := limits.Default.validate(); err != nil {
+			return nil, fmt.Errorf("default rate limit: %w", err)
+		}
+	}
+	for method, limit := range limits.Methods {
+		if err := limit.validate(); err != nil {
+			return nil, fmt.Errorf("rate limit for %s: %w", method, err)
+		}
+	}
+
+	if len(rl.keyBy) == 0 {
+		rl.keyBy = []string{KeyBySubject, KeyByAPIKey, KeyByIP}
+	}
+	for _, keyBy := range rl.keyBy {
+		switch keyBy {
+		case KeyBySubject, KeyByAPIKey, KeyByIP:
+		default:
+			return nil, fmt.Errorf("unknown rate limit keyBy %q", keyBy)
+		}
+	}
+
+	for _, cidr := range limits.TrustedProxies {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", cidr, err)
+		}
+		rl.trustedProxies = append(rl.trustedProxies, prefix)
+	}
+
+	return rl, nil
+}
+
+// describeRequest records the client IP before auth runs, the subject and API
+// key are recorded once they are verified.
+func (rl *rateLimiter) describeRequest(r *http.Request, info *requestInfo) {
+	info.clientIP = rl.clientIP(r)
+}
+
+// clientIP walks X-Forwarded-For back from the connecting address until it
+// finds an address which isn't a trusted proxy.
+func (rl *rateLimiter) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !rl.isTrusted(addr) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		hopAddr, err := netip.ParseAddr(hop)
+		if err != nil {
+			// Can't trust anything further along
+			return host
+		}
+		host = hopAddr.String()
+		if !rl.isTrusted(hopAddr) {
+			return host
+		}
+	}
+	return host
+}
+
+func (rl *rateLimiter) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range rl.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rl *rateLimiter) clientKey(info *requestInfo) string {
+	for _, keyBy := range rl.keyBy {
+		switch keyBy {
+		case KeyBySubject:
+			if subject := actorSubject(info.actor); subject != "" {
+				return "subject:" + subject
+			}
+		case KeyByAPIKey:
+			if info.apiKey != "" {
+				return "apiKey:" + info.apiKey
+			}
+		case KeyByIP:
+			if info.clientIP != "" {
+				return "ip:" + info.clientIP
+			}
+		}
+	}
+	return ""
+}
+
+// actorSubject reads the subject from the verified JWT claims, or uses the
+// whole value for a static actor.
+func actorSubject(actor string) string {
+	if actor == "" {
+		return ""
+	}
+	claims := struct {
+		Subject string `json:"sub"`
+	}{}
+	if err := json.Unmarshal([]byte(actor), &claims); err != nil {
+		return actor
+	}
+	return claims.Subject
+}
+
+// allow takes a token for the client calling the method, returning how long
+// to wait before retrying when there are none.
+func (rl *rateLimiter) allow(method string, info *requestInfo) (time.Duration, bool) {
+	limit, bucket := rl.limitFor(method)
+	if limit == nil {
+		return 0, true
+	}
+
+	key := rl.clientKey(info)
+	if key == "" {
+		return 0, true
+	}
+	key = bucket + "|" + key
+
+	now := time.Now()
+
+	rl.lock.Lock()
+	rl.sweep(now)
+	client, ok := rl.clients[key]
+	if !ok {
+		client = &clientLimiter{
+			limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst),
+		}
+		rl.clients[key] = client
+	}
+	client.lastSeen = now
+	rl.lock.Unlock()
+
+	reservation := client.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return 0, true
+	}
+	reservation.CancelAt(now)
+	return delay, false
+}
+
+// limitFor returns the limit for the method, and the bucket it shares with
+// other methods on the same limit.
+func (rl *rateLimiter) limitFor(method string) (*RateLimit, string) {
+	if limit, ok := rl.limits.Methods[method]; ok {
+		return &limit, method
+	}
+	return rl.limits.Default, ""
+}
+
+// sweep drops idle limiters, the caller holds the lock.
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < time.Minute {
+		return
+	}
+	rl.lastSweep = now
+	for key, client := range rl.clients {
+		if now.Sub(client.lastSeen) > limiterIdle {
+			delete(rl.clients, key)
+		}
+	}
+}
+
+func retryAfterSeconds(delay time.Duration) string {
+	return fmt.Sprintf("%d", int(math.Ceil(delay.Seconds())))
+}
@@ -0,0 +1,91 @@
+package httpserver
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitClientIP(t *testing.T) {
+	rl, err := newRateLimiter(RateLimits{
+		TrustedProxies: []string{"10.0.0.0/8"},
+	})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}{{
+		name:   "direct",
+		remote: "203.0.113.5:1234",
+		want:   "203.0.113.5",
+	}, {
+		name:      "untrusted proxy is the client",
+		remote:    "203.0.113.5:1234",
+		forwarded: "198.51.100.1",
+		want:      "203.0.113.5",
+	}, {
+		name:      "trusted proxy",
+		remote:    "10.1.1.1:1234",
+		forwarded: "198.51.100.1",
+		want:      "198.51.100.1",
+	}, {
+		name:      "spoofed hop before the trusted chain",
+		remote:    "10.1.1.1:1234",
+		forwarded: "1.2.3.4, 198.51.100.1, 10.2.2.2",
+		want:      "198.51.100.1",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tc.remote
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			assert.Equal(t, tc.want, rl.clientIP(req))
+		})
+	}
+}
+
+func TestRateLimitAllow(t *testing.T) {
+	rl, err := newRateLimiter(RateLimits{
+		Default: &RateLimit{Rate: 0.001, Burst: 2},
+		Methods: map[string]RateLimit{
+			"/test.v1.FooService/Slow": {Rate: 0.001, Burst: 1},
+		},
+	})
+	require.NoError(t, err)
+
+	alice := &requestInfo{actor: `{"sub":"alice"}`, clientIP: "198.51.100.1"}
+	bob := &requestInfo{apiKey: "bob-key", clientIP: "198.51.100.1"}
+
+	_, ok := rl.allow("/test.v1.FooService/Get", alice)
+	assert.True(t, ok)
+	_, ok = rl.allow("/test.v1.FooService/List", alice)
+	assert.True(t, ok, "default methods share a bucket of 2")
+	delay, ok := rl.allow("/test.v1.FooService/Get", alice)
+	assert.False(t, ok)
+	assert.Positive(t, delay)
+
+	_, ok = rl.allow("/test.v1.FooService/Get", bob)
+	assert.True(t, ok, "bob is keyed by API key, not the shared IP")
+
+	_, ok = rl.allow("/test.v1.FooService/Slow", alice)
+	assert.True(t, ok, "overridden methods have their own bucket")
+	_, ok = rl.allow("/test.v1.FooService/Slow", alice)
+	assert.False(t, ok)
+}
+
+func TestRateLimitValidation(t *testing.T) {
+	_, err := newRateLimiter(RateLimits{Default: &RateLimit{Rate: 1}})
+	assert.Error(t, err)
+
+	_, err = newRateLimiter(RateLimits{KeyBy: []string{"email"}})
+	assert.Error(t, err)
+
+	_, err = newRateLimiter(RateLimits{TrustedProxies: []string{"10.0.0.1"}})
+	assert.Error(t, err)
+}
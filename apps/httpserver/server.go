@@ -6,15 +6,18 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/pentops/j5/lib/proxy"
 	"github.com/pentops/jwtauth/httpjwt"
-	"github.com/pentops/jwtauth/jwks"
 	"github.com/pentops/log.go/log"
 	"github.com/pentops/o5-runtime-sidecar/sidecar"
 	"golang.org/x/sync/errgroup"
-
-	"github.com/rs/cors"
+	"google.golang.org/grpc"
 	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
@@ -31,90 +34,390 @@ type ServerConfig struct {
 	StaticFiles string   `env:"STATIC_FILES" default:""`
 	CORSOrigins []string `env:"CORS_ORIGINS" default:""`
 	InjectActor string   `env:"INJECT_ACTOR" default:""`
-}
 
-type AppDetail struct {
-	SidecarVersion string
-}
+	// STATIC_FILES is served under the prefix for requests the app doesn't
+	// route. With STATIC_SPA, browsers get index.html for unknown paths
+	// without an extension, so that client side routes load the app.
+	StaticPrefix string `env:"STATIC_PREFIX" default:"/"`
+	StaticSPA    bool   `env:"STATIC_SPA" default:"true"`
 
-func NewRouter(config ServerConfig, app sidecar.AppInfo) (*Router, error) {
-	router := proxy.NewRouter()
+	// JSON CORSPolicies, in place of CORS_ORIGINS
+	CORSPolicyFile string `env:"CORS_POLICY_FILE" default:""`
 
-	router.SetHealthCheck("/healthz", func() error {
-		return nil
-	})
+	// OIDC issuers, whose keys are found through discovery and only verify
+	// tokens from that issuer
+	OIDCIssuers []string `env:"OIDC_ISSUERS" default:""`
 
-	router.AddMiddleware(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("X-Sidecar-Version", app.SidecarVersion)
-			next.ServeHTTP(w, r)
-		})
-	})
+	// Verified tokens must be from one of JWTIssuers or OIDCIssuers, and for
+	// one of JWTAudiences, when set. Expiry is always checked.
+	JWTIssuers   []string      `env:"JWT_ISSUERS" default:""`
+	JWTAudiences []string      `env:"JWT_AUDIENCES" default:""`
+	JWTClockSkew time.Duration `env:"JWT_CLOCK_SKEW" default:"30s"`
 
-	if len(config.CORSOrigins) > 0 {
-		router.AddMiddleware(cors.New(cors.Options{
-			AllowedOrigins:   config.CORSOrigins,
-			AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
-			AllowedHeaders:   []string{"*"},
-			AllowCredentials: true,
-		}).Handler)
-	}
+	// Also serve the app's services as native gRPC (h2c) and gRPC-Web
+	PublicGRPC bool `env:"PUBLIC_GRPC" default:"false"`
 
-	if config.StaticFiles != "" {
-		router.SetNotFoundHandler(http.FileServer(http.Dir(config.StaticFiles)))
-	}
+	// Bytes of GET responses to cache, for as long as the app's
+	// Cache-Control allows. Disabled when zero.
+	ResponseCacheBytes int64 `env:"RESPONSE_CACHE_BYTES" default:"0"`
+
+	// Serves Prometheus metrics, separately from the public port
+	MetricsAddr string `env:"METRICS_ADDR" default:""`
+
+	// JSON RateLimits, requests are not limited without it
+	RateLimitFile string `env:"RATE_LIMIT_FILE" default:""`
 
+	// JSON AuthPolicies, checked against the verified token before the
+	// request reaches the app
+	AuthPolicyFile string `env:"AUTH_POLICY_FILE" default:""`
+
+	// JSON APIKeys, accepted alongside JWKS. One of file or secret.
+	APIKeysFile    string        `env:"API_KEYS_FILE" default:""`
+	APIKeysSecret  string        `env:"API_KEYS_SECRET" default:""`
+	APIKeyHeader   string        `env:"API_KEY_HEADER" default:"X-API-Key"`
+	APIKeysRefresh time.Duration `env:"API_KEYS_REFRESH" default:"5m"`
+}
+
+type AppDetail struct {
+	SidecarVersion string
+}
+
+// NewRouter builds the public router, apiKeys is nil unless API keys are
+// configured.
+func NewRouter(config ServerConfig, app sidecar.AppInfo, apiKeys APIKeySource) (*Router, error) {
 	routerServer := &Router{
-		router:    router,
+		config:    config,
+		app:       app,
 		addr:      config.PublicAddr,
 		listening: make(chan struct{}),
+		metrics:   newRouterMetrics(),
+	}
+
+	if config.AuthPolicyFile != "" {
+		if len(config.JWKS) == 0 && len(config.OIDCIssuers) == 0 && config.InjectActor == "" && apiKeys == nil {
+			return nil, errors.New("AUTH_POLICY_FILE requires JWKS, OIDC_ISSUERS, API keys or INJECT_ACTOR")
+		}
+		policies, err := LoadAuthPolicies(config.AuthPolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		authorizer, err := newAuthorizer(policies)
+		if err != nil {
+			return nil, fmt.Errorf("configuring auth policies: %w", err)
+		}
+		routerServer.authorizer = authorizer
 	}
 
-	if len(config.JWKS) > 0 {
-		jwksManager := jwks.NewKeyManager()
-		if err := jwksManager.AddSourceURLs(config.JWKS...); err != nil {
-			return nil, fmt.Errorf("configuring JWKS: %w", err)
+	var authFunc func(context.Context, *http.Request) (map[string]string, error)
+
+	if len(config.JWKS) > 0 || len(config.OIDCIssuers) > 0 {
+		keys, err := newIssuerKeys(config.JWKS, config.OIDCIssuers)
+		if err != nil {
+			return nil, err
+		}
+
+		validator := &claimsValidator{
+			audiences: config.JWTAudiences,
+			skew:      config.JWTClockSkew,
+			now:       time.Now,
+		}
+		for _, issuer := range slices.Concat(config.JWTIssuers, config.OIDCIssuers) {
+			validator.issuers = append(validator.issuers, strings.TrimSuffix(issuer, "/"))
+		}
+
+		routerServer.keys = keys
+		authFunc = validator.authFunc(keys.authFunc)
+		if routerServer.authorizer != nil {
+			// Policies decide which methods need a token
+			authFunc = allowAnonymous(authFunc)
 		}
+	}
 
-		routerServer.jwks = jwksManager
-		globalAuth := proxy.AuthHeadersFunc(httpjwt.JWKSAuthFunc(jwksManager))
-		routerServer.router.SetGlobalAuth(globalAuth)
+	if apiKeys != nil {
+		routerServer.apiKeys = newAPIKeyStore(apiKeys, config.APIKeyHeader, config.APIKeysRefresh)
+		authFunc = routerServer.apiKeys.authFunc(authFunc, routerServer.authorizer != nil)
 	}
 
 	if config.InjectActor != "" {
-		if len(config.JWKS) > 0 {
-			return nil, errors.New("cannot use INJECT_ACTOR with JWKS authentication")
+		if authFunc != nil {
+			return nil, errors.New("cannot use INJECT_ACTOR with JWKS, OIDC or API key authentication")
 		}
-		globalAuth := proxy.AuthHeadersFunc(func(ctx context.Context, req *http.Request) (map[string]string, error) {
+		authFunc = func(ctx context.Context, req *http.Request) (map[string]string, error) {
 			return map[string]string{
 				httpjwt.VerifiedJWTHeader: config.InjectActor,
 			}, nil
-		})
-		routerServer.router.SetGlobalAuth(globalAuth)
+		}
+	}
+
+	if authFunc != nil {
+		routerServer.authFunc = recordActor(authFunc)
+		routerServer.globalAuth = proxy.AuthHeadersFunc(routerServer.authenticate)
+	}
+
+	if config.RateLimitFile != "" {
+		limits, err := LoadRateLimits(config.RateLimitFile)
+		if err != nil {
+			return nil, err
+		}
+		limiter, err := newRateLimiter(limits)
+		if err != nil {
+			return nil, fmt.Errorf("configuring rate limits: %w", err)
+		}
+		routerServer.rateLimiter = limiter
+	}
+
+	if config.CORSPolicyFile != "" || len(config.CORSOrigins) > 0 {
+		policies := CORSPolicies{
+			Default: CORSPolicy{
+				AllowedOrigins: config.CORSOrigins,
+			},
+		}
+		if len(config.CORSOrigins) == 1 && config.CORSOrigins[0] == "*" {
+			// CORS_ORIGINS=* has always meant any origin, which browsers
+			// only allow without credentials
+			noCredentials := false
+			policies.Default.AllowCredentials = &noCredentials
+		}
+		if config.CORSPolicyFile != "" {
+			if len(config.CORSOrigins) > 0 {
+				return nil, errors.New("set allowedOrigins in CORS_POLICY_FILE rather than CORS_ORIGINS")
+			}
+			loaded, err := LoadCORSPolicies(config.CORSPolicyFile)
+			if err != nil {
+				return nil, err
+			}
+			policies = loaded
+		}
+		corsRouter, err := newCORSRouter(policies)
+		if err != nil {
+			return nil, fmt.Errorf("configuring CORS: %w", err)
+		}
+		routerServer.cors = corsRouter
+	}
+
+	if config.StaticFiles != "" {
+		info, err := os.Stat(config.StaticFiles)
+		if err != nil {
+			return nil, fmt.Errorf("STATIC_FILES: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("STATIC_FILES %s is not a directory", config.StaticFiles)
+		}
+		routerServer.static = newStaticFiles(os.DirFS(config.StaticFiles), config.StaticPrefix, config.StaticSPA)
+	}
+
+	if config.ResponseCacheBytes > 0 {
+		routerServer.cache = newResponseCache(config.ResponseCacheBytes)
 	}
 
+	if config.PublicGRPC {
+		routerServer.grpcHandler = routerServer.newGRPCHandler()
+	}
+
+	routerServer.routes.Store(&ServiceRoutes{
+		router:      routerServer.newProxyRouter(),
+		grpcMethods: grpcMethods{},
+	})
+
 	return routerServer, nil
 }
 
+// newProxyRouter builds a router with everything but the app's services, which
+// are registered on top.
+func (hs *Router) newProxyRouter() proxyRouter {
+	router := proxy.NewRouter()
+
+	router.SetHealthCheck("/healthz", func() error {
+		if hs.readiness == nil {
+			return nil
+		}
+		return hs.readiness()
+	})
+
+	router.AddMiddleware(hs.observeMiddleware)
+
+	router.AddMiddleware(hs.versionMiddleware)
+	router.AddMiddleware(hs.corsMiddleware)
+
+	if hs.cache != nil {
+		router.AddMiddleware(hs.cacheMiddleware)
+	}
+
+	if hs.static != nil {
+		router.SetNotFoundHandler(hs.static)
+	}
+
+	if hs.globalAuth != nil {
+		router.SetGlobalAuth(hs.globalAuth)
+	}
+
+	return router
+}
+
+func (hs *Router) versionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Sidecar-Version", hs.app.SidecarVersion)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (hs *Router) corsMiddleware(next http.Handler) http.Handler {
+	if hs.cors == nil {
+		return next
+	}
+	return hs.cors.middleware(next)
+}
+
+// ServiceRoutes are the routes for a set of the app's services, built ahead of
+// being swapped in.
+type ServiceRoutes struct {
+	router      proxyRouter
+	grpcMethods grpcMethods
+}
+
 type Router struct {
-	addr      string
-	listening chan struct{}
-	router    proxyRouter
-	jwks      *jwks.JWKSManager
+	config     ServerConfig
+	app        sidecar.AppInfo
+	addr       string
+	listening  chan struct{}
+	routes     atomic.Pointer[ServiceRoutes]
+	keys       *issuerKeys
+	globalAuth proxy.AuthHeaders
+	authFunc   func(context.Context, *http.Request) (map[string]string, error)
+	readiness  func() error
+	metrics    *routerMetrics
+
+	rateLimiter *rateLimiter
+	authorizer  *authorizer
+	apiKeys     *apiKeyStore
+	grpcHandler http.Handler
+	cache       *responseCache
+	cors        *corsRouter
+	static      *staticFiles
+}
+
+// SetReadiness reports the app's readiness on /healthz, set before the server
+// starts. /livez only reports that the sidecar itself is up.
+func (hs *Router) SetReadiness(readiness func() error) {
+	hs.readiness = readiness
 }
 
+func (hs *Router) admission() admission {
+	return admission{
+		authorizer: hs.authorizer,
+		limiter:    hs.rateLimiter,
+	}
+}
+
+func (hs *Router) observedConn(invoker proxy.AppConn) observedConn {
+	return observedConn{
+		AppConn:   invoker,
+		admission: hs.admission(),
+	}
+}
+
+type ServiceRegistration struct {
+	Service protoreflect.ServiceDescriptor
+	Invoker proxy.AppConn
+
+	// Conn carries native gRPC and gRPC-Web calls, which are not routed to
+	// the service without it.
+	Conn grpc.ClientConnInterface
+}
+
+// RegisterService adds a service to the current JSON routes, before the
+// server starts.
 func (hs *Router) RegisterService(ctx context.Context, service protoreflect.ServiceDescriptor, invoker proxy.AppConn) error {
-	return hs.router.RegisterGRPCService(ctx, service, invoker)
+	return hs.routes.Load().router.RegisterGRPCService(ctx, service, hs.observedConn(invoker))
+}
+
+// ReplaceServices builds routes for the given services and swaps them in,
+// requests already being handled complete on the old routes.
+func (hs *Router) ReplaceServices(ctx context.Context, services []ServiceRegistration) error {
+	routes, err := hs.BuildRoutes(ctx, services)
+	if err != nil {
+		return err
+	}
+	hs.SetRoutes(routes)
+	return nil
+}
+
+// BuildRoutes builds routes for the given services without serving them, so
+// that they can be swapped in along with other changes which may fail.
+func (hs *Router) BuildRoutes(ctx context.Context, services []ServiceRegistration) (*ServiceRoutes, error) {
+	router := hs.newProxyRouter()
+	methods := grpcMethods{}
+	for _, reg := range services {
+		if err := router.RegisterGRPCService(ctx, reg.Service, hs.observedConn(reg.Invoker)); err != nil {
+			return nil, fmt.Errorf("register service %s: %w", reg.Service.FullName(), err)
+		}
+		if reg.Conn != nil {
+			methods.add(reg.Service, reg.Conn)
+		}
+	}
+
+	return &ServiceRoutes{
+		router:      router,
+		grpcMethods: methods,
+	}, nil
+}
+
+// SetRoutes swaps in routes from BuildRoutes.
+func (hs *Router) SetRoutes(routes *ServiceRoutes) {
+	hs.routes.Store(routes)
+}
+
+func (hs *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/livez" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if hs.isGRPC(r) {
+		hs.grpcHandler.ServeHTTP(w, r)
+		return
+	}
+	hs.routes.Load().router.ServeHTTP(w, r)
 }
 
 func (hs *Router) Run(ctx context.Context) error {
 
 	eg, ctx := errgroup.WithContext(ctx)
 
-	if hs.jwks != nil {
+	if hs.apiKeys != nil {
+		if err := hs.apiKeys.load(ctx); err != nil {
+			return err
+		}
+
+		eg.Go(func() error {
+			return hs.apiKeys.run(ctx)
+		})
+	}
 
+	if hs.keys != nil {
 		eg.Go(func() error {
-			return hs.jwks.Run(ctx)
+			// Serve while discovery retries through an outage at an issuer,
+			// its tokens are rejected until its keys are loaded.
+			return hs.keys.run(ctx)
+		})
+	}
+
+	if hs.config.MetricsAddr != "" {
+		metricsSrv := &http.Server{
+			Handler: hs.metrics.Handler(),
+			Addr:    hs.config.MetricsAddr,
+		}
+
+		go func() {
+			<-ctx.Done()
+			metricsSrv.Close() // nolint: errcheck
+		}()
+
+		eg.Go(func() error {
+			err := metricsSrv.ListenAndServe()
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return fmt.Errorf("metrics server: %w", err)
 		})
 	}
 
@@ -124,10 +427,18 @@ func (hs *Router) Run(ctx context.Context) error {
 	}
 
 	srv := http.Server{
-		Handler: hs.router,
+		Handler: hs,
 		Addr:    hs.addr,
 	}
 
+	if hs.grpcHandler != nil {
+		// Native gRPC clients connect with HTTP/2 without TLS, which is
+		// terminated at the load balancer
+		srv.Protocols = &http.Protocols{}
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+
 	hs.addr = lis.Addr().String()
 	close(hs.listening)
 
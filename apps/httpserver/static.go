@@ -0,0 +1,255 @@
+package httpserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	cacheImmutable  = "public, max-age=31536000, immutable"
+	cacheAsset      = "public, max-age=300"
+	cacheRevalidate = "no-cache"
+)
+
+// precompressed variants, in order of preference
+var staticEncodings = []struct {
+	encoding string
+	ext      string
+}{
+	{encoding: "br", ext: ".br"},
+	{encoding: "gzip", ext: ".gz"},
+}
+
+// staticFiles serves a built site from a directory under a path prefix.
+type staticFiles struct {
+	root   fs.FS
+	prefix string
+
+	// spa serves index.html for unknown paths which look like client side
+	// routes
+	spa bool
+}
+
+func newStaticFiles(root fs.FS, prefix string, spa bool) *staticFiles {
+	prefix = "/" + strings.Trim(prefix, "/") + "/"
+	if prefix == "//" {
+		prefix = "/"
+	}
+	return &staticFiles{
+		root:   root,
+		prefix: prefix,
+		spa:    spa,
+	}
+}
+
+func (sf *staticFiles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path+"/" == sf.prefix {
+		http.Redirect(w, r, sf.prefix, http.StatusMovedPermanently)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, sf.prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	header := w.Header()
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("X-Frame-Options", "SAMEORIGIN")
+	header.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+
+	name := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(r.URL.Path, sf.prefix)), "/")
+	if name == "" {
+		name = "index.html"
+	}
+
+	name, ok := sf.resolve(name)
+	if !ok {
+		if !sf.spa || path.Ext(name) != "" || !acceptsHTML(r) {
+			http.NotFound(w, r)
+			return
+		}
+		name = "index.html"
+		if !sf.isFile(name) {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	if err := sf.serveFile(w, r, name); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// resolve finds the file to serve for the name, directories serve their
+// index.html and are never listed. Dot files are hidden.
+func (sf *staticFiles) resolve(name string) (string, bool) {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return name, false
+		}
+	}
+
+	info, err := fs.Stat(sf.root, name)
+	if err != nil {
+		return name, false
+	}
+	if info.IsDir() {
+		index := path.Join(name, "index.html")
+		if !sf.isFile(index) {
+			return name, false
+		}
+		return index, true
+	}
+	return name, true
+}
+
+func (sf *staticFiles) isFile(name string) bool {
+	info, err := fs.Stat(sf.root, name)
+	return err == nil && !info.IsDir()
+}
+
+func (sf *staticFiles) serveFile(w http.ResponseWriter, r *http.Request, name string) error {
+	header := w.Header()
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	switch {
+	case path.Ext(name) == ".html":
+		header.Set("Cache-Control", cacheRevalidate)
+	case isHashedAsset(name):
+		header.Set("Cache-Control", cacheImmutable)
+	default:
+		header.Set("Cache-Control", cacheAsset)
+	}
+
+	served := name
+	for _, variant := range staticEncodings {
+		if !sf.isFile(name + variant.ext) {
+			continue
+		}
+		header.Set("Vary", "Accept-Encoding")
+		if acceptsEncoding(r, variant.encoding) {
+			served = name + variant.ext
+			header.Set("Content-Encoding", variant.encoding)
+			break
+		}
+	}
+
+	file, err := sf.root.Open(served)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(data)
+	}
+
+	header.Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	http.ServeContent(w, r, name, info.ModTime(), content)
+	return nil
+}
+
+// isHashedAsset looks for a build hash in the file name, which changes with
+// the content so the file can be cached forever. Webpack style names have a
+// hex hash segment, main.3f2a1b9c.js, and Vite (Rollup) style names end in an
+// 8 character base64url hash, index-BjK9dL2x.css. Version numbers and names
+// like bootstrap5.min.js or chart2024.js are not hashes.
+func isHashedAsset(name string) bool {
+	base := path.Base(name)
+	base = strings.TrimSuffix(base, path.Ext(base))
+
+	if len(base) > rollupHashLength && base[len(base)-rollupHashLength-1] == '-' &&
+		isRollupHash(base[len(base)-rollupHashLength:]) {
+		return true
+	}
+
+	for _, segment := range strings.FieldsFunc(base, func(r rune) bool {
+		return r == '.' || r == '-'
+	}) {
+		if isHexHash(segment) {
+			return true
+		}
+	}
+	return false
+}
+
+const rollupHashLength = 8
+
+// isHexHash requires a digit and a letter, so that numbers like dates aren't
+// mistaken for hashes.
+func isHexHash(segment string) bool {
+	if len(segment) < 8 {
+		return false
+	}
+	digits, letters := 0, 0
+	for _, r := range segment {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r >= 'a' && r <= 'f':
+			letters++
+		default:
+			return false
+		}
+	}
+	return digits > 0 && letters > 0
+}
+
+// isRollupHash requires upper case as well as lower case or digits, so that
+// words and names like legacy-module2 aren't mistaken for hashes.
+func isRollupHash(hash string) bool {
+	var upper, other bool
+	for _, r := range hash {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			other = true
+		case r == '-' || r == '_':
+		default:
+			return false
+		}
+	}
+	return upper && other
+}
+
+func acceptsHTML(r *http.Request) bool {
+	// Browsers navigating, rather than scripts fetching
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(name) != encoding {
+			continue
+		}
+		return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+	}
+	return false
+}
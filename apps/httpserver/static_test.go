@@ -0,0 +1,129 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticFiles(t *testing.T) {
+	root := fstest.MapFS{
+		"index.html":                  {Data: []byte("<html>app</html>")},
+		"assets/index-BjK9dL2x.js":    {Data: []byte("hashed js")},
+		"assets/index-BjK9dL2x.js.br": {Data: []byte("brotli js")},
+		"assets/index-BjK9dL2x.js.gz": {Data: []byte("gzip js")},
+		"assets/logo.svg":             {Data: []byte("<svg/>")},
+		"docs/index.html":             {Data: []byte("<html>docs</html>")},
+		"empty/.keep":                 {Data: []byte{}},
+		".env":                        {Data: []byte("SECRET=1")},
+	}
+
+	get := func(sf *staticFiles, path string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		rw := httptest.NewRecorder()
+		sf.ServeHTTP(rw, req)
+		return rw
+	}
+
+	sf := newStaticFiles(root, "/app", true)
+
+	t.Run("index", func(t *testing.T) {
+		rw := get(sf, "/app/")
+		assert.Equal(t, http.StatusOK, rw.Code)
+		assert.Equal(t, "<html>app</html>", rw.Body.String())
+		assert.Equal(t, "no-cache", rw.Header().Get("Cache-Control"))
+		assert.Equal(t, "nosniff", rw.Header().Get("X-Content-Type-Options"))
+
+		rw = get(sf, "/app")
+		assert.Equal(t, http.StatusMovedPermanently, rw.Code)
+		assert.Equal(t, "/app/", rw.Header().Get("Location"))
+	})
+
+	t.Run("spa fallback", func(t *testing.T) {
+		rw := get(sf, "/app/users/123", "Accept", "text/html,application/xhtml+xml")
+		assert.Equal(t, http.StatusOK, rw.Code)
+		assert.Equal(t, "<html>app</html>", rw.Body.String())
+
+		rw = get(sf, "/app/users/123", "Accept", "application/json")
+		assert.Equal(t, http.StatusNotFound, rw.Code, "not for scripts")
+
+		rw = get(sf, "/app/assets/missing.js", "Accept", "text/html")
+		assert.Equal(t, http.StatusNotFound, rw.Code, "not for files")
+
+		rw = get(newStaticFiles(root, "/app", false), "/app/users/123", "Accept", "text/html")
+		assert.Equal(t, http.StatusNotFound, rw.Code, "disabled")
+	})
+
+	t.Run("directories", func(t *testing.T) {
+		rw := get(sf, "/app/docs/")
+		assert.Equal(t, "<html>docs</html>", rw.Body.String())
+
+		rw = get(sf, "/app/empty/")
+		assert.Equal(t, http.StatusNotFound, rw.Code, "not listed")
+	})
+
+	t.Run("hidden", func(t *testing.T) {
+		rw := get(sf, "/app/.env")
+		assert.Equal(t, http.StatusNotFound, rw.Code)
+
+		rw = get(sf, "/app/empty/.keep")
+		assert.Equal(t, http.StatusNotFound, rw.Code)
+	})
+
+	t.Run("outside prefix", func(t *testing.T) {
+		rw := get(sf, "/index.html")
+		assert.Equal(t, http.StatusNotFound, rw.Code)
+
+		rw = get(sf, "/app/../../etc/passwd")
+		assert.Equal(t, http.StatusNotFound, rw.Code, "kept within the root")
+	})
+
+	t.Run("precompressed", func(t *testing.T) {
+		rw := get(sf, "/app/assets/index-BjK9dL2x.js", "Accept-Encoding", "gzip, br")
+		assert.Equal(t, "brotli js", rw.Body.String())
+		assert.Equal(t, "br", rw.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", rw.Header().Get("Vary"))
+		assert.Contains(t, rw.Header().Get("Content-Type"), "javascript")
+		assert.Equal(t, cacheImmutable, rw.Header().Get("Cache-Control"))
+
+		rw = get(sf, "/app/assets/index-BjK9dL2x.js", "Accept-Encoding", "gzip, br;q=0")
+		assert.Equal(t, "gzip js", rw.Body.String())
+		assert.Equal(t, "gzip", rw.Header().Get("Content-Encoding"))
+
+		rw = get(sf, "/app/assets/index-BjK9dL2x.js")
+		assert.Equal(t, "hashed js", rw.Body.String())
+		assert.Empty(t, rw.Header().Get("Content-Encoding"))
+	})
+
+	t.Run("caching", func(t *testing.T) {
+		rw := get(sf, "/app/assets/logo.svg")
+		assert.Equal(t, cacheAsset, rw.Header().Get("Cache-Control"))
+
+		etag := rw.Header().Get("ETag")
+		rw = get(sf, "/app/assets/logo.svg", "If-None-Match", etag)
+		assert.Equal(t, http.StatusNotModified, rw.Code)
+	})
+}
+
+func TestIsHashedAsset(t *testing.T) {
+	assert.True(t, isHashedAsset("assets/index-BjK9dL2x.js"))
+	assert.True(t, isHashedAsset("static/js/main.3f2a1b9c.chunk.js"))
+	assert.False(t, isHashedAsset("assets/component-library.js"))
+	assert.False(t, isHashedAsset("jquery-3.6.0.min.js"))
+	assert.False(t, isHashedAsset("logo.svg"))
+
+	assert.True(t, isHashedAsset("assets/vendor-a_3-Kx9Z.js"), "base64url hash with a dash")
+	assert.True(t, isHashedAsset("static/css/main.0a1b2c3d4e5f6a7b8c9d.css"), "longer webpack hash")
+	assert.False(t, isHashedAsset("polyfills2.js"))
+	assert.False(t, isHashedAsset("bootstrap5.min.js"))
+	assert.False(t, isHashedAsset("chart2024.js"))
+	assert.False(t, isHashedAsset("release-20240101.js"), "dates are not hashes")
+	assert.False(t, isHashedAsset("legacy-module2.js"))
+	assert.False(t, isHashedAsset("app-deadbeef.js"), "hex hashes have digits")
+}
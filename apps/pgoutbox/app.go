@@ -17,7 +17,7 @@ type App struct {
 	*Outbox
 }
 
-func NewApps(envConfig OutboxConfig, parser Parser, sender Batcher, pgConfigs pgclient.ConfigSet) ([]*App, error) {
+func NewApps(envConfig OutboxConfig, parser Parser, sender Batcher, dlh DeadLetterHandler, pgConfigs pgclient.ConfigSet) ([]*App, error) {
 	var apps []*App
 	for _, rawVar := range envConfig.PostgresOutboxURI {
 		conn, err := pgConfigs.GetConnector(rawVar)
@@ -25,7 +25,7 @@ func NewApps(envConfig OutboxConfig, parser Parser, sender Batcher, pgConfigs pg
 			return nil, fmt.Errorf("building postgres connection: %w", err)
 		}
 
-		app, err := NewApp(conn, sender, parser, envConfig.PostgresOutboxDelayable)
+		app, err := NewApp(conn, sender, parser, dlh, envConfig.PostgresOutboxDelayable)
 		if err != nil {
 			return nil, fmt.Errorf("creating outbox listener: %w", err)
 		}
@@ -36,10 +36,10 @@ func NewApps(envConfig OutboxConfig, parser Parser, sender Batcher, pgConfigs pg
 	return apps, nil
 }
 
-func NewApp(conn pgclient.PGConnector, batcher Batcher, parser Parser, delayable bool) (*App, error) {
+func NewApp(conn pgclient.PGConnector, batcher Batcher, parser Parser, dlh DeadLetterHandler, delayable bool) (*App, error) {
 	name := conn.Name()
 
-	o, err := NewOutbox(conn, batcher, parser, delayable)
+	o, err := NewOutbox(conn, batcher, parser, dlh, delayable)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create outbox listener: %w", err)
 	}
@@ -12,7 +12,12 @@ import (
 	"github.com/pentops/log.go/log"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/google/uuid"
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_tpb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/msgconvert"
+	"github.com/pentops/o5-runtime-sidecar/adapters/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var ErrSend = errors.New("error sending batch of outbox messages")
@@ -22,7 +27,11 @@ type Batcher interface {
 }
 
 type Parser interface {
-	ParseMessage(id string, data []byte) (*messaging_pb.Message, error)
+	ParseMessage(ctx context.Context, id string, data []byte) (*messaging_pb.Message, error)
+}
+
+type DeadLetterHandler interface {
+	DeadMessage(context.Context, *messaging_tpb.DeadMessage) error
 }
 
 type pgConnector interface {
@@ -34,10 +43,14 @@ type Outbox struct {
 	connector pgConnector
 	publisher Batcher
 	parser    Parser
+	dlh       DeadLetterHandler
 	delayable bool
 }
 
-func NewOutbox(connector pgConnector, publisher Batcher, parser Parser, delayable bool) (*Outbox, error) {
+// NewOutbox creates an outbox listener. With a dead letter handler, messages
+// which the parser rejects as invalid are dead-lettered and removed, without
+// one they fail the batch.
+func NewOutbox(connector pgConnector, publisher Batcher, parser Parser, dlh DeadLetterHandler, delayable bool) (*Outbox, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 
@@ -64,6 +77,7 @@ func NewOutbox(connector pgConnector, publisher Batcher, parser Parser, delayabl
 		connector: connector,
 		publisher: publisher,
 		parser:    parser,
+		dlh:       dlh,
 		delayable: delayable,
 	}, nil
 }
@@ -257,18 +271,39 @@ func (o *Outbox) doBatch(ctx context.Context, tx pgx.Tx) error {
 		return nil
 	}
 
-	msgs := make([]*messaging_pb.Message, len(msgRows))
-	for idx, row := range msgRows {
-		msg, err := o.parser.ParseMessage(row.id, row.message)
+	// Messages the app didn't trace itself are children of the publish
+	ctx, span := tracing.Tracer().Start(ctx, "outbox publish", trace.WithSpanKind(trace.SpanKindProducer))
+	defer span.End()
+
+	msgs := make([]*messaging_pb.Message, 0, len(msgRows))
+	deadIDs := []string{}
+	for _, row := range msgRows {
+		msg, err := o.parser.ParseMessage(ctx, row.id, row.message)
 		if err != nil {
-			return fmt.Errorf("error parsing outbox message: %w", err)
+			invalid := &msgconvert.ValidationError{}
+			if o.dlh == nil || !errors.As(err, &invalid) {
+				return fmt.Errorf("error parsing outbox message: %w", err)
+			}
+
+			if err := o.deadLetter(ctx, invalid); err != nil {
+				return fmt.Errorf("error dead-lettering invalid outbox message: %w", err)
+			}
+
+			deadIDs = append(deadIDs, row.id)
+			continue
 		}
 
-		msgs[idx] = msg
+		tracing.InjectMessage(ctx, msg)
+		msgs = append(msgs, msg)
 	}
 
 	// NOTE: this err is handled at the end to allow adding deletion of successful messages to the tx
-	successIDs, delayedErr := o.publisher.PublishBatch(ctx, msgs)
+	var successIDs []string
+	var delayedErr error
+	if len(msgs) > 0 {
+		successIDs, delayedErr = o.publisher.PublishBatch(ctx, msgs)
+	}
+	successIDs = append(successIDs, deadIDs...)
 
 	log.WithField(ctx, "successCount", len(successIDs)).Debug("published outbox messages")
 
@@ -289,3 +324,25 @@ func (o *Outbox) doBatch(ctx context.Context, tx pgx.Tx) error {
 
 	return nil
 }
+
+func (o *Outbox) deadLetter(ctx context.Context, invalid *msgconvert.ValidationError) error {
+	log.WithFields(ctx, map[string]any{
+		"messageId": invalid.Message.MessageId,
+		"reason":    invalid.Reason,
+	}).Warn("Dead-lettering invalid outbox message")
+
+	return o.dlh.DeadMessage(ctx, &messaging_tpb.DeadMessage{
+		DeathId: uuid.NewString(),
+		Message: invalid.Message,
+		Problem: &messaging_tpb.Problem{
+			Type: &messaging_tpb.Problem_UnhandledError_{
+				UnhandledError: &messaging_tpb.Problem_UnhandledError{
+					Error: invalid.Error(),
+				},
+			},
+		},
+		Infra: &messaging_tpb.Infra{
+			Type: "OUTBOX",
+		},
+	})
+}
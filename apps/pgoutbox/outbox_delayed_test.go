@@ -31,7 +31,7 @@ func TestDelayedOutbox(t *testing.T) {
 	}
 
 	conv := msgconvert.NewConverter(sidecar.AppInfo{})
-	o, err := NewOutbox(conn, batcher, conv, true)
+	o, err := NewOutbox(conn, batcher, conv, nil, true)
 	if err != nil {
 		t.Fatalf("failed to create outbox listener: %s", err)
 	}
@@ -33,7 +33,7 @@ func TestOutbox(t *testing.T) {
 	}
 
 	conv := msgconvert.NewConverter(sidecar.AppInfo{})
-	o, err := NewOutbox(conn, batcher, conv, false)
+	o, err := NewOutbox(conn, batcher, conv, nil, false)
 	if err != nil {
 		t.Fatalf("failed to create outbox listener: %s", err)
 	}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/pentops/log.go/log"
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-runtime-sidecar/sidecar"
 )
 
 type ResendHandler struct {
@@ -60,3 +61,40 @@ func randomlySelected(ctx context.Context, pct int) bool {
 	}
 	return false
 }
+
+// ReplyFilter drops replies which are addressed to another app or env. Replies
+// without a reply-to are handled like any other message. Subscriptions are
+// configured outside of the sidecar, and a broad one (a shared topic, or a
+// wildcard binding) also delivers replies to requests which other apps sent.
+type ReplyFilter struct {
+	source  sidecar.AppInfo
+	handler Handler
+}
+
+func NewReplyFilter(handler Handler, source sidecar.AppInfo) *ReplyFilter {
+	return &ReplyFilter{
+		handler: handler,
+		source:  source,
+	}
+}
+
+func (rf *ReplyFilter) HandleMessage(ctx context.Context, msg *messaging_pb.Message) error {
+	reply := msg.GetReply()
+	if reply != nil && reply.ReplyTo != "" && reply.ReplyTo != rf.source.ReplyTo() {
+		log.WithFields(ctx, map[string]any{
+			"messageId": msg.MessageId,
+			"replyTo":   reply.ReplyTo,
+		}).Warn("Dropping reply addressed to another app")
+		return nil
+	}
+
+	return rf.handler.HandleMessage(ctx, msg)
+}
+
+// Readiness is satisfied by grpchealth.Checker. Workers check it in their
+// fetch or consume loop, so that messages aren't delivered to wait out their
+// visibility or ack timeout while the app is not serving.
+type Readiness interface {
+	Ready() error
+	WaitReady(context.Context) error
+}
@@ -0,0 +1,146 @@
+package messaging
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pentops/j5/gen/j5/messaging/v1/messaging_j5pb"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-messaging/o5msg"
+	"github.com/pentops/o5-runtime-sidecar/adapters/msgconvert"
+	"github.com/pentops/o5-runtime-sidecar/sidecar"
+	"github.com/pentops/o5-runtime-sidecar/testproto/gen/test/v1/test_tpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// capturingApp stands in for the app behind a worker, decoding each invoked
+// message into a new `into`.
+func capturingApp(t *testing.T, into func() proto.Message) (encoderInvoker, *[]proto.Message) {
+	received := []proto.Message{}
+	return encoderInvoker{
+		invoke: func(ctx context.Context, method string, req any, res any, opts ...grpc.CallOption) error {
+			data, err := proto.Marshal(req.(proto.Message))
+			require.NoError(t, err)
+			msg := into()
+			require.NoError(t, proto.Unmarshal(data, msg))
+			received = append(received, msg)
+			return nil
+		},
+	}, &received
+}
+
+func TestRequestReply(t *testing.T) {
+	ctx := context.Background()
+
+	requester := sidecar.AppInfo{SourceEnv: "prod", SourceApp: "requester"}
+	responder := sidecar.AppInfo{SourceEnv: "prod", SourceApp: "responder"}
+
+	// The requesting app sends through its sidecar
+	request, err := o5msg.WrapMessage(&test_tpb.TestReqResRequestMessage{
+		Request: &messaging_j5pb.RequestMetadata{
+			Context: []byte("request-context"),
+		},
+		Id: "foo",
+	})
+	require.NoError(t, err)
+
+	request, err = msgconvert.NewConverter(requester).ConvertMessage(t.Context(), request)
+	require.NoError(t, err)
+	assert.Equal(t, "prod/requester", request.GetRequest().ReplyTo)
+
+	// The responder's worker passes the reply-to address into the request
+	responderApp, requests := capturingApp(t, func() proto.Message {
+		return &test_tpb.TestReqResRequestMessage{}
+	})
+	responderRouter := NewRouter()
+	requestTopic := test_tpb.File_test_v1_topic_test_p_j5s_proto.Services().ByName("TestReqResRequestTopic")
+	require.NoError(t, responderRouter.RegisterService(ctx, requestTopic, responderApp))
+
+	require.NoError(t, NewReplyFilter(responderRouter, responder).HandleMessage(ctx, request))
+	require.Len(t, *requests, 1)
+	gotRequest := (*requests)[0].(*test_tpb.TestReqResRequestMessage)
+	assert.Equal(t, "prod/requester", gotRequest.Request.ReplyTo)
+
+	// The responding app copies the request metadata to its reply
+	reply, err := o5msg.WrapMessage(&test_tpb.TestReqResReplyMessage{
+		Request: gotRequest.Request,
+		Id:      gotRequest.Id,
+		Name:    "bar",
+	})
+	require.NoError(t, err)
+
+	reply, err = msgconvert.NewConverter(responder).ConvertMessage(t.Context(), reply)
+	require.NoError(t, err)
+
+	env, app, ok := sidecar.ReplyDestination(reply)
+	require.True(t, ok)
+	assert.Equal(t, "prod", env)
+	assert.Equal(t, "requester", app)
+
+	replyTopic := test_tpb.File_test_v1_topic_test_p_j5s_proto.Services().ByName("TestReqResReplyTopic")
+
+	// Another app on the same subscription ignores the reply
+	otherApp, otherReplies := capturingApp(t, func() proto.Message {
+		return &test_tpb.TestReqResReplyMessage{}
+	})
+	otherRouter := NewRouter()
+	require.NoError(t, otherRouter.RegisterService(ctx, replyTopic, otherApp))
+	other := sidecar.AppInfo{SourceEnv: "prod", SourceApp: "other"}
+	require.NoError(t, NewReplyFilter(otherRouter, other).HandleMessage(ctx, reply))
+	assert.Empty(t, *otherReplies)
+
+	// The requester receives the reply with its original context
+	requesterApp, replies := capturingApp(t, func() proto.Message {
+		return &test_tpb.TestReqResReplyMessage{}
+	})
+	requesterRouter := NewRouter()
+	require.NoError(t, requesterRouter.RegisterService(ctx, replyTopic, requesterApp))
+	require.NoError(t, NewReplyFilter(requesterRouter, requester).HandleMessage(ctx, reply))
+	require.Len(t, *replies, 1)
+	gotReply := (*replies)[0].(*test_tpb.TestReqResReplyMessage)
+	assert.Equal(t, "foo", gotReply.Id)
+	assert.Equal(t, "bar", gotReply.Name)
+	assert.Equal(t, []byte("request-context"), gotReply.Request.Context)
+}
+
+func TestReplyFilterOtherEnv(t *testing.T) {
+	called := false
+	filter := NewReplyFilter(HandlerFunc(func(ctx context.Context, msg *messaging_pb.Message) error {
+		called = true
+		return nil
+	}), sidecar.AppInfo{SourceEnv: "prod", SourceApp: "requester"})
+
+	msg := &messaging_pb.Message{
+		Extension: &messaging_pb.Message_Reply_{
+			Reply: &messaging_pb.Message_Reply{
+				ReplyTo: "staging/requester",
+			},
+		},
+	}
+	require.NoError(t, filter.HandleMessage(context.Background(), msg))
+	assert.False(t, called)
+
+	msg.Extension = nil
+	require.NoError(t, filter.HandleMessage(context.Background(), msg))
+	assert.True(t, called)
+}
+
+func TestReplyFilterNoReplyTo(t *testing.T) {
+	called := false
+	filter := NewReplyFilter(HandlerFunc(func(ctx context.Context, msg *messaging_pb.Message) error {
+		called = true
+		return nil
+	}), sidecar.AppInfo{SourceEnv: "prod", SourceApp: "requester"})
+
+	// Published as an ordinary message, e.g. by an app outside o5
+	msg := &messaging_pb.Message{
+		Extension: &messaging_pb.Message_Reply_{
+			Reply: &messaging_pb.Message_Reply{},
+		},
+	}
+	require.NoError(t, filter.HandleMessage(context.Background(), msg))
+	assert.True(t, called)
+}
@@ -3,9 +3,12 @@ package messaging
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/pentops/log.go/log"
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/wire"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
@@ -27,51 +30,121 @@ func (hf HandlerFunc) HandleMessage(ctx context.Context, msg *messaging_pb.Messa
 	return hf(ctx, msg)
 }
 
+// Decrypter restores message bodies encrypted by the publishing sidecar.
+type Decrypter interface {
+	Decrypt(context.Context, *messaging_pb.Message) error
+}
+
 type Router struct {
+	lock            sync.RWMutex
 	handlers        map[string]Handler
 	fallbackHandler Handler
+
+	// custom handlers are kept when services are replaced
+	custom map[string]Handler
+
+	decrypter Decrypter
 }
 
 func NewRouter() *Router {
 	return &Router{
 		handlers: make(map[string]Handler),
+		custom:   make(map[string]Handler),
 	}
 }
 
+type ServiceRegistration struct {
+	Service protoreflect.ServiceDescriptor
+	Invoker AppLink
+}
+
 func (rr *Router) RegisterService(ctx context.Context, service protoreflect.ServiceDescriptor, invoker AppLink) error {
-	methods := service.Methods()
-	for ii := range methods.Len() {
-		method := methods.Get(ii)
-		if err := rr.registerMethod(ctx, method, invoker); err != nil {
-			return err
-		}
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+	return registerService(ctx, service, invoker, rr.handlers, &rr.fallbackHandler)
+}
+
+// ReplaceServices swaps the registered services for the given set, messages
+// already being handled complete with the old handlers.
+func (rr *Router) ReplaceServices(ctx context.Context, services []ServiceRegistration) error {
+	handlers, err := BuildHandlers(ctx, services)
+	if err != nil {
+		return err
 	}
+	rr.SetHandlers(handlers)
 	return nil
 }
 
-func (rr *Router) registerMethod(ctx context.Context, method protoreflect.MethodDescriptor, invoker AppLink) error {
-	serviceName := method.Parent().(protoreflect.ServiceDescriptor).FullName()
-	fullName := fmt.Sprintf("/%s/%s", serviceName, method.Name())
+// ServiceHandlers are the handlers for a set of the app's services, built
+// ahead of being swapped in.
+type ServiceHandlers struct {
+	handlers        map[string]Handler
+	fallbackHandler Handler
+}
 
-	if fullName == GenericTopic {
-		log.WithField(ctx, "service", fullName).Info("Registering Generic Fallback")
-		rr.fallbackHandler = &genericHandler{
-			invoker: invoker,
+// BuildHandlers builds handlers for the given services without routing to
+// them, so that they can be swapped in along with other changes which may
+// fail.
+func BuildHandlers(ctx context.Context, services []ServiceRegistration) (*ServiceHandlers, error) {
+	sh := &ServiceHandlers{
+		handlers: make(map[string]Handler),
+	}
+	for _, reg := range services {
+		if err := registerService(ctx, reg.Service, reg.Invoker, sh.handlers, &sh.fallbackHandler); err != nil {
+			return nil, err
 		}
+	}
+	return sh, nil
+}
 
-	} else {
-		log.WithField(ctx, "service", fullName).Info("Registering Worker Service")
-		ss := &service{
-			requestMessage: method.Input(),
-			fullName:       fullName,
-			invoker:        invoker,
+// SetHandlers swaps in handlers from BuildHandlers, keeping custom handlers.
+func (rr *Router) SetHandlers(sh *ServiceHandlers) {
+	handlers := make(map[string]Handler, len(sh.handlers)+len(rr.custom))
+	for fullMethod, handler := range sh.handlers {
+		handlers[fullMethod] = handler
+	}
+
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+	for fullMethod, handler := range rr.custom {
+		handlers[fullMethod] = handler
+	}
+	rr.handlers = handlers
+	rr.fallbackHandler = sh.fallbackHandler
+}
+
+func registerService(ctx context.Context, desc protoreflect.ServiceDescriptor, invoker AppLink, handlers map[string]Handler, fallbackHandler *Handler) error {
+	methods := desc.Methods()
+	for ii := range methods.Len() {
+		method := methods.Get(ii)
+		fullName := fmt.Sprintf("/%s/%s", desc.FullName(), method.Name())
+
+		if fullName == GenericTopic {
+			log.WithField(ctx, "service", fullName).Info("Registering Generic Fallback")
+			*fallbackHandler = &genericHandler{
+				invoker: invoker,
+			}
+
+		} else {
+			log.WithField(ctx, "service", fullName).Info("Registering Worker Service")
+			handlers[fullName] = &service{
+				requestMessage: method.Input(),
+				fullName:       fullName,
+				invoker:        invoker,
+			}
 		}
-		rr.handlers[ss.fullName] = ss
 	}
 	return nil
 }
 
+func (rr *Router) SetDecrypter(decrypter Decrypter) {
+	rr.decrypter = decrypter
+}
+
 func (rr *Router) RegisterHandler(fullMethod string, handler Handler) {
+	rr.lock.Lock()
+	defer rr.lock.Unlock()
+	rr.custom[fullMethod] = handler
 	rr.handlers[fullMethod] = handler
 }
 
@@ -85,15 +158,30 @@ func (rr *Router) HandleMessage(ctx context.Context, parsed *messaging_pb.Messag
 	log.Debug(ctx, "Message Handler: Begin")
 
 	fullServiceName := fmt.Sprintf("/%s/%s", parsed.GrpcService, parsed.GrpcMethod)
+	rr.lock.RLock()
 	handler, ok := rr.handlers[fullServiceName]
+	fallbackHandler := rr.fallbackHandler
+	rr.lock.RUnlock()
 	if !ok {
-		if rr.fallbackHandler != nil {
+		if fallbackHandler != nil {
 			log.Debug(ctx, "Message Handler: Using fallback handler")
-			handler = rr.fallbackHandler
+			handler = fallbackHandler
 		} else {
 			return ErrNoHandlerMatched(fullServiceName)
 		}
 	}
 
+	if wire.IsEncrypted(parsed) {
+		if rr.decrypter == nil {
+			return fmt.Errorf("message is encrypted and no MESSAGE_ENCRYPTION_PROVIDER is configured")
+		}
+
+		// Decrypt a copy, the original is dead-lettered if the handler fails
+		parsed = proto.Clone(parsed).(*messaging_pb.Message)
+		if err := rr.decrypter.Decrypt(ctx, parsed); err != nil {
+			return fmt.Errorf("failed to decrypt message: %w", err)
+		}
+	}
+
 	return handler.HandleMessage(ctx, parsed)
 }
@@ -8,6 +8,8 @@ import (
 	"github.com/pentops/j5/lib/j5codec"
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_tpb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/tracing"
+	"github.com/pentops/o5-runtime-sidecar/adapters/wire"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/encoding/protojson"
@@ -45,6 +47,10 @@ type genericHandler struct {
 }
 
 func (gh genericHandler) HandleMessage(ctx context.Context, message *messaging_pb.Message) error {
+	if err := wire.Decompress(message); err != nil {
+		return fmt.Errorf("failed to decompress message body: %w", err)
+	}
+
 	protoBody := &messaging_tpb.GenericMessage{
 		Message: message,
 	}
@@ -53,6 +59,7 @@ func (gh genericHandler) HandleMessage(ctx context.Context, message *messaging_p
 		return fmt.Errorf("failed to create message header: %w", err)
 	}
 	ctx = metadata.NewOutgoingContext(ctx, requestMetadata)
+	ctx = tracing.InjectOutgoing(ctx)
 
 	outputMessage := &emptypb.Empty{}
 
@@ -68,6 +75,10 @@ type service struct {
 }
 
 func (ss service) HandleMessage(ctx context.Context, message *messaging_pb.Message) error {
+	// Transports which don't decompress while parsing are covered here
+	if err := wire.Decompress(message); err != nil {
+		return fmt.Errorf("failed to decompress message body: %w", err)
+	}
 
 	protoBody, err := ss.parseMessageBody(message)
 	if err != nil {
@@ -79,6 +90,7 @@ func (ss service) HandleMessage(ctx context.Context, message *messaging_pb.Messa
 		return fmt.Errorf("failed to create message header: %w", err)
 	}
 	ctx = metadata.NewOutgoingContext(ctx, requestMetadata)
+	ctx = tracing.InjectOutgoing(ctx)
 
 	outputMessage := &emptypb.Empty{}
 
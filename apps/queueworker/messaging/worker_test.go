@@ -8,6 +8,7 @@ import (
 	"github.com/pentops/j5/lib/j5codec"
 	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
 	"github.com/pentops/o5-messaging/o5msg"
+	"github.com/pentops/o5-runtime-sidecar/adapters/wire"
 	"github.com/pentops/o5-runtime-sidecar/testproto/gen/test/v1/test_tpb"
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/encoding/prototext"
@@ -128,3 +129,83 @@ func TestRequestMetadata(t *testing.T) {
 		t.Fatalf("Messages do not match")
 	}
 }
+
+type testDecrypter struct{}
+
+func (testDecrypter) Decrypt(ctx context.Context, msg *messaging_pb.Message) error {
+	delete(msg.Headers, wire.EncryptionHeader)
+	msg.Body.Value = []byte("plain")
+	return nil
+}
+
+func TestRouterDecrypt(t *testing.T) {
+	ww := NewRouter()
+
+	var received string
+	ww.RegisterHandler("/test.v1.FooTopic/Foo", HandlerFunc(func(ctx context.Context, msg *messaging_pb.Message) error {
+		received = string(msg.Body.Value)
+		return nil
+	}))
+
+	msg := &messaging_pb.Message{
+		GrpcService: "test.v1.FooTopic",
+		GrpcMethod:  "Foo",
+		Headers: map[string]string{
+			wire.EncryptionHeader: "aes-256-gcm",
+		},
+		Body: &messaging_pb.Any{
+			Value: []byte("sealed"),
+		},
+	}
+
+	if err := ww.HandleMessage(context.Background(), msg); err == nil {
+		t.Fatal("expected an error without a decrypter")
+	}
+
+	ww.SetDecrypter(testDecrypter{})
+	if err := ww.HandleMessage(context.Background(), msg); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if received != "plain" {
+		t.Fatalf("handler received %q", received)
+	}
+
+	// The original is left encrypted for dead letters
+	if string(msg.Body.Value) != "sealed" || !wire.IsEncrypted(msg) {
+		t.Fatal("original message was modified")
+	}
+}
+
+func TestReplaceServices(t *testing.T) {
+	ww := NewRouter()
+	ctx := context.Background()
+
+	services := test_tpb.File_test_v1_topic_test_p_j5s_proto.Services()
+	fooTopic := services.ByName("TestPublishTopic")
+	reqTopic := services.ByName("TestReqResRequestTopic")
+
+	if err := ww.RegisterService(ctx, fooTopic, nil); err != nil {
+		t.Fatal(err.Error())
+	}
+	ww.RegisterHandler("/test.v1.FooTopic/Foo", HandlerFunc(func(ctx context.Context, msg *messaging_pb.Message) error {
+		return nil
+	}))
+
+	if err := ww.ReplaceServices(ctx, []ServiceRegistration{{
+		Service: reqTopic,
+		Invoker: encoderInvoker{},
+	}}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, ok := ww.handlers["/test.v1.topic.TestPublishTopic/Foo"]; ok {
+		t.Fatal("removed service still registered")
+	}
+	if _, ok := ww.handlers["/test.v1.topic.TestReqResRequestTopic/TestReqResRequest"]; !ok {
+		t.Fatal("new service not registered")
+	}
+	if _, ok := ww.handlers["/test.v1.FooTopic/Foo"]; !ok {
+		t.Fatal("custom handler not kept")
+	}
+}
@@ -17,6 +17,7 @@ type WorkerConfig struct {
 
 type App struct {
 	queueWorker *sqsmsg.Worker
+	readiness   messaging.Readiness
 }
 
 type Publisher interface {
@@ -33,6 +34,8 @@ func NewApp(config WorkerConfig, info sidecar.AppInfo, publisher messaging.Publi
 		dlh = messaging.NewO5MessageDeadLetterHandler(publisher, info)
 	}
 
+	handler = messaging.NewReplyFilter(handler, info)
+
 	if config.ResendChance > 0 {
 		handler = messaging.NewResendHandler(handler, config.ResendChance)
 	}
@@ -44,6 +47,23 @@ func NewApp(config WorkerConfig, info sidecar.AppInfo, publisher messaging.Publi
 
 }
 
+// SetReadiness pauses receiving messages while the app is not ready, rather
+// than holding them until their visibility timeout runs out.
+func (app *App) SetReadiness(readiness messaging.Readiness) {
+	app.readiness = readiness
+}
+
 func (app *App) Run(ctx context.Context) error {
-	return app.queueWorker.Run(ctx)
+	if app.readiness == nil {
+		return app.queueWorker.Run(ctx)
+	}
+
+	for {
+		if err := app.readiness.WaitReady(ctx); err != nil {
+			return err
+		}
+		if err := app.queueWorker.FetchOnce(ctx); err != nil {
+			return err
+		}
+	}
 }
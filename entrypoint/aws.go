@@ -7,6 +7,8 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/sns"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
@@ -61,6 +63,22 @@ func (acb *AWSConfigBuilder) EventBridge(ctx context.Context) (EventBridgeAPI, e
 	return eventbridge.NewFromConfig(config), nil
 }
 
+func (acb *AWSConfigBuilder) KMS(ctx context.Context) (KMSAPI, error) {
+	config, err := acb.getConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return kms.NewFromConfig(config), nil
+}
+
+func (acb *AWSConfigBuilder) SecretsManager(ctx context.Context) (SecretsManagerAPI, error) {
+	config, err := acb.getConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return secretsmanager.NewFromConfig(config), nil
+}
+
 func (acb *AWSConfigBuilder) Region() string {
 	return acb.config.Region
 }
@@ -100,6 +118,8 @@ type AWSProvider interface {
 	SNS(context.Context) (SNSAPI, error)
 	SQS(context.Context) (SQSAPI, error)
 	EventBridge(context.Context) (EventBridgeAPI, error)
+	KMS(context.Context) (KMSAPI, error)
+	SecretsManager(context.Context) (SecretsManagerAPI, error)
 
 	Region() string
 	Credentials(context.Context) (aws.CredentialsProvider, error)
@@ -124,3 +144,16 @@ type SQSAPI interface {
 type EventBridgeAPI interface {
 	PutEvents(ctx context.Context, params *eventbridge.PutEventsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
 }
+
+// KMSAPI is an interface for the KMS client which satisfies the interfaces of
+// other packages
+type KMSAPI interface {
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// SecretsManagerAPI is an interface for the Secrets Manager client which
+// satisfies the interfaces of other packages
+type SecretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
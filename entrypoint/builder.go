@@ -3,11 +3,19 @@ package entrypoint
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/pentops/o5-runtime-sidecar/adapters/amqp"
+	"github.com/pentops/o5-runtime-sidecar/adapters/envelope"
 	"github.com/pentops/o5-runtime-sidecar/adapters/eventbridge"
+	"github.com/pentops/o5-runtime-sidecar/adapters/fanout"
+	"github.com/pentops/o5-runtime-sidecar/adapters/kafka"
 	"github.com/pentops/o5-runtime-sidecar/adapters/msgconvert"
+	"github.com/pentops/o5-runtime-sidecar/adapters/nats"
 	"github.com/pentops/o5-runtime-sidecar/adapters/pgclient"
+	"github.com/pentops/o5-runtime-sidecar/adapters/tracing"
+	"github.com/pentops/o5-runtime-sidecar/adapters/wire"
 	"github.com/pentops/o5-runtime-sidecar/apps/bridge"
 	"github.com/pentops/o5-runtime-sidecar/apps/httpserver"
 	"github.com/pentops/o5-runtime-sidecar/apps/pgoutbox"
@@ -30,8 +38,18 @@ type Config struct {
 	BridgeConfig      bridge.BridgeConfig
 	EventBridgeConfig eventbridge.EventBridgeConfig
 	AMQPConfig        amqp.AMQPConfig
+	KafkaConfig       kafka.KafkaConfig
+	NATSConfig        nats.NATSConfig
+	FanoutConfig      fanout.FanoutConfig
+	ValidationConfig  msgconvert.ValidationConfig
+	CompressionConfig wire.CompressionConfig
+	EncryptionConfig  envelope.EncryptionConfig
+	TracingConfig     tracing.TracingConfig
 
 	ServiceEndpoints []string `env:"SERVICE_ENDPOINT" default:""`
+
+	// How often to re-fetch the app's services, in addition to on reconnect
+	ServiceRefreshInterval time.Duration `env:"SERVICE_REFRESH_INTERVAL" default:"1m"`
 }
 
 type Publisher interface {
@@ -48,7 +66,42 @@ func FromConfig(ctx context.Context, envConfig Config, awsConfig AWSProvider) (*
 
 	runtime := NewRuntime()
 	runtime.endpoints = envConfig.ServiceEndpoints
+	runtime.refreshInterval = envConfig.ServiceRefreshInterval
 	runtime.msgConverter = msgconvert.NewConverter(srcConfig)
+	if err := runtime.msgConverter.SetValidation(envConfig.ValidationConfig); err != nil {
+		return nil, err
+	}
+	if err := runtime.msgConverter.SetCompression(envConfig.CompressionConfig); err != nil {
+		return nil, err
+	}
+
+	if envConfig.TracingConfig.Exporter != "" {
+		provider, err := tracing.NewProvider(ctx, envConfig.TracingConfig, srcConfig)
+		if err != nil {
+			return nil, fmt.Errorf("creating tracing provider: %w", err)
+		}
+		runtime.tracing = provider
+	}
+
+	var decrypter *envelope.Decrypter
+	if envConfig.EncryptionConfig.KeyProvider != "" {
+		provider, err := keyProvider(ctx, envConfig.EncryptionConfig, awsConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		if envConfig.EncryptionConfig.KeyID != "" {
+			encrypter, err := envelope.NewEncrypter(provider, envConfig.EncryptionConfig)
+			if err != nil {
+				return nil, fmt.Errorf("creating message encrypter: %w", err)
+			}
+			runtime.msgConverter.SetEncryption(encrypter)
+		}
+
+		decrypter = envelope.NewDecrypter(provider)
+	}
+
+	publishers := []fanout.NamedPublisher{}
 
 	// Publish to EventBridge
 	if envConfig.EventBridgeConfig.BusARN != "" {
@@ -61,9 +114,80 @@ func FromConfig(ctx context.Context, envConfig Config, awsConfig AWSProvider) (*
 			return nil, fmt.Errorf("creating eventbridge publisher: %w", err)
 		}
 
+		publishers = append(publishers, fanout.NamedPublisher{Name: "eventbridge", Publisher: s})
+	}
+
+	if envConfig.AMQPConfig.URI != "" {
+		publisher, err := amqp.NewPublisher(envConfig.AMQPConfig, envConfig.EnvironmentName)
+		if err != nil {
+			return nil, fmt.Errorf("creating amqp publisher: %w", err)
+		}
+
+		publishers = append(publishers, fanout.NamedPublisher{Name: "amqp", Publisher: publisher})
+	}
+
+	if len(envConfig.KafkaConfig.Brokers) > 0 {
+		publisher, err := kafka.NewPublisher(envConfig.KafkaConfig, envConfig.EnvironmentName)
+		if err != nil {
+			return nil, fmt.Errorf("creating kafka publisher: %w", err)
+		}
+
+		publishers = append(publishers, fanout.NamedPublisher{Name: "kafka", Publisher: publisher})
+	}
+
+	if envConfig.NATSConfig.URL != "" {
+		publisher, err := nats.NewPublisher(envConfig.NATSConfig, envConfig.EnvironmentName)
+		if err != nil {
+			return nil, fmt.Errorf("creating nats publisher: %w", err)
+		}
+
+		publishers = append(publishers, fanout.NamedPublisher{Name: "nats", Publisher: publisher})
+	}
+
+	publishers, err := fanout.Enabled(envConfig.FanoutConfig, publishers)
+	if err != nil {
+		return nil, fmt.Errorf("selecting publishers: %w", err)
+	}
+
+	switch len(publishers) {
+	case 0:
+		if envConfig.FanoutConfig.RulesFile != "" {
+			return nil, fmt.Errorf("PUBLISH_RULES_FILE set without any publishers")
+		}
+
+	case 1:
+		if envConfig.FanoutConfig.RulesFile != "" {
+			return nil, fmt.Errorf("PUBLISH_RULES_FILE requires more than one publisher")
+		}
+		runtime.sender = publishers[0].Publisher
+
+	default:
+		// Publish to multiple
+		s, err := fanout.NewPublisher(envConfig.FanoutConfig, publishers)
+		if err != nil {
+			return nil, fmt.Errorf("creating fanout publisher: %w", err)
+		}
+
 		runtime.sender = s
 	}
 
+	workers := []string{}
+	if envConfig.WorkerConfig.SQSURL != "" {
+		workers = append(workers, "SQS_URL")
+	}
+	if envConfig.AMQPConfig.Queue != "" {
+		workers = append(workers, "AMQP_QUEUE")
+	}
+	if envConfig.KafkaConfig.GroupID != "" {
+		workers = append(workers, "KAFKA_GROUP_ID")
+	}
+	if envConfig.NATSConfig.Consumer != "" {
+		workers = append(workers, "NATS_CONSUMER")
+	}
+	if len(workers) > 1 {
+		return nil, fmt.Errorf("only one queue worker can be configured, got %s", strings.Join(workers, ", "))
+	}
+
 	// Subscribe to SQS messages
 	if envConfig.WorkerConfig.SQSURL != "" {
 		sqs, err := awsConfig.SQS(ctx)
@@ -78,28 +202,33 @@ func FromConfig(ctx context.Context, envConfig Config, awsConfig AWSProvider) (*
 		if err != nil {
 			return nil, fmt.Errorf("creating queue worker: %w", err)
 		}
+		w.SetReadiness(runtime.health)
 
 		runtime.queueWorker = w
 	}
 
-	if envConfig.AMQPConfig.URI != "" {
-		if runtime.sender != nil {
-			return nil, fmt.Errorf("cannot set both AMQP_URI and EVENTBRIDGE_ARN")
-		}
-		if runtime.queueWorker != nil {
-			return nil, fmt.Errorf("cannot set both AMQP_URI and SQS_URL")
+	if envConfig.AMQPConfig.Queue != "" {
+		if envConfig.AMQPConfig.URI == "" {
+			return nil, fmt.Errorf("AMQP_QUEUE set but AMQP_URI is empty")
 		}
 
-		publisher, err := amqp.NewPublisher(envConfig.AMQPConfig, envConfig.EnvironmentName)
+		router := messaging.NewRouter()
+		runtime.queueRouter = router
+
+		dlh := messaging.NewO5MessageDeadLetterHandler(runtime.sender, srcConfig)
+
+		worker, err := amqp.NewWorker(envConfig.AMQPConfig, messaging.NewReplyFilter(router, srcConfig), dlh)
 		if err != nil {
 			return nil, fmt.Errorf("creating amqp publisher: %w", err)
 		}
-		runtime.sender = publisher
+		worker.SetReadiness(runtime.health)
 
+		runtime.queueWorker = worker
 	}
-	if envConfig.AMQPConfig.Queue != "" {
-		if envConfig.AMQPConfig.URI == "" {
-			return nil, fmt.Errorf("AMQP_QUEUE set but AMQP_URI is empty")
+
+	if envConfig.KafkaConfig.GroupID != "" {
+		if len(envConfig.KafkaConfig.Brokers) == 0 {
+			return nil, fmt.Errorf("KAFKA_GROUP_ID set but KAFKA_BROKERS is empty")
 		}
 
 		router := messaging.NewRouter()
@@ -107,14 +236,38 @@ func FromConfig(ctx context.Context, envConfig Config, awsConfig AWSProvider) (*
 
 		dlh := messaging.NewO5MessageDeadLetterHandler(runtime.sender, srcConfig)
 
-		worker, err := amqp.NewWorker(envConfig.AMQPConfig, router, dlh)
+		worker, err := kafka.NewWorker(envConfig.KafkaConfig, messaging.NewReplyFilter(router, srcConfig), dlh)
 		if err != nil {
-			return nil, fmt.Errorf("creating amqp publisher: %w", err)
+			return nil, fmt.Errorf("creating kafka worker: %w", err)
 		}
+		worker.SetReadiness(runtime.health)
 
 		runtime.queueWorker = worker
 	}
 
+	if envConfig.NATSConfig.Consumer != "" {
+		if envConfig.NATSConfig.URL == "" {
+			return nil, fmt.Errorf("NATS_CONSUMER set but NATS_URL is empty")
+		}
+
+		router := messaging.NewRouter()
+		runtime.queueRouter = router
+
+		dlh := messaging.NewO5MessageDeadLetterHandler(runtime.sender, srcConfig)
+
+		worker, err := nats.NewWorker(envConfig.NATSConfig, messaging.NewReplyFilter(router, srcConfig), dlh)
+		if err != nil {
+			return nil, fmt.Errorf("creating nats worker: %w", err)
+		}
+		worker.SetReadiness(runtime.health)
+
+		runtime.queueWorker = worker
+	}
+
+	if decrypter != nil && runtime.queueRouter != nil {
+		runtime.queueRouter.SetDecrypter(decrypter)
+	}
+
 	pgConfigs := pgclient.NewConnectorSet(awsConfig, pgclient.EnvProvider{})
 
 	// Listen to a Postgres outbox table
@@ -123,7 +276,9 @@ func FromConfig(ctx context.Context, envConfig Config, awsConfig AWSProvider) (*
 			return nil, fmt.Errorf("outbox requires a sender (set EVENTBRIDGE_ARN)")
 		}
 
-		a, err := pgoutbox.NewApps(envConfig.OutboxConfig, runtime.msgConverter, runtime.sender, pgConfigs)
+		dlh := messaging.NewO5MessageDeadLetterHandler(runtime.sender, srcConfig)
+
+		a, err := pgoutbox.NewApps(envConfig.OutboxConfig, runtime.msgConverter, runtime.sender, dlh, pgConfigs)
 		if err != nil {
 			return nil, fmt.Errorf("creating outbox listener: %w", err)
 		}
@@ -147,18 +302,73 @@ func FromConfig(ctx context.Context, envConfig Config, awsConfig AWSProvider) (*
 			return nil, fmt.Errorf("bridge requires a sender")
 		}
 
-		runtime.adapter = bridge.NewApp(envConfig.BridgeConfig.AdapterAddr, runtime.sender, runtime.msgConverter)
+		adapter, err := bridge.NewApp(envConfig.BridgeConfig, runtime.sender, runtime.msgConverter)
+		if err != nil {
+			return nil, fmt.Errorf("creating bridge: %w", err)
+		}
+
+		runtime.adapter = adapter
 	}
 
 	// Serve a public HTTP server
 	if envConfig.ServerConfig.PublicAddr != "" {
-		r, err := httpserver.NewRouter(envConfig.ServerConfig, srcConfig)
+		apiKeys, err := apiKeySource(ctx, envConfig.ServerConfig, awsConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := httpserver.NewRouter(envConfig.ServerConfig, srcConfig, apiKeys)
 		if err != nil {
 			return nil, fmt.Errorf("creating router: %w", err)
 		}
+		r.SetReadiness(runtime.health.Ready)
 
 		runtime.serviceRouter = r
 	}
 
 	return runtime, nil
 }
+
+func apiKeySource(ctx context.Context, config httpserver.ServerConfig, awsConfig AWSProvider) (httpserver.APIKeySource, error) {
+	switch {
+	case config.APIKeysFile != "" && config.APIKeysSecret != "":
+		return nil, fmt.Errorf("only one of API_KEYS_FILE and API_KEYS_SECRET can be set")
+
+	case config.APIKeysFile != "":
+		return httpserver.FileAPIKeySource(config.APIKeysFile), nil
+
+	case config.APIKeysSecret != "":
+		client, err := awsConfig.SecretsManager(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("getting secrets manager client: %w", err)
+		}
+		return &httpserver.SecretAPIKeySource{
+			Client:   client,
+			SecretID: config.APIKeysSecret,
+		}, nil
+
+	default:
+		return nil, nil
+	}
+}
+
+func keyProvider(ctx context.Context, config envelope.EncryptionConfig, awsConfig AWSProvider) (envelope.KeyProvider, error) {
+	switch config.KeyProvider {
+	case envelope.ProviderKMS:
+		client, err := awsConfig.KMS(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("getting kms client: %w", err)
+		}
+		return envelope.NewKMSKeyProvider(client), nil
+
+	case envelope.ProviderStatic:
+		provider, err := envelope.NewStaticKeyProvider(config.StaticKeys)
+		if err != nil {
+			return nil, fmt.Errorf("MESSAGE_ENCRYPTION_STATIC_KEYS: %w", err)
+		}
+		return provider, nil
+
+	default:
+		return nil, fmt.Errorf("unknown MESSAGE_ENCRYPTION_PROVIDER %q, expected kms or static", config.KeyProvider)
+	}
+}
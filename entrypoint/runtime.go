@@ -5,9 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/pentops/j5/lib/proxy"
 	"github.com/pentops/log.go/log"
+	"github.com/pentops/o5-runtime-sidecar/adapters/grpchealth"
 	"github.com/pentops/o5-runtime-sidecar/adapters/grpcreflect"
+	"github.com/pentops/o5-runtime-sidecar/adapters/tracing"
 
 	"github.com/pentops/o5-runtime-sidecar/adapters/msgconvert"
 	"github.com/pentops/o5-runtime-sidecar/apps/bridge"
@@ -18,6 +23,8 @@ import (
 	"github.com/pentops/runner"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 var ErrNothingToDo = errors.New("no services configured")
@@ -37,14 +44,45 @@ type Runtime struct {
 	postgresProxy   *pgproxy.App
 
 	msgConverter *msgconvert.Converter
+	tracing      *tracing.Provider
 
 	reflectionClients []*grpcreflect.ReflectionClient
+	resolver          *grpcreflect.CompositeResolver
 	endpoints         []string
 	endpointWait      chan struct{}
+	health            *grpchealth.Checker
+
+	// appEndpoints and the routes built from them are replaced together
+	routesLock      sync.Mutex
+	appEndpoints    []*appEndpoint
+	refreshInterval time.Duration
+}
+
+// endpointClient is the reflection client of an app endpoint, see
+// grpcreflect.ReflectionClient
+type endpointClient interface {
+	proxy.AppConn
+	messaging.AppLink
+	Name() string
+	Conn() grpc.ClientConnInterface
+	WaitForReconnect(ctx context.Context) error
+	FetchServices(ctx context.Context) ([]protoreflect.ServiceDescriptor, error)
+	Files() *protoregistry.Files
+	RestoreFiles(files *protoregistry.Files)
+}
+
+// appEndpoint is the last set of services fetched from an endpoint
+type appEndpoint struct {
+	client   endpointClient
+	services []protoreflect.ServiceDescriptor
+	digest   string
 }
 
 func NewRuntime() *Runtime {
-	return &Runtime{}
+	return &Runtime{
+		resolver: grpcreflect.NewCompositeResolver(),
+		health:   grpchealth.NewChecker(),
+	}
 }
 
 func (rt *Runtime) Close() error {
@@ -65,6 +103,10 @@ func (rt *Runtime) Run(ctx context.Context) error {
 		runner.WithCancelOnSignals(),
 	)
 
+	if rt.tracing != nil {
+		runGroup.Add("tracing", rt.tracing.Run)
+	}
+
 	didAnything := false
 	if rt.postgresProxy != nil {
 		didAnything = true
@@ -80,6 +122,11 @@ func (rt *Runtime) Run(ctx context.Context) error {
 		return fmt.Errorf("start goroutines: %w", err)
 	}
 
+	// Not ready until each endpoint reports serving
+	for _, endpoint := range rt.endpoints {
+		rt.health.Add(endpoint)
+	}
+
 	rt.endpointWait = make(chan struct{})
 	runGroup.Add("register-endpoints", func(ctx context.Context) error {
 		defer close(rt.endpointWait)
@@ -95,11 +142,12 @@ func (rt *Runtime) Run(ctx context.Context) error {
 			if err := rt.registerEndpoint(ctx, prClient); err != nil {
 				return fmt.Errorf("register endpoint %s: %w", prClient.Name(), err)
 			}
+
+			rt.resolver.AddSource(prClient)
 		}
 
-		if len(rt.reflectionClients) == 1 {
-			// TODO: Handle multiple clients
-			rt.msgConverter.SetReflectionClient(rt.reflectionClients[0])
+		if len(rt.reflectionClients) > 0 {
+			rt.msgConverter.SetReflectionClient(rt.resolver)
 		}
 
 		return nil
@@ -112,12 +160,18 @@ func (rt *Runtime) Run(ctx context.Context) error {
 
 	<-rt.endpointWait
 
+	for _, ep := range rt.appEndpoints {
+		runGroup.Add("watch-"+ep.client.Name(), func(ctx context.Context) error {
+			return rt.watchEndpoint(ctx, ep)
+		})
+		runGroup.Add("health-"+ep.client.Name(), func(ctx context.Context) error {
+			return rt.health.Watch(ctx, ep.client.Name(), ep.client.Conn())
+		})
+	}
+
 	if rt.serviceRouter != nil {
-		// TODO: Metrics
 		didAnything = true
-
 		runGroup.Add("router", rt.serviceRouter.Run)
-
 	}
 
 	if rt.queueWorker != nil {
@@ -147,39 +201,168 @@ func (rt *Runtime) connectEndpoint(endpoint string) (*grpcreflect.ReflectionClie
 	return grpcreflect.NewClient(conn), nil
 }
 
-func (rt *Runtime) registerEndpoint(ctx context.Context, prClient *grpcreflect.ReflectionClient) error {
+func (rt *Runtime) registerEndpoint(ctx context.Context, prClient endpointClient) error {
 	ss, err := prClient.FetchServices(ctx)
 	if err != nil {
 		return fmt.Errorf("fetch: %w", err)
 	}
 
-	for _, s := range ss {
-		name := string(s.FullName())
+	digest, err := grpcreflect.ServicesDigest(ss)
+	if err != nil {
+		return err
+	}
 
-		switch {
-		case strings.HasSuffix(name, "Service"), strings.HasSuffix(name, "Sandbox"):
-			if rt.serviceRouter == nil {
-				return fmt.Errorf("service %s requires a public port", name)
-			}
+	rt.routesLock.Lock()
+	defer rt.routesLock.Unlock()
 
-			if err := rt.serviceRouter.RegisterService(ctx, s, prClient); err != nil {
-				return fmt.Errorf("register service %s: %w", name, err)
-			}
+	rt.appEndpoints = append(rt.appEndpoints, &appEndpoint{
+		client:   prClient,
+		services: ss,
+		digest:   digest,
+	})
+
+	return rt.replaceRoutes(ctx)
+}
+
+// replaceRoutes routes every service of every endpoint to the endpoint which
+// implements it. The caller holds routesLock.
+func (rt *Runtime) replaceRoutes(ctx context.Context) error {
+	serviceEndpoints := map[string]string{}
+	var httpServices []httpserver.ServiceRegistration
+	var topicServices []messaging.ServiceRegistration
+
+	for _, ep := range rt.appEndpoints {
+		for _, s := range ep.services {
+			name := string(s.FullName())
 
-		case strings.HasSuffix(name, "Topic"):
-			if rt.queueRouter == nil {
-				return fmt.Errorf("topic %s requires an SQS URL", name)
+			if other, ok := serviceEndpoints[name]; ok {
+				return fmt.Errorf("service %s is implemented by both %s and %s", name, other, ep.client.Name())
 			}
+			serviceEndpoints[name] = ep.client.Name()
+
+			switch {
+			case strings.HasSuffix(name, "Service"), strings.HasSuffix(name, "Sandbox"):
+				if rt.serviceRouter == nil {
+					return fmt.Errorf("service %s requires a public port", name)
+				}
+				httpServices = append(httpServices, httpserver.ServiceRegistration{Service: s, Invoker: ep.client, Conn: ep.client.Conn()})
+
+			case strings.HasSuffix(name, "Topic"):
+				if rt.queueRouter == nil {
+					return fmt.Errorf("topic %s requires an SQS URL", name)
+				}
+				topicServices = append(topicServices, messaging.ServiceRegistration{Service: s, Invoker: ep.client})
+
+			default:
+				log.WithField(ctx, "service", name).Error("Unknown service type")
+				// but continue
+			}
+		}
+	}
+
+	// Build both before swapping either in, so that a failure leaves the
+	// previous routes in place for both.
+	var serviceRoutes *httpserver.ServiceRoutes
+	if rt.serviceRouter != nil {
+		routes, err := rt.serviceRouter.BuildRoutes(ctx, httpServices)
+		if err != nil {
+			return err
+		}
+		serviceRoutes = routes
+	}
 
-			if err := rt.queueRouter.RegisterService(ctx, s, prClient); err != nil {
-				return fmt.Errorf("register worker %s: %w", name, err)
+	var topicHandlers *messaging.ServiceHandlers
+	if rt.queueRouter != nil {
+		handlers, err := messaging.BuildHandlers(ctx, topicServices)
+		if err != nil {
+			return fmt.Errorf("register workers: %w", err)
+		}
+		topicHandlers = handlers
+	}
+
+	if serviceRoutes != nil {
+		rt.serviceRouter.SetRoutes(serviceRoutes)
+	}
+	if topicHandlers != nil {
+		rt.queueRouter.SetHandlers(topicHandlers)
+	}
+
+	return nil
+}
+
+// watchEndpoint re-fetches the endpoint's services when the app reconnects,
+// e.g. after its container is replaced, and periodically.
+func (rt *Runtime) watchEndpoint(ctx context.Context, ep *appEndpoint) error {
+	ctx = log.WithField(ctx, "endpoint", ep.client.Name())
+
+	reconnected := make(chan struct{}, 1)
+	go func() {
+		for {
+			if err := ep.client.WaitForReconnect(ctx); err != nil {
+				return
 			}
+			select {
+			case reconnected <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	var tick <-chan time.Time
+	if rt.refreshInterval > 0 {
+		ticker := time.NewTicker(rt.refreshInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-reconnected:
+		case <-tick:
+		}
 
-		default:
-			log.WithField(ctx, "service", name).Error("Unknown service type")
-			// but continue
+		if err := rt.refreshEndpoint(ctx, ep); err != nil {
+			// Keep serving the previous routes
+			log.WithError(ctx, err).Error("Failed to refresh endpoint services")
 		}
 	}
+}
+
+func (rt *Runtime) refreshEndpoint(ctx context.Context, ep *appEndpoint) error {
+	// Fetching replaces the client's cached files, which have to match the
+	// routes when the previous ones are kept.
+	files := ep.client.Files()
+	ss, err := ep.client.FetchServices(ctx)
+	if err != nil {
+		ep.client.RestoreFiles(files)
+		return fmt.Errorf("fetch: %w", err)
+	}
+
+	digest, err := grpcreflect.ServicesDigest(ss)
+	if err != nil {
+		ep.client.RestoreFiles(files)
+		return err
+	}
+
+	rt.routesLock.Lock()
+	defer rt.routesLock.Unlock()
+
+	if digest == ep.digest {
+		return nil
+	}
+
+	previous := *ep
+	ep.services = ss
+	ep.digest = digest
+	if err := rt.replaceRoutes(ctx); err != nil {
+		*ep = previous
+		ep.client.RestoreFiles(files)
+		return err
+	}
 
+	rt.resolver.Reset()
+	log.Info(ctx, "App services changed, routes replaced")
 	return nil
 }
@@ -8,8 +8,18 @@ import (
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+	"github.com/pentops/o5-runtime-sidecar/adapters/amqp"
+	"github.com/pentops/o5-runtime-sidecar/adapters/fanout"
+	"github.com/pentops/o5-runtime-sidecar/adapters/kafka"
 	"github.com/pentops/o5-runtime-sidecar/apps/httpserver"
+	"github.com/pentops/o5-runtime-sidecar/apps/queueworker/messaging"
+	"github.com/pentops/o5-runtime-sidecar/testproto/gen/test/v1/test_tpb"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 type TestAWS struct{}
@@ -26,6 +36,14 @@ func (ta TestAWS) EventBridge(ctx context.Context) (EventBridgeAPI, error) {
 	return nil, fmt.Errorf("Test Not Implemented")
 }
 
+func (ta TestAWS) KMS(ctx context.Context) (KMSAPI, error) {
+	return nil, fmt.Errorf("Test Not Implemented")
+}
+
+func (ta TestAWS) SecretsManager(ctx context.Context) (SecretsManagerAPI, error) {
+	return nil, fmt.Errorf("Test Not Implemented")
+}
+
 func (ta TestAWS) Region() string {
 	return "local"
 }
@@ -83,3 +101,185 @@ func TestLoadEverything(t *testing.T) {
 	})
 
 }
+
+func TestExclusiveWorkers(t *testing.T) {
+	_, err := FromConfig(t.Context(), Config{
+		AMQPConfig: amqp.AMQPConfig{
+			URI:   "amqp://localhost",
+			Queue: "app",
+		},
+		KafkaConfig: kafka.KafkaConfig{
+			Brokers: []string{"localhost:9092"},
+			GroupID: "app",
+		},
+	}, TestAWS{})
+	assert.Error(t, err)
+}
+
+func TestMultiplePublishers(t *testing.T) {
+	runtime, err := FromConfig(t.Context(), Config{
+		AMQPConfig: amqp.AMQPConfig{
+			URI: "amqp://localhost",
+		},
+		KafkaConfig: kafka.KafkaConfig{
+			Brokers: []string{"localhost:9092"},
+		},
+		FanoutConfig: fanout.FanoutConfig{
+			PublishTo: []string{"amqp", "kafka"},
+		},
+	}, TestAWS{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.IsType(t, &fanout.FanoutPublisher{}, runtime.sender)
+}
+
+func TestConsumeOnlyTransport(t *testing.T) {
+	runtime, err := FromConfig(t.Context(), Config{
+		AMQPConfig: amqp.AMQPConfig{
+			URI: "amqp://localhost",
+		},
+		KafkaConfig: kafka.KafkaConfig{
+			Brokers: []string{"localhost:9092"},
+			GroupID: "app",
+		},
+	}, TestAWS{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.IsType(t, &amqp.Publisher{}, runtime.sender, "kafka is only consumed from")
+}
+
+// fakeEndpoint serves services from a reflection client. Each fetch caches a
+// new set of files, as the real client does. Methods the runtime shouldn't
+// call panic through the nil embedded client.
+type fakeEndpoint struct {
+	endpointClient
+
+	name     string
+	services []protoreflect.ServiceDescriptor
+	fetchErr error
+	files    *protoregistry.Files
+	invoked  []string
+}
+
+func (fe *fakeEndpoint) Name() string {
+	return fe.name
+}
+
+func (fe *fakeEndpoint) FetchServices(ctx context.Context) ([]protoreflect.ServiceDescriptor, error) {
+	fe.files = &protoregistry.Files{}
+	if fe.fetchErr != nil {
+		return nil, fe.fetchErr
+	}
+	return fe.services, nil
+}
+
+func (fe *fakeEndpoint) Files() *protoregistry.Files {
+	return fe.files
+}
+
+func (fe *fakeEndpoint) RestoreFiles(files *protoregistry.Files) {
+	fe.files = files
+}
+
+func (fe *fakeEndpoint) Invoke(ctx context.Context, method string, req any, res any, opts ...grpc.CallOption) error {
+	fe.invoked = append(fe.invoked, method)
+	return nil
+}
+
+func testTopics(names ...string) []protoreflect.ServiceDescriptor {
+	services := make([]protoreflect.ServiceDescriptor, 0, len(names))
+	for _, name := range names {
+		services = append(services, test_tpb.File_test_v1_topic_test_p_j5s_proto.Services().ByName(protoreflect.Name(name)))
+	}
+	return services
+}
+
+// routed reports whether the runtime routes the topic method to an app.
+func routed(t *testing.T, rt *Runtime, service, method string) bool {
+	t.Helper()
+	err := rt.queueRouter.HandleMessage(t.Context(), &messaging_pb.Message{
+		GrpcService: service,
+		GrpcMethod:  method,
+		Body: &messaging_pb.Any{
+			Encoding: messaging_pb.WireEncoding_RAW,
+		},
+	})
+	var notMatched messaging.ErrNoHandlerMatched
+	if errors.As(err, &notMatched) {
+		return false
+	}
+	require.NoError(t, err)
+	return true
+}
+
+func testRefreshRuntime(t *testing.T, endpoints ...*fakeEndpoint) *Runtime {
+	t.Helper()
+	rt := NewRuntime()
+	rt.queueRouter = messaging.NewRouter()
+	for _, ep := range endpoints {
+		require.NoError(t, rt.registerEndpoint(t.Context(), ep))
+	}
+	return rt
+}
+
+func TestRefreshEndpointReplacesRoutes(t *testing.T) {
+	app := &fakeEndpoint{name: "app", services: testTopics("TestPublishTopic")}
+	rt := testRefreshRuntime(t, app)
+	ep := rt.appEndpoints[0]
+
+	assert.True(t, routed(t, rt, "test.v1.topic.TestPublishTopic", "Foo"))
+	assert.False(t, routed(t, rt, "test.v1.topic.TestReqResRequestTopic", "TestReqResRequest"))
+
+	app.services = testTopics("TestPublishTopic", "TestReqResRequestTopic")
+	require.NoError(t, rt.refreshEndpoint(t.Context(), ep))
+
+	assert.True(t, routed(t, rt, "test.v1.topic.TestReqResRequestTopic", "TestReqResRequest"))
+	assert.Len(t, ep.services, 2)
+	assert.Equal(t, []string{
+		"/test.v1.topic.TestPublishTopic/Foo",
+		"/test.v1.topic.TestReqResRequestTopic/TestReqResRequest",
+	}, app.invoked)
+}
+
+func TestRefreshEndpointUnchanged(t *testing.T) {
+	app := &fakeEndpoint{name: "app", services: testTopics("TestPublishTopic")}
+	rt := testRefreshRuntime(t, app)
+	ep := rt.appEndpoints[0]
+
+	// Replacing the routes would now fail, so only a no-op succeeds
+	previous := *ep
+	rt.appEndpoints = append(rt.appEndpoints, &appEndpoint{
+		client:   &fakeEndpoint{name: "other"},
+		services: testTopics("TestPublishTopic"),
+	})
+
+	require.NoError(t, rt.refreshEndpoint(t.Context(), ep))
+	assert.Equal(t, previous, *ep)
+	assert.True(t, routed(t, rt, "test.v1.topic.TestPublishTopic", "Foo"))
+}
+
+func TestRefreshEndpointRollsBack(t *testing.T) {
+	app := &fakeEndpoint{name: "app", services: testTopics("TestPublishTopic")}
+	other := &fakeEndpoint{name: "other", services: testTopics("TestReqResRequestTopic")}
+	rt := testRefreshRuntime(t, app, other)
+	ep := rt.appEndpoints[0]
+	previous := *ep
+	files := app.files
+
+	// Both endpoints now implement the same service
+	app.services = testTopics("TestPublishTopic", "TestReqResRequestTopic")
+	assert.Error(t, rt.refreshEndpoint(t.Context(), ep))
+
+	assert.Equal(t, previous, *ep)
+	assert.Same(t, files, app.files, "cached files are restored")
+	assert.True(t, routed(t, rt, "test.v1.topic.TestPublishTopic", "Foo"))
+	assert.True(t, routed(t, rt, "test.v1.topic.TestReqResRequestTopic", "TestReqResRequest"))
+	assert.Equal(t, []string{"/test.v1.topic.TestPublishTopic/Foo"}, app.invoked, "previous routes kept")
+	assert.Equal(t, []string{"/test.v1.topic.TestReqResRequestTopic/TestReqResRequest"}, other.invoked)
+
+	app.fetchErr = errors.New("unavailable")
+	assert.Error(t, rt.refreshEndpoint(t.Context(), ep))
+	assert.Same(t, files, app.files)
+}
@@ -0,0 +1,123 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: o5/sidecar/v1/service/bridge.proto
+
+package sidecar_spb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	MessageBridgeBatchService_SendBatch_FullMethodName = "/o5.sidecar.v1.service.MessageBridgeBatchService/SendBatch"
+)
+
+// MessageBridgeBatchServiceClient is the client API for MessageBridgeBatchService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// MessageBridgeBatchService is served alongside o5.messaging.v1.topic.MessageBridgeTopic
+// on the sidecar's ADAPTER_ADDR.
+type MessageBridgeBatchServiceClient interface {
+	// SendBatch converts and publishes the messages in as few publisher calls
+	// as possible. A failure to publish one message does not fail the call,
+	// check the per-message results.
+	SendBatch(ctx context.Context, in *SendBatchRequest, opts ...grpc.CallOption) (*SendBatchResponse, error)
+}
+
+type messageBridgeBatchServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMessageBridgeBatchServiceClient(cc grpc.ClientConnInterface) MessageBridgeBatchServiceClient {
+	return &messageBridgeBatchServiceClient{cc}
+}
+
+func (c *messageBridgeBatchServiceClient) SendBatch(ctx context.Context, in *SendBatchRequest, opts ...grpc.CallOption) (*SendBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SendBatchResponse)
+	err := c.cc.Invoke(ctx, MessageBridgeBatchService_SendBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MessageBridgeBatchServiceServer is the server API for MessageBridgeBatchService service.
+// All implementations must embed UnimplementedMessageBridgeBatchServiceServer
+// for forward compatibility
+//
+// MessageBridgeBatchService is served alongside o5.messaging.v1.topic.MessageBridgeTopic
+// on the sidecar's ADAPTER_ADDR.
+type MessageBridgeBatchServiceServer interface {
+	// SendBatch converts and publishes the messages in as few publisher calls
+	// as possible. A failure to publish one message does not fail the call,
+	// check the per-message results.
+	SendBatch(context.Context, *SendBatchRequest) (*SendBatchResponse, error)
+	mustEmbedUnimplementedMessageBridgeBatchServiceServer()
+}
+
+// UnimplementedMessageBridgeBatchServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedMessageBridgeBatchServiceServer struct {
+}
+
+func (UnimplementedMessageBridgeBatchServiceServer) SendBatch(context.Context, *SendBatchRequest) (*SendBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendBatch not implemented")
+}
+func (UnimplementedMessageBridgeBatchServiceServer) mustEmbedUnimplementedMessageBridgeBatchServiceServer() {
+}
+
+// UnsafeMessageBridgeBatchServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MessageBridgeBatchServiceServer will
+// result in compilation errors.
+type UnsafeMessageBridgeBatchServiceServer interface {
+	mustEmbedUnimplementedMessageBridgeBatchServiceServer()
+}
+
+func RegisterMessageBridgeBatchServiceServer(s grpc.ServiceRegistrar, srv MessageBridgeBatchServiceServer) {
+	s.RegisterService(&MessageBridgeBatchService_ServiceDesc, srv)
+}
+
+func _MessageBridgeBatchService_SendBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessageBridgeBatchServiceServer).SendBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MessageBridgeBatchService_SendBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessageBridgeBatchServiceServer).SendBatch(ctx, req.(*SendBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MessageBridgeBatchService_ServiceDesc is the grpc.ServiceDesc for MessageBridgeBatchService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MessageBridgeBatchService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "o5.sidecar.v1.service.MessageBridgeBatchService",
+	HandlerType: (*MessageBridgeBatchServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SendBatch",
+			Handler:    _MessageBridgeBatchService_SendBatch_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "o5/sidecar/v1/service/bridge.proto",
+}
@@ -1,7 +1,40 @@
 package sidecar
 
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pentops/o5-messaging/gen/o5/messaging/v1/messaging_pb"
+)
+
 type AppInfo struct {
 	SourceApp      string
 	SourceEnv      string
 	SidecarVersion string
 }
+
+// ReplyTo is the address set on requests sent by this app, replies to those
+// requests are routed back to it.
+func (ai AppInfo) ReplyTo() string {
+	return fmt.Sprintf("%s/%s", ai.SourceEnv, ai.SourceApp)
+}
+
+// ParseReplyTo splits a reply-to address in the form <env>/<app>.
+func ParseReplyTo(replyTo string) (env string, app string, ok bool) {
+	env, app, ok = strings.Cut(replyTo, "/")
+	if !ok || env == "" || app == "" || strings.Contains(app, "/") {
+		return "", "", false
+	}
+	return env, app, true
+}
+
+// ReplyDestination returns the env and app which a reply message is addressed
+// to. ok is false for messages which are not replies, or which have no valid
+// reply-to address.
+func ReplyDestination(msg *messaging_pb.Message) (env string, app string, ok bool) {
+	reply := msg.GetReply()
+	if reply == nil {
+		return "", "", false
+	}
+	return ParseReplyTo(reply.ReplyTo)
+}